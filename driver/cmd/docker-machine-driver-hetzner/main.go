@@ -12,6 +12,14 @@ import (
 var version = "dev"
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "resize" {
+		if err := runResize(os.Args[2:]); err != nil {
+			fmt.Fprintf(os.Stderr, "resize: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	versionFlag := flag.Bool("version", false, "Print version and exit")
 	flag.Parse()
 
@@ -22,3 +30,39 @@ func main() {
 
 	plugin.RegisterDriver(driver.NewDriver("", "", version))
 }
+
+// runResize implements the "resize" subcommand:
+//
+//	docker-machine-driver-hetzner resize --machine-config <config.json> --server-type <type> [--upgrade-disk]
+//
+// The machine config is the rancher-machine host config; the new server type
+// is written back to it so later driver invocations see the current type.
+func runResize(args []string) error {
+	fs := flag.NewFlagSet("resize", flag.ContinueOnError)
+	configPath := fs.String("machine-config", "", "Path to the rancher-machine host config.json")
+	serverType := fs.String("server-type", "", "Target Hetzner Cloud server type")
+	upgradeDisk := fs.Bool("upgrade-disk", false, "Also upgrade the disk (prevents downgrading later)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *configPath == "" || *serverType == "" {
+		fs.Usage()
+		return fmt.Errorf("--machine-config and --server-type are required")
+	}
+
+	cfg, err := driver.LoadMachineConfig(*configPath, version)
+	if err != nil {
+		return err
+	}
+
+	previous := cfg.Driver.ServerType
+	resizeErr := cfg.Driver.Resize(*serverType, *upgradeDisk)
+	// Persist the new type even when powering on afterwards failed — the
+	// change itself has been applied in Hetzner at that point.
+	if cfg.Driver.ServerType != previous {
+		if err := cfg.Save(); err != nil {
+			return err
+		}
+	}
+	return resizeErr
+}
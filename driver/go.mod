@@ -5,6 +5,7 @@ go 1.24.0
 require (
 	github.com/hetznercloud/hcloud-go/v2 v2.36.0
 	github.com/rancher/machine v0.15.0-rancher134
+	golang.org/x/crypto v0.47.0
 )
 
 require (
@@ -18,7 +19,6 @@ require (
 	github.com/prometheus/common v0.66.1 // indirect
 	github.com/prometheus/procfs v0.16.1 // indirect
 	go.yaml.in/yaml/v2 v2.4.2 // indirect
-	golang.org/x/crypto v0.47.0 // indirect
 	golang.org/x/net v0.49.0 // indirect
 	golang.org/x/sys v0.40.0 // indirect
 	golang.org/x/term v0.39.0 // indirect
@@ -0,0 +1,265 @@
+package driver
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/rancher/machine/libmachine/log"
+	gossh "golang.org/x/crypto/ssh"
+)
+
+const defaultBastionUser = "root"
+
+var errBastionUnreachable = errors.New("bastion host not reachable")
+
+// bastionAddr returns the bastion's host:port; the port defaults to 22.
+func (d *Driver) bastionAddr() string {
+	if _, _, err := net.SplitHostPort(d.BastionHost); err == nil {
+		return d.BastionHost
+	}
+	return net.JoinHostPort(d.BastionHost, strconv.Itoa(defaultSSHPort))
+}
+
+// bastionClientConfig returns the client configuration for the bastion: the
+// --hetzner-bastion-key, or the --hetzner-ssh-private-key when none is set,
+// as checkBastion requires. The bastion is long-lived, so its host key is
+// checked against --hetzner-bastion-host-key.
+func (d *Driver) bastionClientConfig() (*gossh.ClientConfig, error) {
+	var signer gossh.Signer
+	if d.BastionKey != "" {
+		_, s, err := parseSSHPrivateKey("hetzner-bastion-key", d.BastionKey)
+		if err != nil {
+			return nil, err
+		}
+		signer = s
+	} else {
+		_, s, err := d.loadSSHPrivateKey()
+		if err != nil {
+			return nil, err
+		}
+		signer = s
+	}
+	hostKey, err := d.bastionHostKey()
+	if err != nil {
+		return nil, err
+	}
+	user := d.BastionUser
+	if user == "" {
+		user = defaultBastionUser
+	}
+	return &gossh.ClientConfig{
+		User:            user,
+		Auth:            []gossh.AuthMethod{gossh.PublicKeys(signer)},
+		HostKeyCallback: gossh.FixedHostKey(hostKey),
+		Timeout:         d.sshWaitTimeout(),
+	}, nil
+}
+
+// bastionHostKey parses --hetzner-bastion-host-key.
+func (d *Driver) bastionHostKey() (gossh.PublicKey, error) {
+	if d.BastionHostKey == "" {
+		return nil, fmt.Errorf("--hetzner-bastion-host-key is required with --hetzner-bastion-host")
+	}
+	key, _, _, _, err := gossh.ParseAuthorizedKey([]byte(d.BastionHostKey))
+	if err != nil {
+		return nil, fmt.Errorf("invalid hetzner-bastion-host-key: %w", err)
+	}
+	return key, nil
+}
+
+// dialViaBastion opens a TCP connection to addr through a new SSH connection
+// to the bastion, which is closed with it.
+func (d *Driver) dialViaBastion(addr string) (net.Conn, error) {
+	config, err := d.bastionClientConfig()
+	if err != nil {
+		return nil, err
+	}
+	bastion := d.bastionAddr()
+	tcp, err := net.DialTimeout("tcp", bastion, config.Timeout)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s: %v", errBastionUnreachable, bastion, err)
+	}
+	if err := tcp.SetDeadline(time.Now().Add(config.Timeout)); err != nil {
+		tcp.Close()
+		return nil, err
+	}
+	c, chans, reqs, err := gossh.NewClientConn(tcp, bastion, config)
+	if err != nil {
+		tcp.Close()
+		return nil, fmt.Errorf("%w: %s: %v", errBastionUnreachable, bastion, err)
+	}
+	if err := tcp.SetDeadline(time.Time{}); err != nil {
+		c.Close()
+		return nil, err
+	}
+	client := gossh.NewClient(c, chans, reqs)
+	conn, err := client.Dial("tcp", addr)
+	if err != nil {
+		client.Close()
+		return nil, fmt.Errorf("%w: through bastion %s: %v", errSSHPortClosed, bastion, err)
+	}
+	return &bastionConn{Conn: conn, client: client, tcp: tcp}, nil
+}
+
+// bastionConn is a connection forwarded by the bastion. SSH channels do not
+// support deadlines, so they are set on the bastion connection, which
+// carries only this channel.
+type bastionConn struct {
+	net.Conn
+	client *gossh.Client
+	tcp    net.Conn
+}
+
+func (c *bastionConn) Close() error {
+	c.Conn.Close()
+	return c.client.Close()
+}
+
+func (c *bastionConn) SetDeadline(t time.Time) error      { return c.tcp.SetDeadline(t) }
+func (c *bastionConn) SetReadDeadline(t time.Time) error  { return c.tcp.SetReadDeadline(t) }
+func (c *bastionConn) SetWriteDeadline(t time.Time) error { return c.tcp.SetWriteDeadline(t) }
+
+// sshTunnelPort returns the local port of the tunnel to the node's sshd
+// through the bastion, starting it on first use. rancher-machine's SSH client
+// cannot use a jump host, so GetSSHHostname and GetSSHPort point it at the
+// tunnel, which stays open until closeSSHTunnel.
+func (d *Driver) sshTunnelPort() (int, error) {
+	d.tunnelMu.Lock()
+	defer d.tunnelMu.Unlock()
+	if d.tunnel != nil {
+		return d.tunnel.port(), nil
+	}
+
+	ip, err := d.GetIP()
+	if err != nil {
+		return 0, err
+	}
+	target := net.JoinHostPort(ip, strconv.Itoa(d.sshPort()))
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return 0, fmt.Errorf("failed to start SSH tunnel: %w", err)
+	}
+	d.tunnel = &sshTunnel{Listener: listener, target: target, dial: d.dialViaBastion, conns: make(map[net.Conn]struct{})}
+	go d.tunnel.serve()
+	log.Infof("Tunnelling SSH to %s through bastion %s on %s", target, d.bastionAddr(), listener.Addr())
+	return d.tunnel.port(), nil
+}
+
+// closeSSHTunnel stops the tunnel through the bastion, if one is open, and
+// drops the connections still forwarded by it.
+func (d *Driver) closeSSHTunnel() {
+	d.tunnelMu.Lock()
+	defer d.tunnelMu.Unlock()
+	if d.tunnel == nil {
+		return
+	}
+	if err := d.tunnel.Close(); err != nil {
+		log.Debugf("Closing SSH tunnel: %v", err)
+	}
+	d.tunnel = nil
+}
+
+// sshTunnel forwards local connections to target through the bastion. Every
+// connection has its own bastion client, which is closed with it.
+type sshTunnel struct {
+	net.Listener
+	target string
+	dial   func(addr string) (net.Conn, error)
+
+	mu     sync.Mutex
+	closed bool
+	conns  map[net.Conn]struct{} // forwarded connections still open
+}
+
+func (t *sshTunnel) port() int {
+	return t.Addr().(*net.TCPAddr).Port
+}
+
+func (t *sshTunnel) serve() {
+	for {
+		local, err := t.Accept()
+		if err != nil {
+			return
+		}
+		go t.forward(local)
+	}
+}
+
+func (t *sshTunnel) forward(local net.Conn) {
+	defer local.Close()
+	remote, err := t.dial(t.target)
+	if err != nil {
+		log.Warnf("SSH tunnel to %s: %v", t.target, err)
+		return
+	}
+	defer remote.Close()
+	if !t.track(remote) {
+		return
+	}
+	defer t.untrack(remote)
+
+	done := make(chan struct{}, 2)
+	go func() {
+		io.Copy(remote, local)
+		done <- struct{}{}
+	}()
+	go func() {
+		io.Copy(local, remote)
+		done <- struct{}{}
+	}()
+	<-done
+}
+
+// track records an open forwarded connection; it reports false once the
+// tunnel is closed.
+func (t *sshTunnel) track(conn net.Conn) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.closed {
+		return false
+	}
+	t.conns[conn] = struct{}{}
+	return true
+}
+
+func (t *sshTunnel) untrack(conn net.Conn) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.conns, conn)
+}
+
+// Close stops accepting connections and closes the forwarded ones.
+func (t *sshTunnel) Close() error {
+	t.mu.Lock()
+	t.closed = true
+	for conn := range t.conns {
+		conn.Close()
+	}
+	t.mu.Unlock()
+	return t.Listener.Close()
+}
+
+// checkBastion verifies the bastion settings before the server is created:
+// the bastion needs a key it accepts, which the generated machine key cannot
+// be, and a pinned host key.
+func (d *Driver) checkBastion() error {
+	if d.BastionHost == "" {
+		return nil
+	}
+	if d.BastionKey == "" && d.SSHPrivateKey == "" {
+		return fmt.Errorf("--hetzner-bastion-host requires --hetzner-bastion-key unless --hetzner-ssh-private-key is set; " +
+			"the generated machine key is not authorized on the bastion")
+	}
+	if d.BastionKey != "" {
+		if _, _, err := parseSSHPrivateKey("hetzner-bastion-key", d.BastionKey); err != nil {
+			return err
+		}
+	}
+	_, err := d.bastionHostKey()
+	return err
+}
@@ -0,0 +1,185 @@
+package driver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"time"
+
+	"github.com/hetznercloud/hcloud-go/v2/hcloud"
+	"github.com/rancher/machine/libmachine/log"
+)
+
+// ErrResourceRetired is wrapped by every PreCreateCheck failure caused by a
+// server type that Hetzner no longer offers. Its text prefixes the error
+// message, so the UI extension and log scrapers can match on it.
+var ErrResourceRetired = errors.New("hetzner resource retired")
+
+// serverTypeFamilyRe extracts the family prefix of a server type name
+// (e.g. "cx" from "cx22", "cpx" from "cpx31", "ccx" from "ccx63").
+var serverTypeFamilyRe = regexp.MustCompile(`^[a-z]+`)
+
+// serverTypeDeprecation returns the deprecation info of the server type for
+// the given location. Location-specific deprecations take precedence over the
+// global (legacy) deprecation field.
+func serverTypeDeprecation(st *hcloud.ServerType, location string) *hcloud.DeprecationInfo {
+	for _, loc := range st.Locations {
+		if loc.Location != nil && loc.Location.Name == location && loc.IsDeprecated() {
+			return loc.Deprecation
+		}
+	}
+	if st.IsDeprecated() {
+		return st.Deprecation
+	}
+	return nil
+}
+
+// isRetired reports whether a deprecated resource is already past its
+// unavailable-after date.
+func isRetired(dep *hcloud.DeprecationInfo, now time.Time) bool {
+	return dep != nil && !dep.UnavailableAfter.IsZero() && now.After(dep.UnavailableAfter)
+}
+
+// checkServerTypeDeprecation warns when the selected server type is
+// deprecated in ServerLocation. With ReplaceDeprecatedServerType enabled it
+// switches to ServerTypeSuccessor or, if unset, the next-larger type of the
+// same family. A type past its unavailable-after date without a replacement
+// is a hard error wrapping ErrResourceRetired.
+func (d *Driver) checkServerTypeDeprecation(ctx context.Context, serverType *hcloud.ServerType) (*hcloud.ServerType, error) {
+	dep := serverTypeDeprecation(serverType, d.ServerLocation)
+	if dep == nil {
+		return serverType, nil
+	}
+
+	retired := isRetired(dep, time.Now())
+	log.Warnf("Warning: server type %q is deprecated in %s (announced %s, unavailable after %s)",
+		serverType.Name, d.ServerLocation,
+		dep.Announced.Format("2006-01-02"), dep.UnavailableAfter.Format("2006-01-02"))
+
+	if !d.ReplaceDeprecatedServerType {
+		if retired {
+			return nil, fmt.Errorf("%w: server type %q is unavailable in %s since %s; "+
+				"choose a current server type or enable --hetzner-replace-deprecated-server-type",
+				ErrResourceRetired, serverType.Name, d.ServerLocation, dep.UnavailableAfter.Format("2006-01-02"))
+		}
+		return serverType, nil
+	}
+
+	replacement, err := d.findServerTypeReplacement(ctx, serverType)
+	if err != nil {
+		if retired {
+			return nil, fmt.Errorf("%w: server type %q is unavailable in %s since %s and no replacement was found: %v",
+				ErrResourceRetired, serverType.Name, d.ServerLocation, dep.UnavailableAfter.Format("2006-01-02"), err)
+		}
+		log.Warnf("Warning: no replacement for deprecated server type %q, keeping it: %v", serverType.Name, err)
+		return serverType, nil
+	}
+
+	log.Infof("Replacing deprecated server type %q with %q", serverType.Name, replacement.Name)
+	d.ServerType = replacement.Name
+	return replacement, nil
+}
+
+// replaceMissingServerType handles a server type that the API no longer
+// lists at all. Only a configured successor can be used here, since the
+// original specs are unknown.
+func (d *Driver) replaceMissingServerType(ctx context.Context) (*hcloud.ServerType, error) {
+	if !d.ReplaceDeprecatedServerType || d.ServerTypeSuccessor == "" {
+		return nil, fmt.Errorf("%w: server type %q not found; it may have been retired — "+
+			"choose a current server type or set --hetzner-server-type-successor",
+			ErrResourceRetired, d.ServerType)
+	}
+
+	successor, err := d.resolveSuccessorServerType(ctx, "")
+	if err != nil {
+		return nil, fmt.Errorf("%w: server type %q not found and successor is unusable: %v",
+			ErrResourceRetired, d.ServerType, err)
+	}
+	log.Infof("Server type %q not found, using successor %q", d.ServerType, successor.Name)
+	d.ServerType = successor.Name
+	return successor, nil
+}
+
+// findServerTypeReplacement returns the configured successor, or the
+// smallest non-deprecated type of the same family and architecture whose
+// cores, memory and disk are all at least those of the deprecated type.
+func (d *Driver) findServerTypeReplacement(ctx context.Context, old *hcloud.ServerType) (*hcloud.ServerType, error) {
+	if d.ServerTypeSuccessor != "" {
+		return d.resolveSuccessorServerType(ctx, old.Architecture)
+	}
+
+	family := serverTypeFamilyRe.FindString(old.Name)
+	if family == "" {
+		return nil, fmt.Errorf("cannot determine family of server type %q", old.Name)
+	}
+
+	all, err := d.getClient().ServerType.All(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list server types: %w", err)
+	}
+
+	var candidates []*hcloud.ServerType
+	for _, st := range all {
+		if st.Name == old.Name || serverTypeFamilyRe.FindString(st.Name) != family {
+			continue
+		}
+		if st.Architecture != old.Architecture || serverTypeDeprecation(st, d.ServerLocation) != nil {
+			continue
+		}
+		if st.Cores < old.Cores || st.Memory < old.Memory || st.Disk < old.Disk {
+			continue
+		}
+		candidates = append(candidates, st)
+	}
+	if len(candidates) == 0 {
+		return nil, fmt.Errorf("no current %s* server type with at least %d cores, %.0f GB memory and %d GB disk",
+			family, old.Cores, old.Memory, old.Disk)
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		a, b := candidates[i], candidates[j]
+		if a.Cores != b.Cores {
+			return a.Cores < b.Cores
+		}
+		if a.Memory != b.Memory {
+			return a.Memory < b.Memory
+		}
+		if a.Disk != b.Disk {
+			return a.Disk < b.Disk
+		}
+		return a.Name < b.Name
+	})
+	return candidates[0], nil
+}
+
+// resolveSuccessorServerType looks up ServerTypeSuccessor and checks that it
+// is usable in ServerLocation. When arch is non-empty the successor must
+// match it, so the configured image keeps working.
+func (d *Driver) resolveSuccessorServerType(ctx context.Context, arch hcloud.Architecture) (*hcloud.ServerType, error) {
+	successor, _, err := d.getClient().ServerType.GetByName(ctx, d.ServerTypeSuccessor)
+	if err != nil {
+		return nil, fmt.Errorf("invalid successor server type %q: %w", d.ServerTypeSuccessor, err)
+	}
+	if successor == nil {
+		return nil, fmt.Errorf("successor server type %q not found", d.ServerTypeSuccessor)
+	}
+	if serverTypeDeprecation(successor, d.ServerLocation) != nil {
+		return nil, fmt.Errorf("successor server type %q is itself deprecated in %s", successor.Name, d.ServerLocation)
+	}
+	if arch != "" && successor.Architecture != arch {
+		return nil, fmt.Errorf("successor server type %q has architecture %s, expected %s",
+			successor.Name, successor.Architecture, arch)
+	}
+	return successor, nil
+}
+
+// warnImageDeprecation logs a warning when the resolved image is deprecated.
+// Hetzner keeps deprecated images bootable for a while, so this never fails.
+func warnImageDeprecation(ref string, image *hcloud.Image) {
+	if image.IsDeprecated() {
+		log.Warnf("Warning: image %q (ID=%d) is deprecated since %s and may become unavailable; "+
+			"switch to a current image", ref, image.ID, image.Deprecated.Format("2006-01-02"))
+	}
+}
@@ -0,0 +1,175 @@
+package driver
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hetznercloud/hcloud-go/v2/hcloud"
+	"github.com/hetznercloud/hcloud-go/v2/hcloud/schema"
+)
+
+// deprecatedServerType returns a server type deprecated in fsn1 with the
+// given unavailable-after date.
+func deprecatedServerType(id int64, name string, cores int, memory float32, disk int, unavailableAfter time.Time) schema.ServerType {
+	return schema.ServerType{
+		ID:           id,
+		Name:         name,
+		Cores:        cores,
+		Memory:       memory,
+		Disk:         disk,
+		Architecture: "x86",
+		Locations: []schema.ServerTypeLocation{{
+			ID:   1,
+			Name: "fsn1",
+			DeprecatableResource: schema.DeprecatableResource{
+				Deprecation: &schema.DeprecationInfo{
+					Announced:        unavailableAfter.Add(-90 * 24 * time.Hour),
+					UnavailableAfter: unavailableAfter,
+				},
+			},
+		}},
+	}
+}
+
+// simpleServerType returns a current x86 server type.
+func simpleServerType(id int64, name string, cores int, memory float32, disk int) schema.ServerType {
+	return schema.ServerType{ID: id, Name: name, Cores: cores, Memory: memory, Disk: disk, Architecture: "x86"}
+}
+
+// deprecationMux serves the given server types by name (or all of them) and
+// the standard location and image.
+func deprecationMux(types ...schema.ServerType) *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/server_types", func(w http.ResponseWriter, r *http.Request) {
+		name := r.URL.Query().Get("name")
+		var resp []schema.ServerType
+		for _, st := range types {
+			if name == "" || st.Name == name {
+				resp = append(resp, st)
+			}
+		}
+		jsonResponse(w, http.StatusOK, schema.ServerTypeListResponse{ServerTypes: resp})
+	})
+	mux.HandleFunc("/locations", func(w http.ResponseWriter, r *http.Request) {
+		jsonResponse(w, http.StatusOK, schema.LocationListResponse{
+			Locations: []schema.Location{standardLocation()},
+		})
+	})
+	mux.HandleFunc("/images", func(w http.ResponseWriter, r *http.Request) {
+		jsonResponse(w, http.StatusOK, schema.ImageListResponse{
+			Images: []schema.Image{standardImage()},
+		})
+	})
+	return mux
+}
+
+func TestPreCreateCheck_DeprecatedServerType_WarnsOnly(t *testing.T) {
+	future := time.Now().Add(30 * 24 * time.Hour)
+	d, _ := newTestDriver(t, deprecationMux(deprecatedServerType(1, "cx22", 2, 4, 40, future)))
+	d.ServerType = "cx22"
+
+	if err := d.PreCreateCheck(); err != nil {
+		t.Fatalf("PreCreateCheck() error: %v", err)
+	}
+	if d.ServerType != "cx22" {
+		t.Errorf("ServerType = %q, want unchanged cx22", d.ServerType)
+	}
+}
+
+func TestPreCreateCheck_RetiredServerType_Fails(t *testing.T) {
+	past := time.Now().Add(-24 * time.Hour)
+	d, _ := newTestDriver(t, deprecationMux(deprecatedServerType(1, "cx22", 2, 4, 40, past)))
+	d.ServerType = "cx22"
+
+	err := d.PreCreateCheck()
+	if err == nil {
+		t.Fatal("expected error for retired server type")
+	}
+	if !errors.Is(err, ErrResourceRetired) {
+		t.Errorf("error = %v, want it to wrap ErrResourceRetired", err)
+	}
+	if !strings.HasPrefix(err.Error(), ErrResourceRetired.Error()) {
+		t.Errorf("error = %q, want prefix %q", err, ErrResourceRetired)
+	}
+}
+
+func TestPreCreateCheck_RetiredServerType_ReplacedBySameFamily(t *testing.T) {
+	past := time.Now().Add(-24 * time.Hour)
+	d, _ := newTestDriver(t, deprecationMux(
+		deprecatedServerType(1, "cx22", 2, 4, 40, past),
+		simpleServerType(2, "cx43", 8, 16, 160),
+		simpleServerType(3, "cx23", 2, 4, 40),
+		simpleServerType(4, "cx33", 4, 8, 80),
+		simpleServerType(5, "cpx11", 2, 2, 40),
+	))
+	d.ServerType = "cx22"
+	d.ReplaceDeprecatedServerType = true
+
+	if err := d.PreCreateCheck(); err != nil {
+		t.Fatalf("PreCreateCheck() error: %v", err)
+	}
+	if d.ServerType != "cx23" {
+		t.Errorf("ServerType = %q, want cx23", d.ServerType)
+	}
+}
+
+func TestPreCreateCheck_DeprecatedServerType_ReplacedBySuccessor(t *testing.T) {
+	future := time.Now().Add(30 * 24 * time.Hour)
+	d, _ := newTestDriver(t, deprecationMux(
+		deprecatedServerType(1, "cx22", 2, 4, 40, future),
+		simpleServerType(4, "cx33", 4, 8, 80),
+	))
+	d.ServerType = "cx22"
+	d.ReplaceDeprecatedServerType = true
+	d.ServerTypeSuccessor = "cx33"
+
+	if err := d.PreCreateCheck(); err != nil {
+		t.Fatalf("PreCreateCheck() error: %v", err)
+	}
+	if d.ServerType != "cx33" {
+		t.Errorf("ServerType = %q, want cx33", d.ServerType)
+	}
+}
+
+func TestPreCreateCheck_MissingServerType_UsesSuccessor(t *testing.T) {
+	d, _ := newTestDriver(t, deprecationMux(simpleServerType(3, "cx23", 2, 4, 40)))
+	d.ServerType = "cx22"
+	d.ReplaceDeprecatedServerType = true
+	d.ServerTypeSuccessor = "cx23"
+
+	if err := d.PreCreateCheck(); err != nil {
+		t.Fatalf("PreCreateCheck() error: %v", err)
+	}
+	if d.ServerType != "cx23" {
+		t.Errorf("ServerType = %q, want cx23", d.ServerType)
+	}
+}
+
+func TestPreCreateCheck_MissingServerType_WrapsRetired(t *testing.T) {
+	d, _ := newTestDriver(t, deprecationMux(simpleServerType(3, "cx23", 2, 4, 40)))
+	d.ServerType = "cx22"
+
+	err := d.PreCreateCheck()
+	if !errors.Is(err, ErrResourceRetired) {
+		t.Fatalf("error = %v, want it to wrap ErrResourceRetired", err)
+	}
+}
+
+func TestServerTypeDeprecation_OtherLocation(t *testing.T) {
+	st := &hcloud.ServerType{
+		Name: "cx22",
+		Locations: []hcloud.ServerTypeLocation{{
+			Location:             &hcloud.Location{Name: "hel1"},
+			DeprecatableResource: hcloud.DeprecatableResource{Deprecation: &hcloud.DeprecationInfo{}},
+		}},
+	}
+	if dep := serverTypeDeprecation(st, "fsn1"); dep != nil {
+		t.Errorf("serverTypeDeprecation(fsn1) = %v, want nil", dep)
+	}
+	if dep := serverTypeDeprecation(st, "hel1"); dep == nil {
+		t.Error("serverTypeDeprecation(hel1) = nil, want deprecation")
+	}
+}
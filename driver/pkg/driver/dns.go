@@ -0,0 +1,306 @@
+package driver
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/rancher/machine/libmachine/log"
+)
+
+const (
+	defaultDNSAPIURL = "https://dns.hetzner.com/api/v1"
+	dnsRecordTTL     = 300
+)
+
+// errDNSNotFound is returned by the DNS client for 404 responses.
+var errDNSNotFound = errors.New("not found")
+
+// dnsClient is a minimal client for the Hetzner DNS API, which is separate
+// from the Cloud API and not covered by hcloud-go.
+type dnsClient struct {
+	baseURL    string
+	token      string
+	httpClient *http.Client
+}
+
+type dnsZone struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+type dnsRecord struct {
+	ID     string `json:"id,omitempty"`
+	ZoneID string `json:"zone_id"`
+	Type   string `json:"type"`
+	Name   string `json:"name"`
+	Value  string `json:"value"`
+	TTL    int    `json:"ttl,omitempty"`
+}
+
+func (d *Driver) getDNSClient() *dnsClient {
+	baseURL := d.DNSAPIURL
+	if baseURL == "" {
+		baseURL = defaultDNSAPIURL
+	}
+	return &dnsClient{
+		baseURL:    strings.TrimSuffix(baseURL, "/"),
+		token:      d.DNSAPIToken,
+		httpClient: &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+func (c *dnsClient) do(ctx context.Context, method, path string, in, out interface{}) error {
+	var body io.Reader
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Auth-API-Token", c.token)
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return fmt.Errorf("%s %s: %w", method, path, errDNSNotFound)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%s %s: DNS API responded with status %d: %s", method, path, resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+func (c *dnsClient) zoneByName(ctx context.Context, name string) (*dnsZone, error) {
+	var resp struct {
+		Zones []dnsZone `json:"zones"`
+	}
+	if err := c.do(ctx, http.MethodGet, "/zones?name="+url.QueryEscape(name), nil, &resp); err != nil {
+		if errors.Is(err, errDNSNotFound) {
+			return nil, fmt.Errorf("DNS zone %q not found", name)
+		}
+		return nil, fmt.Errorf("failed to look up DNS zone %q: %w", name, err)
+	}
+	for _, zone := range resp.Zones {
+		if zone.Name == name {
+			return &zone, nil
+		}
+	}
+	return nil, fmt.Errorf("DNS zone %q not found", name)
+}
+
+func (c *dnsClient) records(ctx context.Context, zoneID string) ([]dnsRecord, error) {
+	var resp struct {
+		Records []dnsRecord `json:"records"`
+	}
+	if err := c.do(ctx, http.MethodGet, "/records?zone_id="+url.QueryEscape(zoneID), nil, &resp); err != nil {
+		return nil, fmt.Errorf("failed to list DNS records: %w", err)
+	}
+	return resp.Records, nil
+}
+
+func (c *dnsClient) createRecord(ctx context.Context, record dnsRecord) (*dnsRecord, error) {
+	var resp struct {
+		Record dnsRecord `json:"record"`
+	}
+	if err := c.do(ctx, http.MethodPost, "/records", record, &resp); err != nil {
+		return nil, fmt.Errorf("failed to create %s record %q: %w", record.Type, record.Name, err)
+	}
+	return &resp.Record, nil
+}
+
+func (c *dnsClient) updateRecord(ctx context.Context, record dnsRecord) error {
+	if err := c.do(ctx, http.MethodPut, "/records/"+url.PathEscape(record.ID), record, nil); err != nil {
+		return fmt.Errorf("failed to update %s record %q: %w", record.Type, record.Name, err)
+	}
+	return nil
+}
+
+func (c *dnsClient) deleteRecord(ctx context.Context, id string) error {
+	return c.do(ctx, http.MethodDelete, "/records/"+url.PathEscape(id), nil, nil)
+}
+
+// validateDNSZone checks that the DNS token can see the configured zone.
+func (d *Driver) validateDNSZone(ctx context.Context) error {
+	if _, err := d.getDNSClient().zoneByName(ctx, d.DNSZone); err != nil {
+		return fmt.Errorf("invalid --hetzner-dns-zone: %w", err)
+	}
+	return nil
+}
+
+// dnsNodeName returns the zone-relative name of the node's records.
+func (d *Driver) dnsNodeName() string {
+	return d.MachineName + "." + d.ClusterID
+}
+
+// dnsAPIName returns the zone-relative name of the round-robin API record.
+func (d *Driver) dnsAPIName() string {
+	return "api." + d.ClusterID
+}
+
+// dnsAddresses returns the addresses to publish by record type: the private
+// IP as A record with --hetzner-dns-use-private-ip, otherwise the enabled
+// public IPv4 and IPv6 addresses.
+func (d *Driver) dnsAddresses(ctx context.Context) (map[string]string, error) {
+	server, _, err := d.getClient().Server.GetByID(ctx, d.ServerID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get server: %w", err)
+	}
+	if server == nil {
+		return nil, fmt.Errorf("server %d not found", d.ServerID)
+	}
+
+	addrs := make(map[string]string)
+	if d.DNSUsePrivateIP {
+		if len(server.PrivateNet) == 0 {
+			return nil, fmt.Errorf("server %d has no private IP for DNS records", d.ServerID)
+		}
+		addrs["A"] = server.PrivateNet[0].IP.String()
+		return addrs, nil
+	}
+	if ip := server.PublicNet.IPv4.IP; len(ip) > 0 && !ip.IsUnspecified() {
+		addrs["A"] = ip.String()
+	}
+	if ip := server.PublicNet.IPv6.IP; len(ip) > 0 && !ip.IsUnspecified() {
+		addrs["AAAA"] = ipv6HostAddress(ip).String()
+	}
+	if len(addrs) == 0 {
+		return nil, fmt.Errorf("server %d has no public IP for DNS records", d.ServerID)
+	}
+	return addrs, nil
+}
+
+// ipv6HostAddress returns the address a server configures in its public
+// IPv6 network: Hetzner assigns a /64 and the images use its ::1 address.
+func ipv6HostAddress(network net.IP) net.IP {
+	host := make(net.IP, net.IPv6len)
+	copy(host, network.To16())
+	host[net.IPv6len-1] = 1
+	return host
+}
+
+// registerDNSRecords upserts the node's A/AAAA records and, with
+// --hetzner-dns-api-record, adds the node to the round-robin API record. The
+// IDs of all records are kept in DNSRecordIDs so Remove can delete them.
+func (d *Driver) registerDNSRecords(ctx context.Context) error {
+	addrs, err := d.dnsAddresses(ctx)
+	if err != nil {
+		return err
+	}
+	client := d.getDNSClient()
+	zone, err := client.zoneByName(ctx, d.DNSZone)
+	if err != nil {
+		return err
+	}
+	existing, err := client.records(ctx, zone.ID)
+	if err != nil {
+		return err
+	}
+
+	for _, recordType := range []string{"A", "AAAA"} {
+		value, ok := addrs[recordType]
+		if !ok {
+			continue
+		}
+		record := dnsRecord{ZoneID: zone.ID, Type: recordType, Name: d.dnsNodeName(), Value: value, TTL: dnsRecordTTL}
+		id, err := upsertDNSRecord(ctx, client, existing, record)
+		if err != nil {
+			return err
+		}
+		d.DNSRecordIDs = append(d.DNSRecordIDs, id)
+		log.Infof("DNS record %s.%s %s -> %s", record.Name, d.DNSZone, recordType, value)
+
+		if !d.DNSAPIRecord {
+			continue
+		}
+		// Round robin: one record per node, so look for this node's value only
+		apiRecord := dnsRecord{ZoneID: zone.ID, Type: recordType, Name: d.dnsAPIName(), Value: value, TTL: dnsRecordTTL}
+		id = findDNSRecord(existing, apiRecord.Type, apiRecord.Name, value)
+		if id == "" {
+			created, err := client.createRecord(ctx, apiRecord)
+			if err != nil {
+				return err
+			}
+			id = created.ID
+		}
+		d.DNSRecordIDs = append(d.DNSRecordIDs, id)
+		log.Infof("DNS record %s.%s %s -> %s", apiRecord.Name, d.DNSZone, recordType, value)
+	}
+	return nil
+}
+
+// upsertDNSRecord updates the record with the same type and name, or creates
+// it, and returns its ID.
+func upsertDNSRecord(ctx context.Context, client *dnsClient, existing []dnsRecord, record dnsRecord) (string, error) {
+	for _, r := range existing {
+		if r.Type != record.Type || r.Name != record.Name {
+			continue
+		}
+		if r.Value != record.Value {
+			record.ID = r.ID
+			if err := client.updateRecord(ctx, record); err != nil {
+				return "", err
+			}
+		}
+		return r.ID, nil
+	}
+	created, err := client.createRecord(ctx, record)
+	if err != nil {
+		return "", err
+	}
+	return created.ID, nil
+}
+
+// findDNSRecord returns the ID of the record with the given type, name and
+// value, or "" if there is none.
+func findDNSRecord(records []dnsRecord, recordType, name, value string) string {
+	for _, r := range records {
+		if r.Type == recordType && r.Name == name && r.Value == value {
+			return r.ID
+		}
+	}
+	return ""
+}
+
+// deleteDNSRecords deletes the records registered for this node. Records that
+// are already gone are skipped.
+func (d *Driver) deleteDNSRecords(ctx context.Context) {
+	if len(d.DNSRecordIDs) == 0 {
+		return
+	}
+	client := d.getDNSClient()
+	var remaining []string
+	for _, id := range d.DNSRecordIDs {
+		if err := client.deleteRecord(ctx, id); err != nil && !errors.Is(err, errDNSNotFound) {
+			log.Warnf("Failed to delete DNS record %s: %v", id, err)
+			remaining = append(remaining, id)
+			continue
+		}
+		log.Infof("Deleted DNS record %s", id)
+	}
+	d.DNSRecordIDs = remaining
+}
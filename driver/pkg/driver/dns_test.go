@@ -0,0 +1,213 @@
+package driver
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/hetznercloud/hcloud-go/v2/hcloud/schema"
+)
+
+// fakeDNS is a stand-in for the Hetzner DNS API with a single zone.
+type fakeDNS struct {
+	records map[string]dnsRecord
+	nextID  int
+	updated []string
+	deleted []string
+}
+
+func newFakeDNS(t *testing.T, records ...dnsRecord) (*fakeDNS, string) {
+	t.Helper()
+	f := &fakeDNS{records: make(map[string]dnsRecord)}
+	for _, r := range records {
+		f.records[r.ID] = r
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/", func(w http.ResponseWriter, r *http.Request) {
+		if got := r.Header.Get("Auth-API-Token"); got != "dns-token" {
+			t.Errorf("Auth-API-Token = %q, want dns-token", got)
+		}
+		path := strings.TrimPrefix(r.URL.Path, "/api/v1")
+		switch {
+		case path == "/zones":
+			var zones []dnsZone
+			if r.URL.Query().Get("name") == "example.com" {
+				zones = append(zones, dnsZone{ID: "zone1", Name: "example.com"})
+			}
+			jsonResponse(w, http.StatusOK, map[string]interface{}{"zones": zones})
+		case path == "/records" && r.Method == http.MethodGet:
+			if got := r.URL.Query().Get("zone_id"); got != "zone1" {
+				t.Errorf("zone_id = %q, want zone1", got)
+			}
+			var list []dnsRecord
+			for _, rec := range f.records {
+				list = append(list, rec)
+			}
+			jsonResponse(w, http.StatusOK, map[string]interface{}{"records": list})
+		case path == "/records" && r.Method == http.MethodPost:
+			var rec dnsRecord
+			if err := json.NewDecoder(r.Body).Decode(&rec); err != nil {
+				t.Fatalf("decode record: %v", err)
+			}
+			f.nextID++
+			rec.ID = "new" + strconv.Itoa(f.nextID)
+			f.records[rec.ID] = rec
+			jsonResponse(w, http.StatusOK, map[string]interface{}{"record": rec})
+		case strings.HasPrefix(path, "/records/"):
+			id := strings.TrimPrefix(path, "/records/")
+			if _, ok := f.records[id]; !ok {
+				jsonResponse(w, http.StatusNotFound, map[string]interface{}{"error": map[string]interface{}{"code": 404}})
+				return
+			}
+			switch r.Method {
+			case http.MethodPut:
+				var rec dnsRecord
+				if err := json.NewDecoder(r.Body).Decode(&rec); err != nil {
+					t.Fatalf("decode record: %v", err)
+				}
+				f.records[id] = rec
+				f.updated = append(f.updated, id)
+				jsonResponse(w, http.StatusOK, map[string]interface{}{"record": rec})
+			case http.MethodDelete:
+				delete(f.records, id)
+				f.deleted = append(f.deleted, id)
+				w.WriteHeader(http.StatusOK)
+			}
+		default:
+			t.Errorf("unexpected DNS request %s %s", r.Method, r.URL)
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	})
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return f, srv.URL + "/api/v1"
+}
+
+func dnsDriver(t *testing.T, apiURL string, server schema.Server) *Driver {
+	mux := http.NewServeMux()
+	registerServers(mux, nil, server)
+	d := clusterNodeDriver(t, mux)
+	d.MachineName = "prod-cp-abc12-xyz34"
+	d.DNSAPIToken = "dns-token"
+	d.DNSAPIURL = apiURL
+	d.DNSZone = "example.com"
+	return d
+}
+
+// recordValues maps "<type> <name>" to the values of the fake's records.
+func (f *fakeDNS) recordValues() map[string][]string {
+	values := make(map[string][]string)
+	for _, r := range f.records {
+		key := r.Type + " " + r.Name
+		values[key] = append(values[key], r.Value)
+	}
+	return values
+}
+
+func TestRegisterDNSRecords_CreatesNodeAndAPIRecords(t *testing.T) {
+	f, apiURL := newFakeDNS(t,
+		dnsRecord{ID: "other", ZoneID: "zone1", Type: "A", Name: "api.prod", Value: "5.6.7.8"})
+	d := dnsDriver(t, apiURL, standardServer(100, "running"))
+	d.DNSAPIRecord = true
+
+	if err := d.registerDNSRecords(testCtx(t)); err != nil {
+		t.Fatalf("registerDNSRecords() error: %v", err)
+	}
+	values := f.recordValues()
+	if v := values["A prod-cp-abc12-xyz34.prod"]; len(v) != 1 || v[0] != "1.2.3.4" {
+		t.Errorf("node A record = %v, want [1.2.3.4]", v)
+	}
+	if v := values["AAAA prod-cp-abc12-xyz34.prod"]; len(v) != 1 || v[0] != "2001:db8::1" {
+		t.Errorf("node AAAA record = %v, want [2001:db8::1]", v)
+	}
+	if v := values["A api.prod"]; len(v) != 2 {
+		t.Errorf("api A records = %v, want round robin with 5.6.7.8 and 1.2.3.4", v)
+	}
+	if len(d.DNSRecordIDs) != 4 {
+		t.Errorf("DNSRecordIDs = %v, want 4 records", d.DNSRecordIDs)
+	}
+}
+
+func TestRegisterDNSRecords_UpdatesExistingRecord(t *testing.T) {
+	f, apiURL := newFakeDNS(t,
+		dnsRecord{ID: "old", ZoneID: "zone1", Type: "A", Name: "prod-cp-abc12-xyz34.prod", Value: "9.9.9.9"})
+	d := dnsDriver(t, apiURL, standardServer(100, "running"))
+
+	if err := d.registerDNSRecords(testCtx(t)); err != nil {
+		t.Fatalf("registerDNSRecords() error: %v", err)
+	}
+	if len(f.updated) != 1 || f.updated[0] != "old" || f.records["old"].Value != "1.2.3.4" {
+		t.Errorf("updated = %v, record = %+v, want old updated to 1.2.3.4", f.updated, f.records["old"])
+	}
+	if len(f.records) != 2 {
+		t.Errorf("got %d records, want A and AAAA", len(f.records))
+	}
+}
+
+func TestRegisterDNSRecords_PrivateIP(t *testing.T) {
+	f, apiURL := newFakeDNS(t)
+	server := standardServer(100, "running")
+	server.PrivateNet = []schema.ServerPrivateNet{{Network: 8, IP: "10.0.0.5"}}
+	d := dnsDriver(t, apiURL, server)
+	d.DNSUsePrivateIP = true
+
+	if err := d.registerDNSRecords(testCtx(t)); err != nil {
+		t.Fatalf("registerDNSRecords() error: %v", err)
+	}
+	values := f.recordValues()
+	if len(values) != 1 || values["A prod-cp-abc12-xyz34.prod"][0] != "10.0.0.5" {
+		t.Errorf("records = %v, want only A 10.0.0.5", values)
+	}
+}
+
+func TestDeleteDNSRecords(t *testing.T) {
+	f, apiURL := newFakeDNS(t,
+		dnsRecord{ID: "a", ZoneID: "zone1", Type: "A", Name: "prod-cp-abc12-xyz34.prod", Value: "1.2.3.4"})
+	d := dnsDriver(t, apiURL, standardServer(100, "running"))
+	d.DNSRecordIDs = []string{"a", "gone"}
+
+	d.deleteDNSRecords(testCtx(t))
+	if len(f.deleted) != 1 || f.deleted[0] != "a" {
+		t.Errorf("deleted = %v, want [a]", f.deleted)
+	}
+	if len(d.DNSRecordIDs) != 0 {
+		t.Errorf("DNSRecordIDs = %v, want empty", d.DNSRecordIDs)
+	}
+}
+
+func TestValidateDNSZone_Unknown(t *testing.T) {
+	_, apiURL := newFakeDNS(t)
+	d := dnsDriver(t, apiURL, standardServer(100, "running"))
+	d.DNSZone = "example.org"
+
+	err := d.validateDNSZone(testCtx(t))
+	if err == nil || !strings.Contains(err.Error(), `DNS zone "example.org" not found`) {
+		t.Fatalf("validateDNSZone() error = %v, want zone not found", err)
+	}
+}
+
+func TestCreate_DNSFailure_ReleasesResources(t *testing.T) {
+	_, apiURL := newFakeDNS(t)
+	next := http.NewServeMux()
+	var deleted []int64
+	registerServers(next, &deleted, standardServer(100, "running"))
+	sshKeyDeleted := false
+	d := createDriver(t, createMux(next, &sshKeyDeleted))
+	d.DNSAPIToken = "dns-token"
+	d.DNSAPIURL = apiURL
+	d.DNSZone = "missing.example.com"
+
+	err := d.Create()
+	if err == nil || !strings.Contains(err.Error(), "failed to register DNS records") {
+		t.Fatalf("Create() error = %v, want DNS registration error", err)
+	}
+	if len(deleted) != 1 || deleted[0] != 100 {
+		t.Errorf("deleted servers %v, want [100]", deleted)
+	}
+	if !sshKeyDeleted {
+		t.Error("SSH key should be deleted")
+	}
+}
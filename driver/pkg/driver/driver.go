@@ -8,12 +8,12 @@ import (
 	"regexp"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/hetznercloud/hcloud-go/v2/hcloud"
 	"github.com/rancher/machine/libmachine/drivers"
 	"github.com/rancher/machine/libmachine/log"
-	"github.com/rancher/machine/libmachine/mcnutils"
 	"github.com/rancher/machine/libmachine/ssh"
 	"github.com/rancher/machine/libmachine/state"
 )
@@ -32,15 +32,30 @@ type Driver struct {
 	APIToken string
 
 	// Server config
-	ServerType     string
-	ServerLocation string
-	Image          string
+	ServerType      string
+	ServerLocation  string
+	ServerLocations []string // candidate locations; one is chosen per machine and stored in ServerLocation
+	Image           string
+	ImageSelector   string            // label selector resolving to the newest matching snapshot; overrides Image
+	ImageMap        map[string]string // per-architecture image name or ID ("x86", "arm"); falls back to Image
+
+	// Server type requirements (auto-selects ServerType when any is set)
+	MinCores      int
+	MinMemory     int      // GB
+	MinDisk       int      // GB
+	CPUType       string   // "shared" or "dedicated"; empty allows both
+	Architectures []string // allowed architectures ("x86", "arm"); empty allows both
+
+	// Deprecation handling
+	ReplaceDeprecatedServerType bool   // switch to a replacement when ServerType is deprecated
+	ServerTypeSuccessor         string // explicit replacement; default is the next-larger type of the same family
 
 	// Networking
 	Networks          []string
 	UsePrivateNetwork bool
 	DisablePublicIPv4 bool
 	DisablePublicIPv6 bool
+	AddressFamily     string // address GetIP returns without a private network: auto, ipv4 or ipv6
 	Firewalls         []string
 
 	// Firewall management
@@ -50,20 +65,82 @@ type Driver struct {
 
 	// Cluster identity (used for shared firewall and resource labeling)
 	ClusterID string
+	Pool      string // node pool name; derived from the machine name when empty
+
+	// Project limits (resource -> limit; unset resources and 0 are not checked)
+	QuotaLimits map[string]int
+
+	// Cost control
+	ClusterMonthlyBudget float64 // gross monthly limit for all servers labelled cluster=<id>; 0 disables the check
 
 	// Advanced
-	UserData       string
-	PlacementGroup string
-	ExistingSSHKey string
+	UserData           string
+	CloudConfig        []string // cloud-config fragments (inline or file path) merged into the user data
+	UserDataTemplate   bool     // render inline user data and cloud-config fragments as Go templates
+	PlacementGroup     string
+	AutoPlacementGroup bool // find or create spread groups per cluster and pool, overflowing to new groups
+	ExistingSSHKey     string
+	SSHPrivateKey      string // private key (file path or inline PEM) used instead of a generated one; never uploaded
+
+	// Control-plane load balancer
+	CreateLoadBalancer bool   // find or create the cluster's load balancer for 6443/9345 and register this server
+	LoadBalancerType   string // type used when creating the load balancer
+	LoadBalancerName   string // custom name for the load balancer (default: rancher-<cluster-id>-cp)
+
+	// Ingress load balancer
+	IngressLoadBalancer bool  // label this pool's servers for the cluster's ingress load balancer and ensure it exists
+	IngressPorts        []int // TCP ports forwarded by the ingress load balancer (default: 80, 443)
+
+	// Control-plane virtual IP
+	CreateFloatingIP bool // find or create the cluster's floating IP, configure it on this server and assign it if free
+
+	// Hetzner DNS records (<machine>.<cluster> and api.<cluster> in DNSZone)
+	DNSAPIToken     string
+	DNSAPIURL       string // Hetzner DNS API base URL; overridable for testing
+	DNSZone         string // zone the records are created in, e.g. example.com
+	DNSAPIRecord    bool   // add this node to the round-robin api.<cluster> record
+	DNSUsePrivateIP bool   // publish the private IP instead of the public addresses
+
+	// Reverse DNS template for the public IPs, e.g. {{.MachineName}}.nodes.example.com
+	ReverseDNSTemplate string
+
+	// Snapshot before removal
+	SnapshotOnRemove  bool // snapshot the server before deleting it
+	SnapshotRetention int  // driver-created snapshots to keep per cluster; 0 keeps all
+	SnapshotRequired  bool // abort removal when the snapshot fails instead of deleting anyway
+
+	// SSH readiness (WaitForSSH)
+	SSHWaitAttempts  int  // authenticated SSH connection attempts
+	SSHWaitTimeout   int  // seconds per SSH connection attempt
+	WaitForCloudInit bool // have Create wait until cloud-init reports done
+	CloudInitTimeout int  // seconds to wait for cloud-init
 
 	// Internal state (serialized to machine config)
-	ServerID       int64
-	SSHKeyID       int64
-	FirewallID     int64
-	PublicIPv4     string // public IPv4 for firewall rules (may differ from IPAddress when using private networks)
-
-	version string
-	client  *hcloud.Client
+	ServerID              int64
+	SSHKeyID              int64
+	FirewallID            int64
+	ImageID               int64    // image actually used to create the server
+	PlacementGroupID      int64    // auto-managed placement group the server was placed in
+	LoadBalancerID        int64    // control-plane load balancer the server is a target of
+	IngressLoadBalancerID int64    // ingress load balancer targeting the server by label
+	FloatingIPID          int64    // cluster floating IP configured on the server
+	FloatingIP            string   // address of the cluster floating IP
+	DNSRecordIDs          []string // DNS records created or updated for the server
+	PublicIPv4            string   // public IPv4 for firewall rules (may differ from IPAddress when using private networks)
+	PublicIPv6            string   // public IPv6 network for firewall rules of nodes without public IPv4
+
+	// Bastion (jump host) for nodes without a reachable address
+	BastionHost    string // host or host:port of the bastion
+	BastionUser    string // SSH user on the bastion
+	BastionKey     string // private key (file path or inline PEM) for the bastion; SSHPrivateKey when empty, which PreCreateCheck then requires
+	BastionHostKey string // pinned public host key of the bastion, authorized_keys format
+
+	version   string
+	client    *hcloud.Client
+	imageName string // resolved image as templates see it
+
+	tunnelMu sync.Mutex
+	tunnel   *sshTunnel // local end of the SSH tunnel through the bastion
 }
 
 // NewDriver creates a new Hetzner driver.
@@ -103,29 +180,67 @@ func (d *Driver) PreCreateCheck() error {
 	}
 
 	// Validate config combinations that don't need API access
-	if d.DisablePublicIPv4 && d.DisablePublicIPv6 && !d.UsePrivateNetwork {
+	if d.DisablePublicIPv4 && d.DisablePublicIPv6 && !d.UsePrivateNetwork && d.BastionHost == "" {
 		return fmt.Errorf("server would have no network connectivity: both public IPv4 and IPv6 are disabled " +
-			"and no private network is configured; enable at least one public IP or use --hetzner-use-private-network")
+			"and no private network is configured; enable at least one public IP, use --hetzner-use-private-network, " +
+			"or reach the node through --hetzner-bastion-host")
+	}
+	if d.BastionHost != "" && len(d.Networks) == 0 {
+		return fmt.Errorf("--hetzner-bastion-host requires --hetzner-networks; the node is reached through the bastion at its private IP")
+	}
+	if err := d.checkBastion(); err != nil {
+		return err
 	}
-	if d.CreateFirewall && d.AutoCreateFirewallRules && d.DisablePublicIPv4 {
-		return fmt.Errorf("cannot auto-create firewall rules when public IPv4 is disabled: firewall rules require a public IPv4 address")
+	if d.AddressFamily == addressFamilyIPv4 && d.DisablePublicIPv4 && !d.UsePrivateNetwork && d.BastionHost == "" {
+		return fmt.Errorf("--hetzner-address-family ipv4 requires a public IPv4 or a private network")
 	}
-	if d.CreateFirewall && d.DisablePublicIPv4 {
-		log.Warnf("Warning: public IPv4 is disabled but CreateFirewall is enabled — "+
+	if d.AddressFamily == addressFamilyIPv6 && d.DisablePublicIPv6 {
+		return fmt.Errorf("--hetzner-address-family ipv6 requires a public IPv6; remove --hetzner-disable-public-ipv6")
+	}
+	if d.CreateFirewall && d.AutoCreateFirewallRules && d.DisablePublicIPv4 && d.DisablePublicIPv6 {
+		return fmt.Errorf("cannot auto-create firewall rules when public IPv4 and IPv6 are disabled: firewall rules require a public IP address")
+	}
+	if d.CreateFirewall && d.DisablePublicIPv4 && d.DisablePublicIPv6 {
+		log.Warnf("Warning: public IPv4 and IPv6 are disabled but CreateFirewall is enabled — "+
 			"this node's IP cannot be added to the shared firewall's internal rules; "+
 			"other nodes' firewalls may block traffic from this node")
 	}
 	if d.CreateFirewall && len(d.Firewalls) > 0 {
 		return fmt.Errorf("cannot use both --hetzner-create-firewall and --hetzner-firewalls; choose one firewall mode")
 	}
-	if d.CreateFirewall && d.ClusterID == "" {
+	if d.CreateLoadBalancer && d.UsePrivateNetwork && len(d.Networks) == 0 {
+		return fmt.Errorf("--hetzner-create-load-balancer with --hetzner-use-private-network requires --hetzner-networks; " +
+			"the load balancer is attached to the first network to reach private targets")
+	}
+	if d.IngressLoadBalancer && d.UsePrivateNetwork && len(d.Networks) == 0 {
+		return fmt.Errorf("--hetzner-ingress-load-balancer with --hetzner-use-private-network requires --hetzner-networks; " +
+			"the load balancer is attached to the first network to reach private targets")
+	}
+	if d.CreateFloatingIP && d.DisablePublicIPv4 && d.DisablePublicIPv6 {
+		return fmt.Errorf("--hetzner-create-floating-ip requires a public network interface; enable public IPv4 or IPv6")
+	}
+	if d.DNSUsePrivateIP && len(d.Networks) == 0 {
+		return fmt.Errorf("--hetzner-dns-use-private-ip requires --hetzner-networks; the private IP comes from the first network")
+	}
+	if d.AutoPlacementGroup && d.PlacementGroup != "" {
+		return fmt.Errorf("cannot use both --hetzner-auto-placement-group and --hetzner-placement-group; choose one placement mode")
+	}
+	if (d.AutoPlacementGroup || len(d.ServerLocations) > 0) && d.Pool == "" {
+		d.Pool = poolFromMachineName(d.MachineName)
+		if d.Pool == "" {
+			return fmt.Errorf("--hetzner-pool is required when --hetzner-auto-placement-group or --hetzner-server-locations is set " +
+				"and the pool cannot be derived from the machine name")
+		}
+		log.Infof("Auto-derived pool %q from machine name %q", d.Pool, d.MachineName)
+	}
+	if scoped := d.clusterScopedFlags(); len(scoped) > 0 && d.ClusterID == "" {
 		// Auto-derive cluster ID from the machine name. Rancher names machines as
 		// <cluster>-<pool>-<hash>-<hash>, so stripping the last 3 segments gives us
-		// the cluster name which is used as the shared firewall identifier.
+		// the cluster name which is used as the shared resource identifier.
 		derived := clusterIDFromMachineName(d.MachineName)
 		if derived == "" {
-			return fmt.Errorf("--hetzner-cluster-id is required when --hetzner-create-firewall is enabled; " +
-				"the cluster ID identifies the shared firewall across all node pools")
+			return fmt.Errorf("--hetzner-cluster-id is required when %s is set; "+
+				"the cluster ID identifies shared resources across all node pools", strings.Join(scoped, ", "))
 		}
 		d.ClusterID = derived
 		log.Infof("Auto-derived cluster ID %q from machine name %q", d.ClusterID, d.MachineName)
@@ -133,9 +248,9 @@ func (d *Driver) PreCreateCheck() error {
 	if err := validateClusterID(d.ClusterID); err != nil {
 		return err
 	}
-	if d.DisablePublicIPv4 && !d.DisablePublicIPv6 && d.ClusterID != "" {
-		log.Warnf("Warning: IPv6-only node in cluster %q — firewall internal rules use IPv4 source CIDRs; "+
-			"this node's traffic may be blocked by other nodes' firewalls", d.ClusterID)
+	if d.DisablePublicIPv4 && !d.DisablePublicIPv6 && !d.UsePrivateNetwork && d.ClusterID != "" {
+		log.Warnf("Warning: IPv6-only node in cluster %q — other nodes can reach it over IPv6 only; "+
+			"give them public IPv6 or put the cluster in a private network", d.ClusterID)
 	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
@@ -147,13 +262,39 @@ func (d *Driver) PreCreateCheck() error {
 		return fmt.Errorf("failed to validate API token: %w", err)
 	}
 
+	// Validate the DNS token and zone
+	if d.DNSZone != "" {
+		if err := d.validateDNSZone(ctx); err != nil {
+			return err
+		}
+	}
+
+	// Pick this machine's location when spreading across several
+	if len(d.ServerLocations) > 0 {
+		if err := d.selectServerLocation(ctx); err != nil {
+			return err
+		}
+	}
+
+	// Pick the server type from requirements when configured
+	if d.hasServerTypeRequirements() {
+		if err := d.selectServerType(ctx); err != nil {
+			return err
+		}
+	}
+
 	// Validate server type exists
 	serverType, _, err := d.getClient().ServerType.GetByName(ctx, d.ServerType)
 	if err != nil {
 		return fmt.Errorf("invalid server type %q: %w", d.ServerType, err)
 	}
 	if serverType == nil {
-		return fmt.Errorf("server type %q not found", d.ServerType)
+		if serverType, err = d.replaceMissingServerType(ctx); err != nil {
+			return err
+		}
+	}
+	if serverType, err = d.checkServerTypeDeprecation(ctx, serverType); err != nil {
+		return err
 	}
 
 	// Validate location exists
@@ -168,20 +309,54 @@ func (d *Driver) PreCreateCheck() error {
 	// Validate image exists for the server type's architecture
 	arch := serverType.Architecture
 	log.Infof("Server type %q uses architecture %s", d.ServerType, arch)
-	image, _, err := d.getClient().Image.GetByNameAndArchitecture(ctx, d.Image, arch)
+	image, err := d.resolveImage(ctx, arch)
 	if err != nil {
-		return fmt.Errorf("invalid image %q for architecture %s: %w", d.Image, arch, err)
+		return err
+	}
+	d.checkImageMapping(arch)
+	warnImageDeprecation(d.imageDescription(), image)
+	d.imageName = templateImageName(image)
+
+	// Render the reverse DNS name now so template mistakes surface before
+	// creation; location, server type and image are final at this point
+	if d.ReverseDNSTemplate != "" {
+		if _, err := d.reverseDNSName(); err != nil {
+			return err
+		}
 	}
-	if image == nil {
-		return fmt.Errorf("image %q not found for architecture %s", d.Image, arch)
+	// Same for templated user data and fragments (dry render, nothing is sent)
+	if d.UserDataTemplate {
+		if _, err := d.readUserData(); err != nil {
+			return err
+		}
+		if _, err := d.readCloudConfigFragments(); err != nil {
+			return err
+		}
+	}
+
+	// Fail before creating anything if project limits would be exceeded
+	if err := d.checkProjectQuotas(ctx, serverType); err != nil {
+		return err
+	}
+
+	// Refuse to exceed the cluster's monthly budget
+	if d.ClusterMonthlyBudget > 0 {
+		if err := d.checkClusterBudget(ctx, serverType); err != nil {
+			return err
+		}
 	}
 
 	// Validate existing SSH key if specified
 	if d.ExistingSSHKey != "" {
-		_, err = d.resolveSSHKey(ctx, d.ExistingSSHKey)
+		existingSSHKey, err := d.resolveSSHKey(ctx, d.ExistingSSHKey)
 		if err != nil {
 			return fmt.Errorf("invalid existing SSH key %q: %w", d.ExistingSSHKey, err)
 		}
+		if d.SSHPrivateKey != "" {
+			if err := d.checkSSHKeyMatches(existingSSHKey); err != nil {
+				return err
+			}
+		}
 	}
 
 	return nil
@@ -191,8 +366,12 @@ func (d *Driver) PreCreateCheck() error {
 func (d *Driver) Create() error {
 	log.Infof("Creating Hetzner Cloud server...")
 
-	// Generate SSH key
-	if err := ssh.GenerateSSHKey(d.GetSSHKeyPath()); err != nil {
+	// Generate SSH key, or install the one provided
+	if d.SSHPrivateKey != "" {
+		if err := d.installSSHPrivateKey(); err != nil {
+			return err
+		}
+	} else if err := ssh.GenerateSSHKey(d.GetSSHKeyPath()); err != nil {
 		return fmt.Errorf("failed to generate SSH key: %w", err)
 	}
 
@@ -204,19 +383,24 @@ func (d *Driver) Create() error {
 	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
 	defer cancel()
 
-	// Upload SSH key to Hetzner
-	sshKeyName := sshKeyNamePrefix + d.MachineName
-	log.Infof("Uploading SSH key %q...", sshKeyName)
+	// The floating IP must exist before the server so cloud-init can configure
+	// it; failures from here on release it again unless another node uses it
+	if d.CreateFloatingIP {
+		if err := d.ensureClusterFloatingIP(ctx); err != nil {
+			d.cleanupFailedCreate()
+			return err
+		}
+	}
 
-	sshKey, _, err := d.getClient().SSHKey.Create(ctx, hcloud.SSHKeyCreateOpts{
-		Name:      sshKeyName,
-		PublicKey: string(publicKeyBytes),
-		Labels:    d.resourceLabels(),
-	})
-	if err != nil {
-		return fmt.Errorf("failed to create SSH key: %w", err)
+	// Reuse the Hetzner SSH key with the same fingerprint, or upload it. A
+	// provided key is already in Hetzner as --hetzner-existing-ssh-key.
+	var sshKey *hcloud.SSHKey
+	if d.SSHPrivateKey == "" {
+		if sshKey, err = d.ensureSSHKey(ctx, publicKeyBytes); err != nil {
+			d.cleanupFailedCreate()
+			return err
+		}
 	}
-	d.SSHKeyID = sshKey.ID
 
 	// Resolve existing SSH key if specified
 	var existingSSHKey *hcloud.SSHKey
@@ -224,26 +408,41 @@ func (d *Driver) Create() error {
 		log.Infof("Resolving existing SSH key %q...", d.ExistingSSHKey)
 		existingSSHKey, err = d.resolveSSHKey(ctx, d.ExistingSSHKey)
 		if err != nil {
-			d.deleteSSHKey(ctx)
+			d.cleanupFailedCreate()
 			return fmt.Errorf("failed to resolve existing SSH key %q: %w", d.ExistingSSHKey, err)
 		}
-		log.Infof("Using existing SSH key %q (ID=%d) alongside auto-generated key", existingSSHKey.Name, existingSSHKey.ID)
+		if d.SSHPrivateKey != "" {
+			if err := d.checkSSHKeyMatches(existingSSHKey); err != nil {
+				d.cleanupFailedCreate()
+				return err
+			}
+			log.Infof("Using existing SSH key %q (ID=%d) with the provided private key", existingSSHKey.Name, existingSSHKey.ID)
+		} else {
+			log.Infof("Using existing SSH key %q (ID=%d) alongside auto-generated key", existingSSHKey.Name, existingSSHKey.ID)
+		}
 	}
 
 	// Build server create options (no firewall yet — added after server has IP)
 	opts, err := d.buildServerCreateOpts(ctx, sshKey, existingSSHKey)
 	if err != nil {
-		d.deleteSSHKey(ctx)
+		d.cleanupFailedCreate()
 		return fmt.Errorf("failed to build server options: %w", err)
 	}
 
+	d.logCostEstimate(ctx)
+
 	// Create server
-	log.Infof("Creating server %q (type=%s, location=%s, image=%s)...",
-		d.MachineName, d.ServerType, d.ServerLocation, d.Image)
+	log.Infof("Creating server %q (type=%s, location=%s, image=%s, image ID=%d)...",
+		d.MachineName, d.ServerType, d.ServerLocation, d.imageDescription(), d.ImageID)
 
-	result, _, err := d.getClient().Server.Create(ctx, *opts)
+	var result hcloud.ServerCreateResult
+	if d.AutoPlacementGroup {
+		result, err = d.createServerInPoolPlacementGroup(ctx, opts)
+	} else {
+		result, _, err = d.getClient().Server.Create(ctx, *opts)
+	}
 	if err != nil {
-		d.deleteSSHKey(ctx)
+		d.cleanupFailedCreate()
 		return fmt.Errorf("failed to create server: %w", err)
 	}
 
@@ -252,6 +451,7 @@ func (d *Driver) Create() error {
 
 	// Wait for the create action to complete
 	if err := d.waitForAction(ctx, result.Action); err != nil {
+		d.cleanupFailedCreate()
 		return fmt.Errorf("server creation failed: %w", err)
 	}
 
@@ -264,22 +464,24 @@ func (d *Driver) Create() error {
 
 	// Set the IP address
 	if err := d.updateIPAddress(ctx); err != nil {
+		d.cleanupFailedCreate()
 		return fmt.Errorf("failed to get server IP: %w", err)
 	}
 
 	log.Infof("Server %q is ready at %s", d.MachineName, d.IPAddress)
 
+	// Point the PTR records of the public IPs at the configured name
+	if d.ReverseDNSTemplate != "" {
+		d.setReverseDNS(ctx)
+	}
+
 	// Set up shared firewall (after server is provisioned and has an IP)
 	if d.CreateFirewall {
 		if err := d.setupFirewall(ctx); err != nil {
-			// Clean up server so it doesn't leak if firewall setup fails.
-			// The SSH key is cleaned up in Remove() as well, but we do it here
-			// since Rancher may not call Remove() if Create() returns an error
-			// with a zero ServerID.
-			d.cleanupServer(ctx)
+			d.cleanupFailedCreate()
 			return err
 		}
-	} else if d.ClusterID != "" && !d.DisablePublicIPv4 {
+	} else if d.ClusterID != "" && (!d.DisablePublicIPv4 || !d.DisablePublicIPv6) {
 		// Node doesn't manage its own firewall, but belongs to a cluster that
 		// may have a shared firewall. Add this node's IP to the cluster firewall
 		// so other nodes' firewalls allow traffic from this node.
@@ -289,6 +491,46 @@ func (d *Driver) Create() error {
 		}
 	}
 
+	// Register as a target of the control-plane load balancer
+	if d.CreateLoadBalancer {
+		if err := d.registerWithLoadBalancer(ctx); err != nil {
+			d.cleanupFailedCreate()
+			return err
+		}
+	}
+
+	// The ingress load balancer picks this server up by its ingress label
+	if d.IngressLoadBalancer {
+		if err := d.ensureIngressLoadBalancer(ctx); err != nil {
+			d.cleanupFailedCreate()
+			return err
+		}
+	}
+
+	// The first node of the cluster takes the floating IP
+	if d.CreateFloatingIP {
+		if err := d.assignFloatingIP(ctx); err != nil {
+			d.cleanupFailedCreate()
+			return err
+		}
+	}
+
+	// Publish the node's addresses in DNS
+	if d.DNSZone != "" {
+		if err := d.registerDNSRecords(ctx); err != nil {
+			d.cleanupFailedCreate()
+			return fmt.Errorf("failed to register DNS records: %w", err)
+		}
+	}
+
+	// Wait until the server accepts the machine key and, with
+	// --hetzner-wait-for-cloud-init, the bootstrap has finished, so failures
+	// surface on this machine rather than as a node that never registers
+	if err := WaitForSSH(d); err != nil {
+		d.cleanupFailedCreate()
+		return err
+	}
+
 	return nil
 }
 
@@ -296,15 +538,11 @@ func (d *Driver) Create() error {
 // performs best-effort cleanup so the firewall doesn't leak if Rancher
 // doesn't immediately retry.
 func (d *Driver) setupFirewall(ctx context.Context) error {
-	// Always fetch the public IPv4 when available — even when AutoCreateFirewallRules
+	// Always fetch the public IP when available — even when AutoCreateFirewallRules
 	// is false, we still add this node's IP to the shared firewall's internal rules
 	// so other nodes allow traffic from it.
-	if !d.DisablePublicIPv4 {
-		publicIP, err := d.fetchPublicIPv4(ctx)
-		if err != nil {
-			return fmt.Errorf("failed to get public IP for firewall: %w", err)
-		}
-		d.PublicIPv4 = publicIP
+	if err := d.updateFirewallSource(ctx); err != nil {
+		return fmt.Errorf("failed to get public IP for firewall: %w", err)
 	}
 
 	fw, created, err := d.findOrCreateSharedFirewall(ctx)
@@ -322,9 +560,9 @@ func (d *Driver) setupFirewall(ctx context.Context) error {
 	// Skip addNodeToFirewall when we just created the firewall — the node's
 	// IP is already included in the initial rules, so calling it would just
 	// trigger an unnecessary read-modify-verify cycle.
-	// Also skip when PublicIPv4 is empty (DisablePublicIPv4=true) — there's
-	// no IP to add to the internal rules.
-	if !created && d.PublicIPv4 != "" {
+	// Also skip when the node has no public IP — there's no IP to add to the
+	// internal rules.
+	if !created && d.firewallSource() != "" {
 		if err := d.addNodeToFirewall(ctx); err != nil {
 			cleanupCtx, cleanupCancel := context.WithTimeout(context.Background(), 30*time.Second)
 			defer cleanupCancel()
@@ -347,14 +585,15 @@ func (d *Driver) buildServerCreateOpts(ctx context.Context, autoSSHKey *hcloud.S
 
 	// Use the server type's architecture to find the matching image
 	arch := serverType.Architecture
-	log.Infof("Resolving image %q for architecture %s", d.Image, arch)
-	image, _, err := d.getClient().Image.GetByNameAndArchitecture(ctx, d.Image, arch)
+	log.Infof("Resolving image %s for architecture %s", d.imageDescription(), arch)
+	image, err := d.resolveImage(ctx, arch)
 	if err != nil {
-		return nil, fmt.Errorf("image %q not found for architecture %s: %w", d.Image, arch, err)
-	}
-	if image == nil {
-		return nil, fmt.Errorf("image %q not found for architecture %s", d.Image, arch)
+		return nil, err
 	}
+	// Record the concrete image for traceability — selectors resolve to a
+	// different snapshot as new images are built.
+	d.ImageID = image.ID
+	d.imageName = templateImageName(image)
 
 	location, _, err := d.getClient().Location.GetByName(ctx, d.ServerLocation)
 	if err != nil {
@@ -377,19 +616,18 @@ func (d *Driver) buildServerCreateOpts(ctx context.Context, autoSSHKey *hcloud.S
 		},
 	}
 
-	if d.UserData != "" {
-		userData := d.UserData
-		// If the userData looks like a file path, read its contents
-		// rancher-machine writes the bootstrap script to a temp file
-		if strings.HasPrefix(userData, "/") {
-			content, err := os.ReadFile(userData)
-			if err != nil {
-				return nil, fmt.Errorf("failed to read user data file %q: %w", userData, err)
-			}
-			userData = string(content)
-			log.Infof("Read user data from file %q (%d bytes)", d.UserData, len(userData))
-		}
-		opts.UserData = userData
+	if opts.UserData, err = d.readUserData(); err != nil {
+		return nil, err
+	}
+	// Merge the cloud-config the driver's options need and the user's
+	// fragments into the user data
+	fragments, err := d.readCloudConfigFragments()
+	if err != nil {
+		return nil, err
+	}
+	fragments = append(d.cloudConfigSnippets(opts.SSHKeys), fragments...)
+	if opts.UserData, err = composeUserData(opts.UserData, fragments...); err != nil {
+		return nil, err
 	}
 
 	// Attach networks
@@ -417,6 +655,12 @@ func (d *Driver) buildServerCreateOpts(ctx context.Context, autoSSHKey *hcloud.S
 			return nil, fmt.Errorf("failed to resolve placement group %q: %w", d.PlacementGroup, err)
 		}
 		opts.PlacementGroup = pg
+	} else if d.AutoPlacementGroup {
+		pg, err := d.findOrCreatePoolPlacementGroup(ctx)
+		if err != nil {
+			return nil, err
+		}
+		opts.PlacementGroup = pg
 	}
 
 	return opts, nil
@@ -446,6 +690,49 @@ func clusterIDFromMachineName(name string) string {
 	return sanitizeClusterID(name[:loc[0]])
 }
 
+// clusterScopedFlags returns the enabled flags whose resources are shared by
+// all machines of a cluster and therefore need a cluster ID.
+func (d *Driver) clusterScopedFlags() []string {
+	var flags []string
+	if d.CreateFirewall {
+		flags = append(flags, "--hetzner-create-firewall")
+	}
+	if d.ClusterMonthlyBudget > 0 {
+		flags = append(flags, "--hetzner-cluster-monthly-budget")
+	}
+	if d.AutoPlacementGroup {
+		flags = append(flags, "--hetzner-auto-placement-group")
+	}
+	if d.CreateLoadBalancer {
+		flags = append(flags, "--hetzner-create-load-balancer")
+	}
+	if d.IngressLoadBalancer {
+		flags = append(flags, "--hetzner-ingress-load-balancer")
+	}
+	if d.CreateFloatingIP {
+		flags = append(flags, "--hetzner-create-floating-ip")
+	}
+	if d.DNSZone != "" {
+		flags = append(flags, "--hetzner-dns-zone")
+	}
+	if len(d.ServerLocations) > 0 {
+		flags = append(flags, "--hetzner-server-locations")
+	}
+	return flags
+}
+
+// poolFromMachineName extracts the pool name from a Rancher machine name
+// (<cluster>-<pool>-<hash>-<hash>). See machineNameSuffixRe for the caveat
+// about pool names containing hyphens.
+func poolFromMachineName(name string) string {
+	loc := machineNameSuffixRe.FindStringIndex(name)
+	if loc == nil || loc[0] == 0 {
+		return ""
+	}
+	pool, _, _ := strings.Cut(name[loc[0]+1:], "-")
+	return sanitizeClusterID(pool)
+}
+
 // resourceLabels returns the standard labels applied to all Hetzner resources.
 func (d *Driver) resourceLabels() map[string]string {
 	labels := map[string]string{
@@ -455,11 +742,23 @@ func (d *Driver) resourceLabels() map[string]string {
 	if d.ClusterID != "" {
 		labels["cluster"] = d.ClusterID
 	}
+	if d.Pool != "" {
+		labels["pool"] = d.Pool
+	}
+	if d.IngressLoadBalancer {
+		labels[ingressLabel] = "true"
+	}
+	if d.CreateFloatingIP {
+		labels[floatingIPLabel] = "true"
+	}
 	return labels
 }
 
 func (d *Driver) buildSSHKeyList(autoKey *hcloud.SSHKey, existingKey *hcloud.SSHKey) []*hcloud.SSHKey {
-	keys := []*hcloud.SSHKey{autoKey}
+	var keys []*hcloud.SSHKey
+	if autoKey != nil {
+		keys = append(keys, autoKey)
+	}
 	if existingKey != nil {
 		keys = append(keys, existingKey)
 	}
@@ -583,7 +882,11 @@ func (d *Driver) GetState() (state.State, error) {
 	}
 }
 
-// GetIP returns the public IPv4 address of the server.
+// GetIP returns the address rancher-machine connects to, cached after Create.
+// It is the first private network IP with --hetzner-use-private-network or a
+// bastion; otherwise the public IPv4, unless --hetzner-address-family ipv6 is
+// set or the server has none, then the IPv6 host address (<prefix>::1).
+// --hetzner-address-family ipv4 never falls back to IPv6.
 func (d *Driver) GetIP() (string, error) {
 	if d.IPAddress != "" {
 		return d.IPAddress, nil
@@ -624,14 +927,21 @@ func (d *Driver) fetchIP(ctx context.Context) (string, error) {
 		return "", fmt.Errorf("server %d not found", d.ServerID)
 	}
 
-	// If using private network, return private IP
-	if d.UsePrivateNetwork && len(server.PrivateNet) > 0 {
+	// If using private network or a bastion, return private IP
+	if (d.UsePrivateNetwork || d.BastionHost != "") && len(server.PrivateNet) > 0 {
 		return server.PrivateNet[0].IP.String(), nil
 	}
 
-	// Return public IPv4
-	if ip := server.PublicNet.IPv4.IP; len(ip) > 0 && !ip.IsUnspecified() {
-		return ip.String(), nil
+	// Return the public IP of the preferred address family
+	ipv4 := server.PublicNet.IPv4.IP
+	hasIPv4 := len(ipv4) > 0 && !ipv4.IsUnspecified()
+	ipv6 := server.PublicNet.IPv6.IP
+	hasIPv6 := len(ipv6) > 0 && !ipv6.IsUnspecified()
+	switch {
+	case hasIPv4 && d.AddressFamily != addressFamilyIPv6:
+		return ipv4.String(), nil
+	case hasIPv6 && d.AddressFamily != addressFamilyIPv4:
+		return ipv6HostAddress(ipv6).String(), nil
 	}
 
 	return "", fmt.Errorf("no IP address available for server %d", d.ServerID)
@@ -665,11 +975,46 @@ func (d *Driver) fetchPublicIPv4(ctx context.Context) (string, error) {
 	return "", fmt.Errorf("no public IPv4 address available for server %d", d.ServerID)
 }
 
-// GetSSHHostname returns the hostname for SSH connections.
+// fetchPublicIPv6Network returns the server's public IPv6 network (a /64).
+// A node may send from any address in it, so firewall rules allow the whole
+// network.
+func (d *Driver) fetchPublicIPv6Network(ctx context.Context) (string, error) {
+	server, _, err := d.getClient().Server.GetByID(ctx, d.ServerID)
+	if err != nil {
+		return "", fmt.Errorf("failed to get server: %w", err)
+	}
+	if server == nil {
+		return "", fmt.Errorf("server %d not found", d.ServerID)
+	}
+
+	if network := server.PublicNet.IPv6.Network; network != nil && !network.IP.IsUnspecified() {
+		return network.String(), nil
+	}
+
+	return "", fmt.Errorf("no public IPv6 network available for server %d", d.ServerID)
+}
+
+// GetSSHHostname returns the hostname for SSH connections: the local end of
+// the tunnel through the bastion, if one is set.
 func (d *Driver) GetSSHHostname() (string, error) {
+	if d.BastionHost != "" {
+		if _, err := d.sshTunnelPort(); err != nil {
+			return "", err
+		}
+		return "127.0.0.1", nil
+	}
 	return d.GetIP()
 }
 
+// GetSSHPort returns the port for SSH connections: the local end of the
+// tunnel through the bastion, if one is set.
+func (d *Driver) GetSSHPort() (int, error) {
+	if d.BastionHost != "" {
+		return d.sshTunnelPort()
+	}
+	return d.sshPort(), nil
+}
+
 // GetURL returns the Docker URL.
 func (d *Driver) GetURL() (string, error) {
 	ip, err := d.GetIP()
@@ -697,6 +1042,7 @@ func (d *Driver) Start() error {
 // Stop gracefully shuts down the server.
 func (d *Driver) Stop() error {
 	log.Infof("Stopping server %d...", d.ServerID)
+	d.closeSSHTunnel()
 
 	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
 	defer cancel()
@@ -727,6 +1073,7 @@ func (d *Driver) Restart() error {
 // Kill forcefully stops the server.
 func (d *Driver) Kill() error {
 	log.Infof("Killing server %d...", d.ServerID)
+	d.closeSSHTunnel()
 
 	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
 	defer cancel()
@@ -742,20 +1089,51 @@ func (d *Driver) Kill() error {
 // Remove deletes the server and associated resources.
 func (d *Driver) Remove() error {
 	log.Infof("Removing server %d...", d.ServerID)
+	d.closeSSHTunnel()
+
+	// Snapshot first, with its own timeout — snapshots can take far longer
+	// than the rest of the removal.
+	if d.SnapshotOnRemove && d.ServerID != 0 {
+		if err := d.snapshotBeforeRemove(); err != nil {
+			if d.SnapshotRequired {
+				return fmt.Errorf("refusing to delete server %d without snapshot: %w", d.ServerID, err)
+			}
+			log.Warnf("Snapshot before removal failed, deleting anyway: %v", err)
+		}
+	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
 	defer cancel()
+	return d.removeResources(ctx)
+}
 
+// removeResources deletes the server and releases everything the node set
+// up: firewall rules, load balancer targets, DNS records, the floating IP, the
+// SSH key and orphaned shared resources.
+func (d *Driver) removeResources(ctx context.Context) error {
 	// Ensure we have the public IP for firewall cleanup (may be missing on older machines)
-	if d.PublicIPv4 == "" && d.ServerID != 0 {
-		if ip, err := d.fetchPublicIPv4(ctx); err == nil {
-			d.PublicIPv4 = ip
+	if d.firewallSource() == "" && d.ServerID != 0 {
+		if err := d.updateFirewallSource(ctx); err != nil {
+			log.Debugf("No public IP for firewall cleanup: %v", err)
 		}
 	}
 
 	// Remove this node's IP from the shared firewall before deleting the server
 	d.removeNodeFromFirewall(ctx)
 
+	// Stop sending control-plane traffic to the server before deleting it
+	if d.CreateLoadBalancer {
+		d.removeLoadBalancerTarget(ctx)
+	}
+
+	// Stop resolving the node's names before it goes away
+	d.deleteDNSRecords(ctx)
+
+	// Hand the floating IP to a surviving node before this one goes away
+	if d.CreateFloatingIP {
+		d.reassignFloatingIP(ctx)
+	}
+
 	// Delete server — this is the critical operation; if it fails, return an error
 	// so Rancher knows the machine was not fully removed and can retry.
 	var serverDelErr error
@@ -781,32 +1159,45 @@ func (d *Driver) Remove() error {
 	if d.CreateFirewall {
 		d.deleteFirewallIfOrphaned(ctx)
 	}
+	if d.AutoPlacementGroup {
+		d.deletePlacementGroupIfEmpty(ctx)
+	}
+	if d.CreateLoadBalancer {
+		d.deleteLoadBalancerIfOrphaned(ctx)
+	}
+	if d.IngressLoadBalancer {
+		d.deleteIngressLoadBalancerIfUnused(ctx)
+	}
+	if d.CreateFloatingIP {
+		d.deleteFloatingIPIfUnused(ctx)
+	}
 
 	return serverDelErr
 }
 
-// cleanupServer performs best-effort deletion of the server and SSH key.
-// Called when Create() fails after the server was already provisioned (e.g.
-// firewall setup failure) to avoid leaking the server in Hetzner.
-func (d *Driver) cleanupServer(ctx context.Context) {
+// cleanupFailedCreate deletes the server, if it was created, and releases
+// everything Create() set up for it, as Remove() would. Rancher may not call
+// Remove() when Create() fails, so the server and its shared resources would
+// leak otherwise. It uses a fresh context, the create context may be near its
+// deadline.
+func (d *Driver) cleanupFailedCreate() {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+	if err := d.removeResources(ctx); err != nil {
+		log.Warnf("Failed to clean up server %d after create failure: %v", d.ServerID, err)
+		return
+	}
 	if d.ServerID != 0 {
-		server, _, err := d.getClient().Server.GetByID(ctx, d.ServerID)
-		if err != nil {
-			log.Warnf("Failed to get server %d for cleanup: %v", d.ServerID, err)
-		} else if server != nil {
-			result, _, err := d.getClient().Server.DeleteWithResult(ctx, server)
-			if err != nil {
-				log.Warnf("Failed to delete server %d during cleanup: %v", d.ServerID, err)
-			} else if err := d.waitForAction(ctx, result.Action); err != nil {
-				log.Warnf("Server %d cleanup deletion action failed: %v", d.ServerID, err)
-			} else {
-				log.Infof("Cleaned up server %d after firewall setup failure", d.ServerID)
-			}
-		}
+		log.Infof("Cleaned up server %d after create failure", d.ServerID)
 	}
-	d.deleteSSHKey(ctx)
 }
 
+// deleteSSHKey deletes the machine's SSH key if the driver created it and no
+// other machine still uses it; otherwise only this machine's reference label
+// is removed. Keys without any reference labels predate them and belong to
+// the machine that uploaded them: a second machine reusing such a key labels
+// it for both (see ensureSSHKey), so a key still without labels is this
+// machine's alone.
 func (d *Driver) deleteSSHKey(ctx context.Context) {
 	if d.SSHKeyID == 0 {
 		return
@@ -820,6 +1211,31 @@ func (d *Driver) deleteSSHKey(ctx context.Context) {
 	if sshKey == nil {
 		return
 	}
+	if !isDriverSSHKey(sshKey) {
+		log.Infof("Keeping SSH key %q (ID=%d), it was not created by the driver", sshKey.Name, sshKey.ID)
+		return
+	}
+
+	labels := make(map[string]string, len(sshKey.Labels))
+	var users []string
+	for k, v := range sshKey.Labels {
+		if k == d.sshKeyRefLabel() {
+			continue
+		}
+		labels[k] = v
+		if strings.HasPrefix(k, sshKeyRefLabelPrefix) {
+			users = append(users, strings.TrimPrefix(k, sshKeyRefLabelPrefix))
+		}
+	}
+	if len(users) > 0 {
+		log.Infof("Keeping SSH key %q (ID=%d), still used by %s", sshKey.Name, sshKey.ID, strings.Join(users, ", "))
+		if len(labels) != len(sshKey.Labels) {
+			if _, _, err := d.getClient().SSHKey.Update(ctx, sshKey, hcloud.SSHKeyUpdateOpts{Labels: labels}); err != nil {
+				log.Warnf("Failed to remove %q from the users of SSH key %d: %v", d.MachineName, d.SSHKeyID, err)
+			}
+		}
+		return
+	}
 
 	_, err = d.getClient().SSHKey.Delete(ctx, sshKey)
 	if err != nil {
@@ -839,27 +1255,6 @@ func (d *Driver) waitForAction(ctx context.Context, action *hcloud.Action) error
 	return nil
 }
 
-// WaitForSSH waits until the server accepts SSH connections.
-func WaitForSSH(d *Driver) error {
-	ip, err := d.GetIP()
-	if err != nil {
-		return err
-	}
-
-	log.Infof("Waiting for SSH on %s:%d...", ip, d.SSHPort)
-
-	return mcnutils.WaitForSpecific(func() bool {
-		conn, err := net.DialTimeout("tcp",
-			net.JoinHostPort(ip, strconv.Itoa(d.SSHPort)),
-			5*time.Second)
-		if err != nil {
-			return false
-		}
-		conn.Close()
-		return true
-	}, 60, 3*time.Second)
-}
-
 // GetSSHUsername returns the SSH user to use.
 func (d *Driver) GetSSHUsername() string {
 	if d.SSHUser != "" {
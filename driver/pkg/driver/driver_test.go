@@ -9,6 +9,7 @@ import (
 	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"testing"
 	"time"
@@ -179,6 +180,91 @@ func registerActionPoller(mux *http.ServeMux, actionID int64) {
 	})
 }
 
+// registerServers serves GET and DELETE /servers/{id} for the given servers
+// and not_found for any other ID. Deleted IDs are appended to deleted unless
+// it is nil.
+func registerServers(mux *http.ServeMux, deleted *[]int64, servers ...schema.Server) {
+	mux.HandleFunc("/servers/", func(w http.ResponseWriter, r *http.Request) {
+		for _, s := range servers {
+			if r.URL.Path != "/servers/"+strconv.FormatInt(s.ID, 10) {
+				continue
+			}
+			if r.Method == http.MethodDelete {
+				if deleted != nil {
+					*deleted = append(*deleted, s.ID)
+				}
+				jsonResponse(w, http.StatusOK, schema.ServerDeleteResponse{Action: completedAction(1)})
+				return
+			}
+			jsonResponse(w, http.StatusOK, schema.ServerGetResponse{Server: s})
+			return
+		}
+		jsonResponse(w, http.StatusNotFound, schema.ErrorResponse{
+			Error: schema.Error{Code: "not_found", Message: "server not found"},
+		})
+	})
+}
+
+// clusterNodeDriver returns a driver for server 100 of cluster "prod".
+func clusterNodeDriver(t *testing.T, mux *http.ServeMux) *Driver {
+	d, _ := newTestDriver(t, mux)
+	d.ClusterID = "prod"
+	d.ServerID = 100
+	return d
+}
+
+// createMux wraps a feature mux with what Create() needs to bring up server
+// 100: SSH key upload and removal, type, image and location lookup, and the
+// server create. Everything else, including the server itself, is served by
+// next. sshKeyDeleted is set when the SSH key is deleted.
+func createMux(next http.Handler, sshKeyDeleted *bool) *http.ServeMux {
+	mux := http.NewServeMux()
+	registerStandardEndpoints(mux)
+	mux.HandleFunc("/ssh_keys", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			jsonResponse(w, http.StatusCreated, schema.SSHKeyCreateResponse{
+				SSHKey: schema.SSHKey{ID: 100, Name: "rancher-machine-test-machine"},
+			})
+			return
+		}
+		jsonResponse(w, http.StatusOK, schema.SSHKeyListResponse{SSHKeys: []schema.SSHKey{}})
+	})
+	mux.HandleFunc("/ssh_keys/100", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodDelete {
+			*sshKeyDeleted = true
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		jsonResponse(w, http.StatusOK, schema.SSHKeyGetResponse{SSHKey: schema.SSHKey{
+			ID:     100,
+			Name:   "rancher-machine-test-machine",
+			Labels: map[string]string{"managed-by": "rancher-machine", sshKeyRefLabelPrefix + "test-machine": "true"},
+		}})
+	})
+	mux.HandleFunc("/servers", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			next.ServeHTTP(w, r)
+			return
+		}
+		jsonResponse(w, http.StatusCreated, schema.ServerCreateResponse{
+			Server: standardServer(100, "initializing"),
+			Action: completedAction(1),
+		})
+	})
+	mux.Handle("/", next)
+	return mux
+}
+
+// createDriver returns a cluster node driver whose Create() runs against mux.
+func createDriver(t *testing.T, mux *http.ServeMux) *Driver {
+	d := clusterNodeDriver(t, mux)
+	d.ServerID = 0
+	sshDir := t.TempDir()
+	d.BaseDriver.SSHKeyPath = filepath.Join(sshDir, "id_rsa")
+	d.BaseDriver.StorePath = sshDir
+	return d
+}
+
 // ---------------------------------------------------------------------------
 // PreCreateCheck tests
 // ---------------------------------------------------------------------------
@@ -360,18 +446,57 @@ func TestPreCreateCheck_InvalidSSHKey(t *testing.T) {
 	}
 }
 
-func TestPreCreateCheck_FirewallWithDisabledIPv4(t *testing.T) {
+func TestPreCreateCheck_FirewallWithoutPublicIP(t *testing.T) {
 	d, _ := newTestDriver(t, http.NewServeMux())
 	d.CreateFirewall = true
 	d.AutoCreateFirewallRules = true
 	d.DisablePublicIPv4 = true
+	d.DisablePublicIPv6 = true
+	d.UsePrivateNetwork = true
 
 	err := d.PreCreateCheck()
 	if err == nil {
-		t.Fatal("expected error when CreateFirewall + AutoCreateFirewallRules + DisablePublicIPv4")
+		t.Fatal("expected error when CreateFirewall + AutoCreateFirewallRules without public IPs")
+	}
+	if !strings.Contains(err.Error(), "public IP") {
+		t.Errorf("error = %q, want it to mention 'public IP'", err)
+	}
+}
+
+func TestPreCreateCheck_IPv6Only(t *testing.T) {
+	mux := http.NewServeMux()
+	registerStandardEndpoints(mux)
+	d, _ := newTestDriver(t, mux)
+	d.ClusterID = "test-cluster"
+	d.CreateFirewall = true
+	d.AutoCreateFirewallRules = true
+	d.DisablePublicIPv4 = true
+
+	if err := d.PreCreateCheck(); err != nil {
+		t.Fatalf("PreCreateCheck() should accept an IPv6-only node: %v", err)
+	}
+}
+
+func TestPreCreateCheck_AddressFamilyUnavailable(t *testing.T) {
+	tests := []struct {
+		name   string
+		family string
+		setup  func(d *Driver)
+	}{
+		{"ipv4 without IPv4", addressFamilyIPv4, func(d *Driver) { d.DisablePublicIPv4 = true }},
+		{"ipv6 without IPv6", addressFamilyIPv6, func(d *Driver) { d.DisablePublicIPv6 = true }},
 	}
-	if !strings.Contains(err.Error(), "public IPv4") {
-		t.Errorf("error = %q, want it to mention 'public IPv4'", err)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d, _ := newTestDriver(t, http.NewServeMux())
+			d.AddressFamily = tt.family
+			tt.setup(d)
+
+			err := d.PreCreateCheck()
+			if err == nil || !strings.Contains(err.Error(), "--hetzner-address-family") {
+				t.Fatalf("PreCreateCheck() error = %v, want hetzner-address-family error", err)
+			}
+		})
 	}
 }
 
@@ -596,6 +721,52 @@ func TestGetIP_NoIPAvailable(t *testing.T) {
 	}
 }
 
+func TestGetIP_AddressFamily(t *testing.T) {
+	tests := []struct {
+		name    string
+		family  string
+		noIPv4  bool
+		want    string
+		wantErr bool
+	}{
+		{"auto prefers IPv4", addressFamilyAuto, false, "1.2.3.4", false},
+		{"auto falls back to IPv6", addressFamilyAuto, true, "2001:db8::1", false},
+		{"unset falls back to IPv6", "", true, "2001:db8::1", false},
+		{"ipv6", addressFamilyIPv6, false, "2001:db8::1", false},
+		{"ipv4 without IPv4", addressFamilyIPv4, true, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mux := http.NewServeMux()
+			mux.HandleFunc("/servers/", func(w http.ResponseWriter, r *http.Request) {
+				s := standardServer(123, "running")
+				if tt.noIPv4 {
+					s.PublicNet.IPv4.IP = ""
+				}
+				jsonResponse(w, http.StatusOK, schema.ServerGetResponse{Server: s})
+			})
+
+			d, _ := newTestDriver(t, mux)
+			d.ServerID = 123
+			d.AddressFamily = tt.family
+
+			ip, err := d.GetIP()
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("GetIP() = %q, want error", ip)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("GetIP() error: %v", err)
+			}
+			if ip != tt.want {
+				t.Errorf("GetIP() = %q, want %q", ip, tt.want)
+			}
+		})
+	}
+}
+
 // ---------------------------------------------------------------------------
 // GetSSHHostname / GetURL tests
 // ---------------------------------------------------------------------------
@@ -626,6 +797,22 @@ func TestGetURL(t *testing.T) {
 	}
 }
 
+func TestGetURL_IPv6(t *testing.T) {
+	d := NewDriver("test", t.TempDir(), "test")
+	d.IPAddress = "2001:db8::1"
+
+	url, err := d.GetURL()
+	if err != nil {
+		t.Fatalf("GetURL() error: %v", err)
+	}
+	if url != "tcp://[2001:db8::1]:2376" {
+		t.Errorf("GetURL() = %q, want %q", url, "tcp://[2001:db8::1]:2376")
+	}
+	if hostname, _ := d.GetSSHHostname(); hostname != "2001:db8::1" {
+		t.Errorf("GetSSHHostname() = %q, want the unbracketed %q", hostname, "2001:db8::1")
+	}
+}
+
 // ---------------------------------------------------------------------------
 // GetSSHUsername tests
 // ---------------------------------------------------------------------------
@@ -789,7 +976,7 @@ func TestRemove(t *testing.T) {
 			return
 		}
 		jsonResponse(w, http.StatusOK, schema.SSHKeyGetResponse{
-			SSHKey: schema.SSHKey{ID: 456, Name: "rancher-machine-test"},
+			SSHKey: schema.SSHKey{ID: 456, Name: "rancher-machine-test", Labels: map[string]string{"managed-by": "rancher-machine"}},
 		})
 	})
 	registerActionPoller(mux, 10)
@@ -820,7 +1007,7 @@ func TestRemove_NoServerID(t *testing.T) {
 			return
 		}
 		jsonResponse(w, http.StatusOK, schema.SSHKeyGetResponse{
-			SSHKey: schema.SSHKey{ID: 456, Name: "rancher-machine-test"},
+			SSHKey: schema.SSHKey{ID: 456, Name: "rancher-machine-test", Labels: map[string]string{"managed-by": "rancher-machine"}},
 		})
 	})
 
@@ -849,7 +1036,7 @@ func TestRemove_ServerAlreadyGone(t *testing.T) {
 			return
 		}
 		jsonResponse(w, http.StatusOK, schema.SSHKeyGetResponse{
-			SSHKey: schema.SSHKey{ID: 456, Name: "rancher-machine-test"},
+			SSHKey: schema.SSHKey{ID: 456, Name: "rancher-machine-test", Labels: map[string]string{"managed-by": "rancher-machine"}},
 		})
 	})
 
@@ -901,7 +1088,7 @@ func TestRemove_ServerAPIError_ReturnsError(t *testing.T) {
 			return
 		}
 		jsonResponse(w, http.StatusOK, schema.SSHKeyGetResponse{
-			SSHKey: schema.SSHKey{ID: 456, Name: "rancher-machine-test"},
+			SSHKey: schema.SSHKey{ID: 456, Name: "rancher-machine-test", Labels: map[string]string{"managed-by": "rancher-machine"}},
 		})
 	})
 
@@ -1601,6 +1788,7 @@ func TestCreate_FullFlow(t *testing.T) {
 	d.BaseDriver.SSHKeyPath = filepath.Join(sshDir, "id_rsa")
 	d.BaseDriver.StorePath = sshDir
 
+	acceptSSH(t)
 	if err := d.Create(); err != nil {
 		t.Fatalf("Create() error: %v", err)
 	}
@@ -1644,7 +1832,7 @@ func TestCreate_ServerFailure_CleansUpSSHKey(t *testing.T) {
 			return
 		}
 		jsonResponse(w, http.StatusOK, schema.SSHKeyGetResponse{
-			SSHKey: schema.SSHKey{ID: 100, Name: "rancher-machine-test-machine"},
+			SSHKey: schema.SSHKey{ID: 100, Name: "rancher-machine-test-machine", Labels: map[string]string{"managed-by": "rancher-machine"}},
 		})
 	})
 
@@ -1774,6 +1962,7 @@ func TestCreate_WithFirewall(t *testing.T) {
 	d.BaseDriver.SSHKeyPath = filepath.Join(sshDir, "id_rsa")
 	d.BaseDriver.StorePath = sshDir
 
+	acceptSSH(t)
 	if err := d.Create(); err != nil {
 		t.Fatalf("Create() error: %v", err)
 	}
@@ -1815,7 +2004,7 @@ func TestCreate_FirewallFailure_CleansUpServer(t *testing.T) {
 			return
 		}
 		jsonResponse(w, http.StatusOK, schema.SSHKeyGetResponse{
-			SSHKey: schema.SSHKey{ID: 100, Name: "rancher-machine-test-pool-abc12-def34"},
+			SSHKey: schema.SSHKey{ID: 100, Name: "rancher-machine-test-pool-abc12-def34", Labels: map[string]string{"managed-by": "rancher-machine"}},
 		})
 	})
 
@@ -1979,7 +2168,7 @@ func testCtx(t *testing.T) context.Context {
 // ---------------------------------------------------------------------------
 
 func TestRKE2PublicRules(t *testing.T) {
-	rules := rke2PublicRules()
+	rules := rke2PublicRules(22)
 
 	if len(rules) == 0 {
 		t.Fatal("expected non-empty rules")
@@ -2106,6 +2295,14 @@ func TestIPToIPNet(t *testing.T) {
 		t.Errorf("IPv4: got %s, want 1.2.3.4/32", ipNet.String())
 	}
 
+	ipNet, err = ipToIPNet("2001:db8::/64")
+	if err != nil {
+		t.Fatalf("ipToIPNet() error: %v", err)
+	}
+	if ipNet.String() != "2001:db8::/64" {
+		t.Errorf("IPv6 network: got %s, want 2001:db8::/64", ipNet.String())
+	}
+
 	// Invalid IP should return error, not panic
 	_, err = ipToIPNet("not-an-ip")
 	if err == nil {
@@ -2141,7 +2338,7 @@ func TestRebuildRulesWithNodeIP(t *testing.T) {
 	ip2 := testIPNet(t, "10.0.0.2")
 
 	// Start with public rules + internal for ip1
-	rules := append(rke2PublicRules(), rke2InternalRules([]net.IPNet{ip1})...)
+	rules := append(rke2PublicRules(22), rke2InternalRules([]net.IPNet{ip1})...)
 
 	// Add ip2
 	updated := rebuildRulesWithNodeIP(rules, ip2)
@@ -2164,7 +2361,7 @@ func TestRebuildRulesWithoutNodeIP(t *testing.T) {
 	ip1 := testIPNet(t, "10.0.0.1")
 	ip2 := testIPNet(t, "10.0.0.2")
 
-	rules := append(rke2PublicRules(), rke2InternalRules([]net.IPNet{ip1, ip2})...)
+	rules := append(rke2PublicRules(22), rke2InternalRules([]net.IPNet{ip1, ip2})...)
 
 	// Remove ip1
 	updated := rebuildRulesWithoutNodeIP(rules, ip1)
@@ -2407,6 +2604,78 @@ func TestAddNodeToFirewall_AlreadyPresent(t *testing.T) {
 	}
 }
 
+func TestAddNodeToFirewall_AddsSSHPortRule(t *testing.T) {
+	// Node IP already present, but this pool uses SSH port 2222
+	existingRules := []schema.FirewallRule{
+		testFWRule("in", "tcp", "22", []string{"0.0.0.0/0", "::/0"}, "SSH"),
+		testFWRule("in", "tcp", "9345", []string{"10.0.0.1/32"}, "RKE2 supervisor API (cluster nodes only)"),
+	}
+
+	var setRules []schema.FirewallRuleRequest
+	mux := http.NewServeMux()
+	mux.HandleFunc("/firewalls/50", func(w http.ResponseWriter, r *http.Request) {
+		jsonResponse(w, http.StatusOK, schema.FirewallGetResponse{
+			Firewall: schema.Firewall{ID: 50, Name: "rancher-test", Rules: existingRules},
+		})
+	})
+	mux.HandleFunc("/firewalls/50/actions/set_rules", func(w http.ResponseWriter, r *http.Request) {
+		var req schema.FirewallActionSetRulesRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Fatalf("decode set_rules request: %v", err)
+		}
+		setRules = req.Rules
+		existingRules = append(existingRules, testFWRule("in", "tcp", "2222", []string{"0.0.0.0/0", "::/0"}, "SSH"))
+		jsonResponse(w, http.StatusCreated, schema.FirewallActionSetRulesResponse{
+			Actions: []schema.Action{completedAction(70)},
+		})
+	})
+	registerActionPoller(mux, 70)
+
+	d, _ := newTestDriver(t, mux)
+	d.FirewallID = 50
+	d.PublicIPv4 = "10.0.0.1"
+	d.SSHPort = 2222
+	d.AutoCreateFirewallRules = true
+
+	if err := d.addNodeToFirewall(testCtx(t)); err != nil {
+		t.Fatalf("addNodeToFirewall() error: %v", err)
+	}
+
+	var ports []string
+	for _, rule := range setRules {
+		if rule.Description != nil && *rule.Description == "SSH" && rule.Port != nil {
+			ports = append(ports, *rule.Port)
+		}
+	}
+	if len(ports) != 2 || ports[0] != "22" || ports[1] != "2222" {
+		t.Errorf("SSH rule ports = %v, want [22 2222]", ports)
+	}
+}
+
+func TestWithSSHPortRule(t *testing.T) {
+	rules := rke2PublicRules(22)
+
+	if _, added := withSSHPortRule(rules, 22); added {
+		t.Error("withSSHPortRule() added a rule for a port already allowed")
+	}
+
+	updated, added := withSSHPortRule(rules, 2222)
+	if !added || len(updated) != len(rules)+1 {
+		t.Fatalf("withSSHPortRule() = %d rules (added=%v), want %d", len(updated), added, len(rules)+1)
+	}
+	rule := updated[len(updated)-1]
+	if *rule.Port != "2222" || *rule.Description != "SSH" || len(rule.SourceIPs) != 2 {
+		t.Errorf("added rule = port %s, %q, sources %v", *rule.Port, *rule.Description, rule.SourceIPs)
+	}
+	if *rules[0].Port != "22" {
+		t.Errorf("original SSH rule port changed to %s", *rules[0].Port)
+	}
+
+	if _, added := withSSHPortRule(rke2InternalRules(nil), 2222); added {
+		t.Error("withSSHPortRule() added a rule to a firewall without SSH rule")
+	}
+}
+
 func TestDeleteFirewallIfOrphaned_NoServers(t *testing.T) {
 	deleted := false
 
@@ -3563,6 +3832,76 @@ func TestRegisterWithClusterFirewall_Success(t *testing.T) {
 	}
 }
 
+func TestRegisterWithClusterFirewall_IPv6Only(t *testing.T) {
+	var setRules schema.FirewallActionSetRulesRequest
+
+	existingFW := schema.Firewall{
+		ID:   80,
+		Name: "rancher-test-cluster",
+		Rules: []schema.FirewallRule{
+			testFWRule("in", "tcp", "22", []string{"0.0.0.0/0"}, "SSH"),
+			testFWRule("in", "tcp", "9345", []string{"10.0.0.1/32"}, "RKE2 supervisor API (cluster nodes only)"),
+		},
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/servers/200", func(w http.ResponseWriter, r *http.Request) {
+		s := standardServer(200, "running")
+		s.PublicNet.IPv4.IP = ""
+		jsonResponse(w, http.StatusOK, schema.ServerGetResponse{Server: s})
+	})
+	mux.HandleFunc("/firewalls", func(w http.ResponseWriter, r *http.Request) {
+		jsonResponse(w, http.StatusOK, schema.FirewallListResponse{
+			Firewalls: []schema.Firewall{existingFW},
+		})
+	})
+	getCount := 0
+	mux.HandleFunc("/firewalls/80", func(w http.ResponseWriter, r *http.Request) {
+		getCount++
+		rules := existingFW.Rules
+		if getCount > 1 {
+			rules = []schema.FirewallRule{
+				testFWRule("in", "tcp", "22", []string{"0.0.0.0/0"}, "SSH"),
+				testFWRule("in", "tcp", "9345", []string{"10.0.0.1/32", "2001:db8::/64"}, "RKE2 supervisor API (cluster nodes only)"),
+			}
+		}
+		jsonResponse(w, http.StatusOK, schema.FirewallGetResponse{
+			Firewall: schema.Firewall{ID: 80, Name: "rancher-test-cluster", Rules: rules},
+		})
+	})
+	mux.HandleFunc("/firewalls/80/actions/set_rules", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewDecoder(r.Body).Decode(&setRules)
+		jsonResponse(w, http.StatusCreated, schema.FirewallActionSetRulesResponse{
+			Actions: []schema.Action{completedAction(95)},
+		})
+	})
+	registerActionPoller(mux, 95)
+
+	d, _ := newTestDriver(t, mux)
+	d.ServerID = 200
+	d.ClusterID = "test-cluster"
+	d.DisablePublicIPv4 = true
+
+	if err := d.registerWithClusterFirewall(testCtx(t)); err != nil {
+		t.Fatalf("registerWithClusterFirewall() error: %v", err)
+	}
+	if d.PublicIPv4 != "" || d.PublicIPv6 != "2001:db8::/64" {
+		t.Errorf("PublicIPv4 = %q, PublicIPv6 = %q, want only the IPv6 network 2001:db8::/64", d.PublicIPv4, d.PublicIPv6)
+	}
+	found := false
+	for _, rule := range setRules.Rules {
+		if rule.Port == nil || *rule.Port != "9345" {
+			continue
+		}
+		for _, src := range rule.SourceIPs {
+			found = found || src == "2001:db8::/64"
+		}
+	}
+	if !found {
+		t.Errorf("set_rules = %+v, want 2001:db8::/64 as a source of the internal rules", setRules.Rules)
+	}
+}
+
 func TestRegisterWithClusterFirewall_NoFirewall(t *testing.T) {
 	mux := http.NewServeMux()
 
@@ -3598,7 +3937,7 @@ func TestRegisterWithClusterFirewall_NoFirewall(t *testing.T) {
 // TestSetupFirewall_DisablePublicIPv4_SkipsAddNode verifies that when
 // DisablePublicIPv4=true, setupFirewall attaches the firewall but does NOT
 // attempt to add the node's IP to internal rules (since there is no public IP).
-func TestSetupFirewall_DisablePublicIPv4_SkipsAddNode(t *testing.T) {
+func TestSetupFirewall_NoPublicIP_SkipsAddNode(t *testing.T) {
 	attachCalled := false
 	setRulesCalled := false
 
@@ -3644,6 +3983,7 @@ func TestSetupFirewall_DisablePublicIPv4_SkipsAddNode(t *testing.T) {
 	d.CreateFirewall = true
 	d.AutoCreateFirewallRules = false
 	d.DisablePublicIPv4 = true
+	d.DisablePublicIPv6 = true
 
 	err := d.setupFirewall(testCtx(t))
 	if err != nil {
@@ -3654,7 +3994,7 @@ func TestSetupFirewall_DisablePublicIPv4_SkipsAddNode(t *testing.T) {
 		t.Error("firewall should have been attached to server")
 	}
 	if setRulesCalled {
-		t.Error("SetRules should NOT be called when DisablePublicIPv4=true (no IP to add)")
+		t.Error("SetRules should NOT be called without public IPs (no IP to add)")
 	}
 	if d.PublicIPv4 != "" {
 		t.Errorf("PublicIPv4 = %q, want empty", d.PublicIPv4)
@@ -7,6 +7,7 @@ import (
 	"math/rand"
 	"net"
 	"regexp"
+	"strconv"
 	"strings"
 	"time"
 
@@ -25,11 +26,12 @@ const (
 func strPtr(s string) *string { return &s }
 
 // rke2PublicRules returns firewall rules for RKE2 ports that are typically
-// made publicly reachable (SSH, Kubernetes API, NodePorts, ICMP, all outbound).
+// made publicly reachable (SSH on sshPort, Kubernetes API, NodePorts, ICMP,
+// all outbound).
 // Note: These rules allow access from any IP (0.0.0.0/0 and ::/0). Depending
 // on your security requirements, you may want to restrict the allowed source
 // ranges by using custom firewall rules instead of auto-generated ones.
-func rke2PublicRules() []hcloud.FirewallRule {
+func rke2PublicRules(sshPort int) []hcloud.FirewallRule {
 	anyIPv4 := mustParseCIDR("0.0.0.0/0")
 	anyIPv6 := mustParseCIDR("::/0")
 	anySource := []net.IPNet{anyIPv4, anyIPv6}
@@ -39,9 +41,9 @@ func rke2PublicRules() []hcloud.FirewallRule {
 		{
 			Direction:   hcloud.FirewallRuleDirectionIn,
 			Protocol:    hcloud.FirewallRuleProtocolTCP,
-			Port:        strPtr("22"),
+			Port:        strPtr(strconv.Itoa(sshPort)),
 			SourceIPs:   anySource,
-			Description: strPtr("SSH"),
+			Description: strPtr(sshRuleDescription),
 		},
 		// Kubernetes API
 		{
@@ -225,13 +227,13 @@ func (d *Driver) findOrCreateSharedFirewall(ctx context.Context) (*hcloud.Firewa
 
 	var rules []hcloud.FirewallRule
 	if d.AutoCreateFirewallRules {
-		nodeIP, err := ipToIPNet(d.PublicIPv4)
+		nodeIP, err := ipToIPNet(d.firewallSource())
 		if err != nil {
 			return nil, false, fmt.Errorf("invalid public IP for firewall: %w", err)
 		}
-		rules = append(rules, rke2PublicRules()...)
+		rules = append(rules, rke2PublicRules(d.sshPort())...)
 		rules = append(rules, rke2InternalRules([]net.IPNet{nodeIP})...)
-		log.Infof("Creating shared firewall %q with %d rules (public + internal for %s)...", name, len(rules), d.PublicIPv4)
+		log.Infof("Creating shared firewall %q with %d rules (public + internal for %s)...", name, len(rules), d.firewallSource())
 	} else {
 		log.Infof("Creating shared firewall %q (no rules)...", name)
 	}
@@ -275,10 +277,11 @@ func (d *Driver) findOrCreateSharedFirewall(ctx context.Context) (*hcloud.Firewa
 // This runs regardless of AutoCreateFirewallRules — every node in the cluster
 // needs its IP whitelisted so that other nodes' firewalls allow traffic from it.
 func (d *Driver) addNodeToFirewall(ctx context.Context) error {
-	nodeIP, err := ipToIPNet(d.PublicIPv4)
+	nodeIP, err := ipToIPNet(d.firewallSource())
 	if err != nil {
 		return fmt.Errorf("invalid public IP for firewall rules: %w", err)
 	}
+	sshPort := d.sshPort()
 
 	for attempt := 0; attempt < maxFirewallRetries; attempt++ {
 		if attempt > 0 {
@@ -300,14 +303,17 @@ func (d *Driver) addNodeToFirewall(ctx context.Context) error {
 			return fmt.Errorf("firewall %d not found", d.FirewallID)
 		}
 
+		// Pools of a cluster may use different SSH ports; the firewall carries all of them
+		rules, sshRuleAdded := withSSHPortRule(fw.Rules, sshPort)
+
 		// Check if our IP is already present in internal rules
-		if firewallHasNodeIP(fw.Rules, nodeIP) {
-			log.Infof("Node IP %s already present in firewall rules", d.PublicIPv4)
+		if firewallHasNodeIP(fw.Rules, nodeIP) && !sshRuleAdded {
+			log.Infof("Node IP %s already present in firewall rules", d.firewallSource())
 			return nil
 		}
 
 		// Build updated rules: keep public + outbound rules, rebuild internal rules with new IP
-		updatedRules := rebuildRulesWithNodeIP(fw.Rules, nodeIP)
+		updatedRules := rebuildRulesWithNodeIP(rules, nodeIP)
 
 		// Apply updated rules
 		actions, _, err := d.getClient().Firewall.SetRules(ctx, fw, hcloud.FirewallSetRulesOpts{
@@ -334,13 +340,15 @@ func (d *Driver) addNodeToFirewall(ctx context.Context) error {
 			continue
 		}
 		if fw != nil && firewallHasNodeIP(fw.Rules, nodeIP) {
-			log.Infof("Node IP %s added to firewall rules", d.PublicIPv4)
-			return nil
+			if _, missing := withSSHPortRule(fw.Rules, sshPort); !missing {
+				log.Infof("Node IP %s added to firewall rules", d.firewallSource())
+				return nil
+			}
 		}
-		log.Warnf("Node IP %s not found after update (attempt %d), retrying...", d.PublicIPv4, attempt+1)
+		log.Warnf("Node IP %s not found after update (attempt %d), retrying...", d.firewallSource(), attempt+1)
 	}
 
-	return fmt.Errorf("failed to add node IP %s to firewall after %d retries", d.PublicIPv4, maxFirewallRetries)
+	return fmt.Errorf("failed to add node IP %s to firewall after %d retries", d.firewallSource(), maxFirewallRetries)
 }
 
 // removeNodeFromFirewall removes the node's IP from the shared firewall's internal rules.
@@ -348,13 +356,13 @@ func (d *Driver) addNodeToFirewall(ctx context.Context) error {
 // This runs regardless of AutoCreateFirewallRules — if the node's IP was added
 // to the firewall (which now happens for all cluster nodes), it must be cleaned up.
 func (d *Driver) removeNodeFromFirewall(ctx context.Context) {
-	if d.FirewallID == 0 || d.PublicIPv4 == "" {
+	if d.FirewallID == 0 || d.firewallSource() == "" {
 		return
 	}
 
-	nodeIP, err := ipToIPNet(d.PublicIPv4)
+	nodeIP, err := ipToIPNet(d.firewallSource())
 	if err != nil {
-		log.Warnf("Invalid public IP %q, skipping firewall cleanup: %v", d.PublicIPv4, err)
+		log.Warnf("Invalid public IP %q, skipping firewall cleanup: %v", d.firewallSource(), err)
 		return
 	}
 
@@ -390,10 +398,10 @@ func (d *Driver) removeNodeFromFirewall(ctx context.Context) {
 		})
 		if err != nil {
 			if isNonRetriableError(err) {
-				log.Warnf("Non-retriable error removing node IP %s from firewall: %v", d.PublicIPv4, err)
+				log.Warnf("Non-retriable error removing node IP %s from firewall: %v", d.firewallSource(), err)
 				return
 			}
-			log.Warnf("Failed to remove node IP %s from firewall (attempt %d): %v", d.PublicIPv4, attempt+1, err)
+			log.Warnf("Failed to remove node IP %s from firewall (attempt %d): %v", d.firewallSource(), attempt+1, err)
 			continue
 		}
 
@@ -410,13 +418,13 @@ func (d *Driver) removeNodeFromFirewall(ctx context.Context) {
 			continue
 		}
 		if fw == nil || !firewallHasNodeIP(fw.Rules, nodeIP) {
-			log.Infof("Removed node IP %s from firewall rules", d.PublicIPv4)
+			log.Infof("Removed node IP %s from firewall rules", d.firewallSource())
 			return
 		}
-		log.Warnf("Node IP %s still present after removal (attempt %d), retrying...", d.PublicIPv4, attempt+1)
+		log.Warnf("Node IP %s still present after removal (attempt %d), retrying...", d.firewallSource(), attempt+1)
 	}
 
-	log.Warnf("Failed to remove node IP %s from firewall after %d retries", d.PublicIPv4, maxFirewallRetries)
+	log.Warnf("Failed to remove node IP %s from firewall after %d retries", d.firewallSource(), maxFirewallRetries)
 }
 
 // deleteFirewallIfOrphaned deletes the shared firewall if no servers are attached to it.
@@ -451,16 +459,43 @@ func (d *Driver) deleteFirewallIfOrphaned(ctx context.Context) {
 	}
 }
 
+// firewallSource returns the node's source in the shared firewall's internal
+// rules: its public IPv4 or, for a node without one, its public IPv6 network.
+func (d *Driver) firewallSource() string {
+	if d.PublicIPv4 != "" {
+		return d.PublicIPv4
+	}
+	return d.PublicIPv6
+}
+
+// updateFirewallSource fetches the node's firewall source (see firewallSource);
+// a node without public IPs has none.
+func (d *Driver) updateFirewallSource(ctx context.Context) error {
+	switch {
+	case !d.DisablePublicIPv4:
+		ip, err := d.fetchPublicIPv4(ctx)
+		if err != nil {
+			return err
+		}
+		d.PublicIPv4 = ip
+	case !d.DisablePublicIPv6:
+		network, err := d.fetchPublicIPv6Network(ctx)
+		if err != nil {
+			return err
+		}
+		d.PublicIPv6 = network
+	}
+	return nil
+}
+
 // registerWithClusterFirewall adds this node's IP to the cluster's shared
 // firewall without creating or attaching the firewall. Used by nodes with
 // CreateFirewall=false that still need to be whitelisted in the cluster firewall
 // so that other nodes' firewalls allow traffic from them.
 func (d *Driver) registerWithClusterFirewall(ctx context.Context) error {
-	publicIP, err := d.fetchPublicIPv4(ctx)
-	if err != nil {
+	if err := d.updateFirewallSource(ctx); err != nil {
 		return fmt.Errorf("failed to get public IP: %w", err)
 	}
-	d.PublicIPv4 = publicIP
 
 	fw, err := d.findSharedFirewall(ctx)
 	if err != nil {
@@ -472,7 +507,7 @@ func (d *Driver) registerWithClusterFirewall(ctx context.Context) error {
 	}
 
 	d.FirewallID = fw.ID
-	log.Infof("Found cluster firewall %q (ID=%d), adding node IP %s", fw.Name, fw.ID, d.PublicIPv4)
+	log.Infof("Found cluster firewall %q (ID=%d), adding node IP %s", fw.Name, fw.ID, d.firewallSource())
 	return d.addNodeToFirewall(ctx)
 }
 
@@ -505,6 +540,35 @@ func (d *Driver) attachFirewallToServer(ctx context.Context, fw *hcloud.Firewall
 
 // --- Helper functions ---
 
+// sshRuleDescription identifies the public SSH rules of the shared firewall.
+const sshRuleDescription = "SSH"
+
+// withSSHPortRule returns the rules with an SSH rule for port added, and
+// whether one had to be added. The new rule copies the sources of an
+// existing SSH rule; firewalls without SSH rule (rules managed by the user)
+// are left alone.
+func withSSHPortRule(rules []hcloud.FirewallRule, port int) ([]hcloud.FirewallRule, bool) {
+	var template *hcloud.FirewallRule
+	for i, rule := range rules {
+		if rule.Direction != hcloud.FirewallRuleDirectionIn || rule.Description == nil || *rule.Description != sshRuleDescription {
+			continue
+		}
+		if rule.Port != nil && *rule.Port == strconv.Itoa(port) {
+			return rules, false
+		}
+		template = &rules[i]
+	}
+	if template == nil {
+		return rules, false
+	}
+
+	rule := *template
+	rule.Port = strPtr(strconv.Itoa(port))
+	result := make([]hcloud.FirewallRule, 0, len(rules)+1)
+	result = append(result, rules...)
+	return append(result, rule), true
+}
+
 // firewallHasNodeIP checks if any internal rule already contains the given IP.
 func firewallHasNodeIP(rules []hcloud.FirewallRule, nodeIP net.IPNet) bool {
 	for _, rule := range rules {
@@ -596,7 +660,15 @@ func collectNodeIPs(rules []hcloud.FirewallRule) []net.IPNet {
 }
 
 // ipToIPNet converts an IP address string to a /32 (IPv4) or /128 (IPv6) IPNet.
+// A network in CIDR notation, such as an IPv6 /64, is returned as is.
 func ipToIPNet(ipStr string) (net.IPNet, error) {
+	if strings.Contains(ipStr, "/") {
+		_, network, err := net.ParseCIDR(ipStr)
+		if err != nil {
+			return net.IPNet{}, fmt.Errorf("invalid network %q", ipStr)
+		}
+		return *network, nil
+	}
 	ip := net.ParseIP(ipStr)
 	if ip == nil {
 		return net.IPNet{}, fmt.Errorf("invalid IP address %q", ipStr)
@@ -2,6 +2,7 @@ package driver
 
 import (
 	"fmt"
+	"strconv"
 
 	"github.com/rancher/machine/libmachine/drivers"
 	"github.com/rancher/machine/libmachine/mcnflag"
@@ -15,6 +16,13 @@ const (
 	defaultSSHPort        = 22
 )
 
+// Address families for --hetzner-address-family.
+const (
+	addressFamilyAuto = "auto" // public IPv4, else public IPv6
+	addressFamilyIPv4 = "ipv4"
+	addressFamilyIPv6 = "ipv6"
+)
+
 func (d *Driver) GetCreateFlags() []mcnflag.Flag {
 	return []mcnflag.Flag{
 		mcnflag.StringFlag{
@@ -28,18 +36,68 @@ func (d *Driver) GetCreateFlags() []mcnflag.Flag {
 			Usage:  "Hetzner Cloud server type (e.g. cx23, cx33, cx43)",
 			Value:  defaultServerType,
 		},
+		mcnflag.IntFlag{
+			Name:   "hetzner-min-cores",
+			EnvVar: "HETZNER_MIN_CORES",
+			Usage:  "Minimum vCPUs; setting any requirement selects the cheapest matching server type instead of --hetzner-server-type",
+		},
+		mcnflag.IntFlag{
+			Name:   "hetzner-min-memory",
+			EnvVar: "HETZNER_MIN_MEMORY",
+			Usage:  "Minimum memory in GB for server type auto-selection",
+		},
+		mcnflag.IntFlag{
+			Name:   "hetzner-min-disk",
+			EnvVar: "HETZNER_MIN_DISK",
+			Usage:  "Minimum disk size in GB for server type auto-selection",
+		},
+		mcnflag.StringFlag{
+			Name:   "hetzner-cpu-type",
+			EnvVar: "HETZNER_CPU_TYPE",
+			Usage:  "CPU type for server type auto-selection (shared or dedicated)",
+		},
+		mcnflag.StringSliceFlag{
+			Name:   "hetzner-architectures",
+			EnvVar: "HETZNER_ARCHITECTURES",
+			Usage:  "Allowed architectures for server type auto-selection (x86, arm)",
+		},
+		mcnflag.BoolFlag{
+			Name:   "hetzner-replace-deprecated-server-type",
+			EnvVar: "HETZNER_REPLACE_DEPRECATED_SERVER_TYPE",
+			Usage:  "Automatically switch to a replacement when the server type is deprecated or retired",
+		},
+		mcnflag.StringFlag{
+			Name:   "hetzner-server-type-successor",
+			EnvVar: "HETZNER_SERVER_TYPE_SUCCESSOR",
+			Usage:  "Replacement server type for a deprecated type (default: next-larger type of the same family)",
+		},
 		mcnflag.StringFlag{
 			Name:   "hetzner-server-location",
 			EnvVar: "HETZNER_SERVER_LOCATION",
 			Usage:  "Hetzner Cloud server location (e.g. fsn1, nbg1, hel1)",
 			Value:  defaultServerLocation,
 		},
+		mcnflag.StringSliceFlag{
+			Name:   "hetzner-server-locations",
+			EnvVar: "HETZNER_SERVER_LOCATIONS",
+			Usage:  "Spread machines across these locations (e.g. fsn1, nbg1, hel1); overrides hetzner-server-location",
+		},
 		mcnflag.StringFlag{
 			Name:   "hetzner-image",
 			EnvVar: "HETZNER_IMAGE",
 			Usage:  "Hetzner Cloud image name or ID (e.g. ubuntu-24.04, debian-12)",
 			Value:  defaultImage,
 		},
+		mcnflag.StringSliceFlag{
+			Name:   "hetzner-image-map",
+			EnvVar: "HETZNER_IMAGE_MAP",
+			Usage:  "Per-architecture image name or ID as <arch>=<image> (e.g. x86=ubuntu-24.04, arm=123456); chosen from the server type's architecture",
+		},
+		mcnflag.StringFlag{
+			Name:   "hetzner-image-selector",
+			EnvVar: "HETZNER_IMAGE_SELECTOR",
+			Usage:  "Label selector for a snapshot image (e.g. role=rke2-node,os=ubuntu); the newest match for the server type's architecture is used and overrides --hetzner-image",
+		},
 		mcnflag.BoolFlag{
 			Name:   "hetzner-use-private-network",
 			EnvVar: "HETZNER_USE_PRIVATE_NETWORK",
@@ -75,6 +133,83 @@ func (d *Driver) GetCreateFlags() []mcnflag.Flag {
 			EnvVar: "HETZNER_CLUSTER_ID",
 			Usage:  "Cluster identifier for shared firewall and resource labeling",
 		},
+		mcnflag.BoolFlag{
+			Name:   "hetzner-create-load-balancer",
+			EnvVar: "HETZNER_CREATE_LOAD_BALANCER",
+			Usage:  "Find or create the cluster's control-plane load balancer (6443, 9345) and add this server as a target",
+		},
+		mcnflag.StringFlag{
+			Name:   "hetzner-load-balancer-type",
+			EnvVar: "HETZNER_LOAD_BALANCER_TYPE",
+			Usage:  "Load balancer type used when creating the control-plane load balancer",
+			Value:  defaultLoadBalancerType,
+		},
+		mcnflag.StringFlag{
+			Name:   "hetzner-load-balancer-name",
+			EnvVar: "HETZNER_LOAD_BALANCER_NAME",
+			Usage:  "Name for the created control-plane load balancer (default: rancher-<cluster-name>-cp)",
+		},
+		mcnflag.BoolFlag{
+			Name:   "hetzner-ingress-load-balancer",
+			EnvVar: "HETZNER_INGRESS_LOAD_BALANCER",
+			Usage:  "Label this pool's servers ingress=true and find or create the cluster's ingress load balancer targeting them",
+		},
+		mcnflag.StringSliceFlag{
+			Name:   "hetzner-ingress-ports",
+			EnvVar: "HETZNER_INGRESS_PORTS",
+			Usage:  "TCP ports forwarded by the ingress load balancer (default: 80, 443)",
+		},
+		mcnflag.BoolFlag{
+			Name:   "hetzner-create-floating-ip",
+			EnvVar: "HETZNER_CREATE_FLOATING_IP",
+			Usage:  "Find or create the cluster's floating IP, configure it on this server and assign it to the first node",
+		},
+		mcnflag.StringFlag{
+			Name:   "hetzner-dns-api-token",
+			EnvVar: "HETZNER_DNS_API_TOKEN",
+			Usage:  "Hetzner DNS API token for managing node records",
+		},
+		mcnflag.StringFlag{
+			Name:   "hetzner-dns-zone",
+			EnvVar: "HETZNER_DNS_ZONE",
+			Usage:  "DNS zone for <machine>.<cluster> records (e.g. example.com); empty disables DNS records",
+		},
+		mcnflag.BoolFlag{
+			Name:   "hetzner-dns-api-record",
+			EnvVar: "HETZNER_DNS_API_RECORD",
+			Usage:  "Add this node to the round-robin api.<cluster> record",
+		},
+		mcnflag.BoolFlag{
+			Name:   "hetzner-dns-use-private-ip",
+			EnvVar: "HETZNER_DNS_USE_PRIVATE_IP",
+			Usage:  "Publish the node's private IP instead of its public addresses",
+		},
+		mcnflag.StringFlag{
+			Name:   "hetzner-dns-api-url",
+			EnvVar: "HETZNER_DNS_API_URL",
+			Usage:  "Hetzner DNS API base URL",
+			Value:  defaultDNSAPIURL,
+		},
+		mcnflag.StringFlag{
+			Name:   "hetzner-reverse-dns",
+			EnvVar: "HETZNER_REVERSE_DNS",
+			Usage:  "Reverse DNS template for the public IPs, e.g. {{.MachineName}}.nodes.example.com (fields: MachineName, ClusterID, Pool, Location)",
+		},
+		mcnflag.StringFlag{
+			Name:   "hetzner-pool",
+			EnvVar: "HETZNER_POOL",
+			Usage:  "Node pool name used for pool labels, placement groups and location spreading (default: derived from the machine name)",
+		},
+		mcnflag.StringSliceFlag{
+			Name:   "hetzner-quota-limits",
+			EnvVar: "HETZNER_QUOTA_LIMITS",
+			Usage:  "Project limits for the pre-flight check as <resource>=<limit> (servers, cores, primary-ips, firewalls, placement-group-servers); only configured limits are checked, 0 disables",
+		},
+		mcnflag.StringFlag{
+			Name:   "hetzner-cluster-monthly-budget",
+			EnvVar: "HETZNER_CLUSTER_MONTHLY_BUDGET",
+			Usage:  "Refuse to create a node that would raise the cluster's gross monthly cost above this amount (empty disables the check)",
+		},
 		mcnflag.BoolFlag{
 			Name:   "hetzner-disable-public-ipv4",
 			EnvVar: "HETZNER_DISABLE_PUBLIC_IPV4",
@@ -85,21 +220,118 @@ func (d *Driver) GetCreateFlags() []mcnflag.Flag {
 			EnvVar: "HETZNER_DISABLE_PUBLIC_IPV6",
 			Usage:  "Disable public IPv6 address",
 		},
+		mcnflag.StringFlag{
+			Name:   "hetzner-address-family",
+			EnvVar: "HETZNER_ADDRESS_FAMILY",
+			Usage:  "Public address to connect to without a private network: auto (IPv4, else IPv6), ipv4 or ipv6",
+			Value:  addressFamilyAuto,
+		},
 		mcnflag.StringFlag{
 			Name:   "hetzner-user-data",
 			EnvVar: "HETZNER_USER_DATA",
-			Usage:  "Cloud-init user data (string or file path)",
+			Usage:  "Cloud-init user data: inline, file path, file:// URL or base64:<data>; gzip is detected",
+		},
+		mcnflag.StringSliceFlag{
+			Name:   "hetzner-cloud-config",
+			EnvVar: "HETZNER_CLOUD_CONFIG",
+			Usage:  "Cloud-config fragment merged into the user data, given like hetzner-user-data; can be repeated",
+		},
+		mcnflag.BoolFlag{
+			Name:   "hetzner-user-data-template",
+			EnvVar: "HETZNER_USER_DATA_TEMPLATE",
+			Usage:  "Render inline user data and cloud-config fragments as Go templates with machine and cluster variables (user data files are never templated)",
 		},
 		mcnflag.StringFlag{
 			Name:   "hetzner-placement-group",
 			EnvVar: "HETZNER_PLACEMENT_GROUP",
 			Usage:  "Placement group ID or name",
 		},
+		mcnflag.BoolFlag{
+			Name:   "hetzner-auto-placement-group",
+			EnvVar: "HETZNER_AUTO_PLACEMENT_GROUP",
+			Usage:  "Find or create spread placement groups per cluster and pool, adding <cluster>-<pool>-2, -3, ... when full",
+		},
+		mcnflag.StringFlag{
+			Name:   "hetzner-ssh-user",
+			EnvVar: "HETZNER_SSH_USER",
+			Usage:  "SSH user; a non-root user is created by cloud-init with passwordless sudo and the machine's SSH keys",
+			Value:  defaultSSHUser,
+		},
+		mcnflag.IntFlag{
+			Name:   "hetzner-ssh-port",
+			EnvVar: "HETZNER_SSH_PORT",
+			Usage:  "SSH port; sshd is moved there by cloud-init and the shared firewall's SSH rule allows it",
+			Value:  defaultSSHPort,
+		},
 		mcnflag.StringFlag{
 			Name:   "hetzner-existing-ssh-key",
 			EnvVar: "HETZNER_EXISTING_SSH_KEY",
 			Usage:  "Use an existing SSH key by name or ID (added alongside the auto-generated key)",
 		},
+		mcnflag.StringFlag{
+			Name:   "hetzner-ssh-private-key",
+			EnvVar: "HETZNER_SSH_PRIVATE_KEY",
+			Usage:  "Private key (file path or inline PEM) to connect with instead of a generated key; hetzner-existing-ssh-key must hold its public key, and nothing is uploaded",
+		},
+		mcnflag.IntFlag{
+			Name:   "hetzner-ssh-wait-attempts",
+			EnvVar: "HETZNER_SSH_WAIT_ATTEMPTS",
+			Usage:  "Authenticated SSH connection attempts before the server counts as unreachable",
+			Value:  defaultSSHWaitAttempts,
+		},
+		mcnflag.IntFlag{
+			Name:   "hetzner-ssh-wait-timeout",
+			EnvVar: "HETZNER_SSH_WAIT_TIMEOUT",
+			Usage:  "Seconds per SSH connection attempt, including the handshake",
+			Value:  defaultSSHWaitTimeout,
+		},
+		mcnflag.BoolFlag{
+			Name:   "hetzner-wait-for-cloud-init",
+			EnvVar: "HETZNER_WAIT_FOR_CLOUD_INIT",
+			Usage:  "Also wait in Create until cloud-init reports done, after the SSH login succeeds; the server is removed if it fails",
+		},
+		mcnflag.IntFlag{
+			Name:   "hetzner-cloud-init-timeout",
+			EnvVar: "HETZNER_CLOUD_INIT_TIMEOUT",
+			Usage:  "Seconds to wait for cloud-init with hetzner-wait-for-cloud-init",
+			Value:  defaultCloudInitTimeout,
+		},
+		mcnflag.StringFlag{
+			Name:   "hetzner-bastion-host",
+			EnvVar: "HETZNER_BASTION_HOST",
+			Usage:  "Bastion (jump host) as host or host:port to reach the node's private IP through over SSH; requires hetzner-networks",
+		},
+		mcnflag.StringFlag{
+			Name:   "hetzner-bastion-user",
+			EnvVar: "HETZNER_BASTION_USER",
+			Usage:  "SSH user on the bastion host",
+			Value:  defaultBastionUser,
+		},
+		mcnflag.StringFlag{
+			Name:   "hetzner-bastion-key",
+			EnvVar: "HETZNER_BASTION_KEY",
+			Usage:  "Private key (file path or inline PEM) for the bastion host; required unless hetzner-ssh-private-key is also authorized on the bastion",
+		},
+		mcnflag.StringFlag{
+			Name:   "hetzner-bastion-host-key",
+			EnvVar: "HETZNER_BASTION_HOST_KEY",
+			Usage:  "Public host key of the bastion in authorized_keys format (e.g. the content of /etc/ssh/ssh_host_ed25519_key.pub); required with hetzner-bastion-host",
+		},
+		mcnflag.BoolFlag{
+			Name:   "hetzner-snapshot-on-remove",
+			EnvVar: "HETZNER_SNAPSHOT_ON_REMOVE",
+			Usage:  "Create a labelled snapshot of the server before deleting it",
+		},
+		mcnflag.IntFlag{
+			Name:   "hetzner-snapshot-retention",
+			EnvVar: "HETZNER_SNAPSHOT_RETENTION",
+			Usage:  "Number of removal snapshots to keep per cluster (0 keeps all)",
+		},
+		mcnflag.BoolFlag{
+			Name:   "hetzner-snapshot-required",
+			EnvVar: "HETZNER_SNAPSHOT_REQUIRED",
+			Usage:  "Abort removal if the snapshot fails (default: delete anyway)",
+		},
 	}
 }
 
@@ -110,8 +342,32 @@ func (d *Driver) SetConfigFromFlags(opts drivers.DriverOptions) error {
 	}
 
 	d.ServerType = opts.String("hetzner-server-type")
+	d.MinCores = opts.Int("hetzner-min-cores")
+	d.MinMemory = opts.Int("hetzner-min-memory")
+	d.MinDisk = opts.Int("hetzner-min-disk")
+	d.CPUType = opts.String("hetzner-cpu-type")
+	d.Architectures = opts.StringSlice("hetzner-architectures")
+	if d.MinCores < 0 || d.MinMemory < 0 || d.MinDisk < 0 {
+		return fmt.Errorf("hetzner-min-cores, hetzner-min-memory and hetzner-min-disk must not be negative")
+	}
+	if err := validateServerTypeRequirementFlags(d.CPUType, d.Architectures); err != nil {
+		return err
+	}
+	d.ReplaceDeprecatedServerType = opts.Bool("hetzner-replace-deprecated-server-type")
+	d.ServerTypeSuccessor = opts.String("hetzner-server-type-successor")
 	d.ServerLocation = opts.String("hetzner-server-location")
+	serverLocations, err := parseServerLocations(opts.StringSlice("hetzner-server-locations"))
+	if err != nil {
+		return err
+	}
+	d.ServerLocations = serverLocations
 	d.Image = opts.String("hetzner-image")
+	d.ImageSelector = opts.String("hetzner-image-selector")
+	imageMap, err := parseImageMap(opts.StringSlice("hetzner-image-map"))
+	if err != nil {
+		return err
+	}
+	d.ImageMap = imageMap
 	d.UsePrivateNetwork = opts.Bool("hetzner-use-private-network")
 	d.Networks = opts.StringSlice("hetzner-networks")
 	d.Firewalls = opts.StringSlice("hetzner-firewalls")
@@ -119,14 +375,116 @@ func (d *Driver) SetConfigFromFlags(opts drivers.DriverOptions) error {
 	d.FirewallName = opts.String("hetzner-firewall-name")
 	d.AutoCreateFirewallRules = opts.Bool("hetzner-auto-create-firewall-rules")
 	d.ClusterID = opts.String("hetzner-cluster-id")
+	d.Pool = opts.String("hetzner-pool")
+	d.CreateLoadBalancer = opts.Bool("hetzner-create-load-balancer")
+	d.LoadBalancerType = opts.String("hetzner-load-balancer-type")
+	d.LoadBalancerName = opts.String("hetzner-load-balancer-name")
+	d.IngressLoadBalancer = opts.Bool("hetzner-ingress-load-balancer")
+	d.CreateFloatingIP = opts.Bool("hetzner-create-floating-ip")
+	d.DNSAPIToken = opts.String("hetzner-dns-api-token")
+	d.DNSZone = opts.String("hetzner-dns-zone")
+	d.DNSAPIRecord = opts.Bool("hetzner-dns-api-record")
+	d.DNSUsePrivateIP = opts.Bool("hetzner-dns-use-private-ip")
+	d.DNSAPIURL = opts.String("hetzner-dns-api-url")
+	d.ReverseDNSTemplate = opts.String("hetzner-reverse-dns")
+	if d.ReverseDNSTemplate != "" {
+		if _, err := parseReverseDNSTemplate(d.ReverseDNSTemplate); err != nil {
+			return err
+		}
+	}
+	if d.DNSZone != "" && d.DNSAPIToken == "" {
+		return fmt.Errorf("hetzner-dns-api-token is required when hetzner-dns-zone is set")
+	}
+	if (d.DNSAPIRecord || d.DNSUsePrivateIP) && d.DNSZone == "" {
+		return fmt.Errorf("hetzner-dns-api-record and hetzner-dns-use-private-ip require hetzner-dns-zone")
+	}
+	if d.IngressPorts, err = parseIngressPorts(opts.StringSlice("hetzner-ingress-ports")); err != nil {
+		return err
+	}
+	if d.QuotaLimits, err = parseQuotaLimits(opts.StringSlice("hetzner-quota-limits")); err != nil {
+		return err
+	}
+	if budget := opts.String("hetzner-cluster-monthly-budget"); budget != "" {
+		d.ClusterMonthlyBudget, err = strconv.ParseFloat(budget, 64)
+		if err != nil || d.ClusterMonthlyBudget < 0 {
+			return fmt.Errorf("hetzner-cluster-monthly-budget must be a non-negative number, got %q", budget)
+		}
+	}
 	d.DisablePublicIPv4 = opts.Bool("hetzner-disable-public-ipv4")
 	d.DisablePublicIPv6 = opts.Bool("hetzner-disable-public-ipv6")
+	d.AddressFamily = opts.String("hetzner-address-family")
+	switch d.AddressFamily {
+	case "":
+		d.AddressFamily = addressFamilyAuto
+	case addressFamilyAuto, addressFamilyIPv4, addressFamilyIPv6:
+	default:
+		return fmt.Errorf("hetzner-address-family must be auto, ipv4 or ipv6, got %q", d.AddressFamily)
+	}
 	d.UserData = opts.String("hetzner-user-data")
+	d.CloudConfig = opts.StringSlice("hetzner-cloud-config")
+	d.UserDataTemplate = opts.Bool("hetzner-user-data-template")
 	d.PlacementGroup = opts.String("hetzner-placement-group")
+	d.AutoPlacementGroup = opts.Bool("hetzner-auto-placement-group")
 	d.ExistingSSHKey = opts.String("hetzner-existing-ssh-key")
+	d.SSHPrivateKey = opts.String("hetzner-ssh-private-key")
+	if d.SSHPrivateKey != "" && d.ExistingSSHKey == "" {
+		return fmt.Errorf("hetzner-ssh-private-key requires hetzner-existing-ssh-key, the Hetzner SSH key holding its public key")
+	}
+	d.SnapshotOnRemove = opts.Bool("hetzner-snapshot-on-remove")
+	d.SnapshotRetention = opts.Int("hetzner-snapshot-retention")
+	d.SnapshotRequired = opts.Bool("hetzner-snapshot-required")
+
+	if d.SnapshotRetention < 0 {
+		return fmt.Errorf("hetzner-snapshot-retention must not be negative")
+	}
 
-	d.SSHUser = defaultSSHUser
-	d.SSHPort = defaultSSHPort
+	d.SSHUser = opts.String("hetzner-ssh-user")
+	if d.SSHUser == "" {
+		d.SSHUser = defaultSSHUser
+	}
+	if err := validateSSHUser(d.SSHUser); err != nil {
+		return err
+	}
+	d.SSHPort = opts.Int("hetzner-ssh-port")
+	if d.SSHPort == 0 {
+		d.SSHPort = defaultSSHPort
+	}
+	if d.SSHPort < 1 || d.SSHPort > 65535 {
+		return fmt.Errorf("hetzner-ssh-port must be between 1 and 65535, got %d", d.SSHPort)
+	}
+
+	d.WaitForCloudInit = opts.Bool("hetzner-wait-for-cloud-init")
+	for _, setting := range []struct {
+		flag  string
+		value *int
+		def   int
+	}{
+		{"hetzner-ssh-wait-attempts", &d.SSHWaitAttempts, defaultSSHWaitAttempts},
+		{"hetzner-ssh-wait-timeout", &d.SSHWaitTimeout, defaultSSHWaitTimeout},
+		{"hetzner-cloud-init-timeout", &d.CloudInitTimeout, defaultCloudInitTimeout},
+	} {
+		*setting.value = opts.Int(setting.flag)
+		if *setting.value == 0 {
+			*setting.value = setting.def
+		}
+		if *setting.value < 0 {
+			return fmt.Errorf("%s must be positive, got %d", setting.flag, *setting.value)
+		}
+	}
+
+	d.BastionHost = opts.String("hetzner-bastion-host")
+	d.BastionUser = opts.String("hetzner-bastion-user")
+	if d.BastionUser == "" {
+		d.BastionUser = defaultBastionUser
+	}
+	d.BastionKey = opts.String("hetzner-bastion-key")
+	if d.BastionKey != "" && d.BastionHost == "" {
+		return fmt.Errorf("hetzner-bastion-key requires hetzner-bastion-host")
+	}
+	d.BastionHostKey = opts.String("hetzner-bastion-host-key")
+	if d.BastionHostKey != "" && d.BastionHost == "" {
+		return fmt.Errorf("hetzner-bastion-host-key requires hetzner-bastion-host")
+	}
 
 	return nil
 }
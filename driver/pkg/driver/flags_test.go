@@ -1,6 +1,7 @@
 package driver
 
 import (
+	"strings"
 	"testing"
 
 	"github.com/rancher/machine/libmachine/drivers"
@@ -13,8 +14,18 @@ func TestGetCreateFlags(t *testing.T) {
 	expectedFlags := []string{
 		"hetzner-api-token",
 		"hetzner-server-type",
+		"hetzner-min-cores",
+		"hetzner-min-memory",
+		"hetzner-min-disk",
+		"hetzner-cpu-type",
+		"hetzner-architectures",
+		"hetzner-replace-deprecated-server-type",
+		"hetzner-server-type-successor",
 		"hetzner-server-location",
+		"hetzner-server-locations",
 		"hetzner-image",
+		"hetzner-image-map",
+		"hetzner-image-selector",
 		"hetzner-use-private-network",
 		"hetzner-networks",
 		"hetzner-firewalls",
@@ -22,11 +33,44 @@ func TestGetCreateFlags(t *testing.T) {
 		"hetzner-firewall-name",
 		"hetzner-auto-create-firewall-rules",
 		"hetzner-cluster-id",
+		"hetzner-create-load-balancer",
+		"hetzner-load-balancer-type",
+		"hetzner-load-balancer-name",
+		"hetzner-ingress-load-balancer",
+		"hetzner-ingress-ports",
+		"hetzner-create-floating-ip",
+		"hetzner-dns-api-token",
+		"hetzner-dns-zone",
+		"hetzner-dns-api-record",
+		"hetzner-dns-use-private-ip",
+		"hetzner-dns-api-url",
+		"hetzner-reverse-dns",
+		"hetzner-pool",
+		"hetzner-quota-limits",
+		"hetzner-cluster-monthly-budget",
 		"hetzner-disable-public-ipv4",
 		"hetzner-disable-public-ipv6",
+		"hetzner-address-family",
 		"hetzner-user-data",
+		"hetzner-cloud-config",
+		"hetzner-user-data-template",
 		"hetzner-placement-group",
+		"hetzner-auto-placement-group",
+		"hetzner-ssh-user",
+		"hetzner-ssh-port",
 		"hetzner-existing-ssh-key",
+		"hetzner-ssh-private-key",
+		"hetzner-ssh-wait-attempts",
+		"hetzner-ssh-wait-timeout",
+		"hetzner-wait-for-cloud-init",
+		"hetzner-cloud-init-timeout",
+		"hetzner-bastion-host",
+		"hetzner-bastion-user",
+		"hetzner-bastion-key",
+		"hetzner-bastion-host-key",
+		"hetzner-snapshot-on-remove",
+		"hetzner-snapshot-retention",
+		"hetzner-snapshot-required",
 	}
 
 	if len(flags) != len(expectedFlags) {
@@ -53,7 +97,17 @@ func TestSetConfigFromFlags_AllFlags(t *testing.T) {
 			"hetzner-api-token":           "test-token-123",
 			"hetzner-server-type":         "cx32",
 			"hetzner-server-location":     "nbg1",
+			"hetzner-server-locations":    []string{"fsn1", "nbg1", "hel1"},
+			"hetzner-replace-deprecated-server-type": true,
+			"hetzner-min-cores":                      4,
+			"hetzner-min-memory":                     8,
+			"hetzner-min-disk":                       80,
+			"hetzner-cpu-type":                       "dedicated",
+			"hetzner-architectures":                  []string{"x86", "arm"},
+			"hetzner-server-type-successor":          "cx43",
 			"hetzner-image":               "debian-12",
+			"hetzner-image-selector":      "role=rke2-node",
+			"hetzner-image-map":           []string{"x86=debian-12", "arm=4711"},
 			"hetzner-use-private-network": true,
 			"hetzner-networks":            []string{"net1", "net2"},
 			"hetzner-firewalls":                    []string{"fw1"},
@@ -61,11 +115,44 @@ func TestSetConfigFromFlags_AllFlags(t *testing.T) {
 			"hetzner-firewall-name":                "my-firewall",
 			"hetzner-auto-create-firewall-rules":   true,
 			"hetzner-cluster-id":                   "my-cluster-123",
+			"hetzner-create-load-balancer":         true,
+			"hetzner-load-balancer-type":           "lb21",
+			"hetzner-load-balancer-name":           "my-lb",
+			"hetzner-ingress-load-balancer":        true,
+			"hetzner-ingress-ports":                []string{"80", "443", "8443"},
+			"hetzner-create-floating-ip":           true,
+			"hetzner-dns-api-token":                "dns-token",
+			"hetzner-dns-zone":                     "example.com",
+			"hetzner-dns-api-record":               true,
+			"hetzner-dns-use-private-ip":           true,
+			"hetzner-dns-api-url":                  "http://localhost:8080/api/v1",
+			"hetzner-reverse-dns":                  "{{.MachineName}}.nodes.example.com",
+			"hetzner-pool":                         "workers",
+			"hetzner-quota-limits":                 []string{"servers=50", "cores=0"},
+			"hetzner-cluster-monthly-budget":       "250.50",
 			"hetzner-disable-public-ipv4":          true,
 			"hetzner-disable-public-ipv6": false,
+			"hetzner-address-family":      "ipv6",
 			"hetzner-user-data":           "#!/bin/bash\necho hello",
+			"hetzner-cloud-config":        []string{"/etc/rancher/sysctl.yaml"},
+			"hetzner-user-data-template":  true,
 			"hetzner-placement-group":     "pg-1",
+			"hetzner-auto-placement-group": true,
+			"hetzner-ssh-user":            "deploy",
+			"hetzner-ssh-port":            2222,
 			"hetzner-existing-ssh-key":    "my-key",
+			"hetzner-ssh-private-key":     "/keys/id_ed25519",
+			"hetzner-ssh-wait-attempts":   20,
+			"hetzner-ssh-wait-timeout":    5,
+			"hetzner-wait-for-cloud-init": true,
+			"hetzner-cloud-init-timeout":  900,
+			"hetzner-bastion-host":        "bastion.example.com:2200",
+			"hetzner-bastion-user":        "jump",
+			"hetzner-bastion-key":         "/keys/bastion",
+			"hetzner-bastion-host-key":    "ssh-ed25519 AAAAbastion",
+			"hetzner-snapshot-on-remove":  true,
+			"hetzner-snapshot-retention":  3,
+			"hetzner-snapshot-required":   true,
 		},
 	}
 
@@ -82,9 +169,33 @@ func TestSetConfigFromFlags_AllFlags(t *testing.T) {
 	if d.ServerLocation != "nbg1" {
 		t.Errorf("ServerLocation = %q, want %q", d.ServerLocation, "nbg1")
 	}
+	if len(d.ServerLocations) != 3 || d.ServerLocations[2] != "hel1" {
+		t.Errorf("ServerLocations = %v, want [fsn1 nbg1 hel1]", d.ServerLocations)
+	}
+	if !d.ReplaceDeprecatedServerType {
+		t.Error("ReplaceDeprecatedServerType should be true")
+	}
+	if d.MinCores != 4 || d.MinMemory != 8 || d.MinDisk != 80 {
+		t.Errorf("MinCores/MinMemory/MinDisk = %d/%d/%d, want 4/8/80", d.MinCores, d.MinMemory, d.MinDisk)
+	}
+	if d.CPUType != "dedicated" {
+		t.Errorf("CPUType = %q, want %q", d.CPUType, "dedicated")
+	}
+	if len(d.Architectures) != 2 {
+		t.Errorf("Architectures = %v, want [x86 arm]", d.Architectures)
+	}
+	if d.ServerTypeSuccessor != "cx43" {
+		t.Errorf("ServerTypeSuccessor = %q, want %q", d.ServerTypeSuccessor, "cx43")
+	}
 	if d.Image != "debian-12" {
 		t.Errorf("Image = %q, want %q", d.Image, "debian-12")
 	}
+	if d.ImageSelector != "role=rke2-node" {
+		t.Errorf("ImageSelector = %q, want %q", d.ImageSelector, "role=rke2-node")
+	}
+	if d.ImageMap["x86"] != "debian-12" || d.ImageMap["arm"] != "4711" {
+		t.Errorf("ImageMap = %v, want map[arm:4711 x86:debian-12]", d.ImageMap)
+	}
 	if !d.UsePrivateNetwork {
 		t.Error("UsePrivateNetwork should be true")
 	}
@@ -106,26 +217,86 @@ func TestSetConfigFromFlags_AllFlags(t *testing.T) {
 	if d.ClusterID != "my-cluster-123" {
 		t.Errorf("ClusterID = %q, want %q", d.ClusterID, "my-cluster-123")
 	}
+	if d.QuotaLimits["servers"] != 50 || d.QuotaLimits["cores"] != 0 || len(d.QuotaLimits) != 2 {
+		t.Errorf("QuotaLimits = %v, want servers=50 cores=0", d.QuotaLimits)
+	}
+	if d.ClusterMonthlyBudget != 250.50 {
+		t.Errorf("ClusterMonthlyBudget = %v, want 250.50", d.ClusterMonthlyBudget)
+	}
 	if !d.DisablePublicIPv4 {
 		t.Error("DisablePublicIPv4 should be true")
 	}
 	if d.DisablePublicIPv6 {
 		t.Error("DisablePublicIPv6 should be false")
 	}
+	if d.AddressFamily != addressFamilyIPv6 {
+		t.Errorf("AddressFamily = %q, want %q", d.AddressFamily, addressFamilyIPv6)
+	}
 	if d.UserData != "#!/bin/bash\necho hello" {
 		t.Errorf("UserData = %q, want %q", d.UserData, "#!/bin/bash\necho hello")
 	}
+	if len(d.CloudConfig) != 1 || d.CloudConfig[0] != "/etc/rancher/sysctl.yaml" {
+		t.Errorf("CloudConfig = %v, want [/etc/rancher/sysctl.yaml]", d.CloudConfig)
+	}
+	if !d.UserDataTemplate {
+		t.Error("UserDataTemplate should be true")
+	}
 	if d.PlacementGroup != "pg-1" {
 		t.Errorf("PlacementGroup = %q, want %q", d.PlacementGroup, "pg-1")
 	}
+	if !d.AutoPlacementGroup {
+		t.Error("AutoPlacementGroup should be true")
+	}
+	if !d.CreateLoadBalancer || d.LoadBalancerType != "lb21" || d.LoadBalancerName != "my-lb" {
+		t.Errorf("load balancer config = %v/%q/%q, want true/lb21/my-lb", d.CreateLoadBalancer, d.LoadBalancerType, d.LoadBalancerName)
+	}
+	if !d.IngressLoadBalancer || len(d.IngressPorts) != 3 || d.IngressPorts[2] != 8443 {
+		t.Errorf("ingress config = %v/%v, want true/[80 443 8443]", d.IngressLoadBalancer, d.IngressPorts)
+	}
+	if !d.CreateFloatingIP {
+		t.Error("CreateFloatingIP should be true")
+	}
+	if d.DNSAPIToken != "dns-token" || d.DNSZone != "example.com" || !d.DNSAPIRecord || !d.DNSUsePrivateIP ||
+		d.DNSAPIURL != "http://localhost:8080/api/v1" {
+		t.Errorf("DNS config = %q/%q/%v/%v/%q", d.DNSAPIToken, d.DNSZone, d.DNSAPIRecord, d.DNSUsePrivateIP, d.DNSAPIURL)
+	}
+	if d.ReverseDNSTemplate != "{{.MachineName}}.nodes.example.com" {
+		t.Errorf("ReverseDNSTemplate = %q", d.ReverseDNSTemplate)
+	}
+	if d.Pool != "workers" {
+		t.Errorf("Pool = %q, want %q", d.Pool, "workers")
+	}
 	if d.ExistingSSHKey != "my-key" {
 		t.Errorf("ExistingSSHKey = %q, want %q", d.ExistingSSHKey, "my-key")
 	}
-	if d.SSHUser != defaultSSHUser {
-		t.Errorf("SSHUser = %q, want %q", d.SSHUser, defaultSSHUser)
+	if d.SSHPrivateKey != "/keys/id_ed25519" {
+		t.Errorf("SSHPrivateKey = %q, want %q", d.SSHPrivateKey, "/keys/id_ed25519")
 	}
-	if d.SSHPort != defaultSSHPort {
-		t.Errorf("SSHPort = %d, want %d", d.SSHPort, defaultSSHPort)
+	if d.SSHWaitAttempts != 20 || d.SSHWaitTimeout != 5 || !d.WaitForCloudInit || d.CloudInitTimeout != 900 {
+		t.Errorf("SSH wait = %d attempts, %ds, cloud-init %v %ds; want 20, 5s, true, 900s",
+			d.SSHWaitAttempts, d.SSHWaitTimeout, d.WaitForCloudInit, d.CloudInitTimeout)
+	}
+	if d.BastionHost != "bastion.example.com:2200" || d.BastionUser != "jump" || d.BastionKey != "/keys/bastion" {
+		t.Errorf("bastion = %q as %q with %q, want bastion.example.com:2200 as jump with /keys/bastion",
+			d.BastionHost, d.BastionUser, d.BastionKey)
+	}
+	if d.BastionHostKey != "ssh-ed25519 AAAAbastion" {
+		t.Errorf("BastionHostKey = %q, want ssh-ed25519 AAAAbastion", d.BastionHostKey)
+	}
+	if !d.SnapshotOnRemove {
+		t.Error("SnapshotOnRemove should be true")
+	}
+	if d.SnapshotRetention != 3 {
+		t.Errorf("SnapshotRetention = %d, want 3", d.SnapshotRetention)
+	}
+	if !d.SnapshotRequired {
+		t.Error("SnapshotRequired should be true")
+	}
+	if d.SSHUser != "deploy" {
+		t.Errorf("SSHUser = %q, want %q", d.SSHUser, "deploy")
+	}
+	if d.SSHPort != 2222 {
+		t.Errorf("SSHPort = %d, want %d", d.SSHPort, 2222)
 	}
 }
 
@@ -197,6 +368,209 @@ func TestSetConfigFromFlags_Defaults(t *testing.T) {
 	}
 }
 
+func TestSetConfigFromFlags_NegativeSnapshotRetention(t *testing.T) {
+	d := NewDriver("test", t.TempDir(), "test")
+
+	opts := &mockDriverOptions{
+		values: map[string]interface{}{
+			"hetzner-api-token":          "token",
+			"hetzner-snapshot-retention": -1,
+		},
+	}
+
+	if err := d.SetConfigFromFlags(opts); err == nil {
+		t.Fatal("expected error for negative snapshot retention")
+	}
+}
+
+func TestSetConfigFromFlags_InvalidClusterMonthlyBudget(t *testing.T) {
+	for _, budget := range []string{"lots", "-10"} {
+		d := NewDriver("test", t.TempDir(), "test")
+		opts := &mockDriverOptions{
+			values: map[string]interface{}{
+				"hetzner-api-token":              "token",
+				"hetzner-cluster-monthly-budget": budget,
+			},
+		}
+		if err := d.SetConfigFromFlags(opts); err == nil {
+			t.Errorf("expected error for budget %q", budget)
+		}
+	}
+}
+
+func TestSetConfigFromFlags_InvalidQuotaLimits(t *testing.T) {
+	for _, entries := range [][]string{
+		{"servers"},
+		{"volumes=10"},
+		{"cores=-1"},
+		{"servers=many"},
+	} {
+		d := NewDriver("test", t.TempDir(), "test")
+		opts := &mockDriverOptions{
+			values: map[string]interface{}{
+				"hetzner-api-token":    "token",
+				"hetzner-quota-limits": entries,
+			},
+		}
+		if err := d.SetConfigFromFlags(opts); err == nil {
+			t.Errorf("expected error for quota limits %v", entries)
+		}
+	}
+}
+
+func TestSetConfigFromFlags_InvalidImageMap(t *testing.T) {
+	for _, entries := range [][]string{
+		{"x86"},
+		{"riscv=ubuntu-24.04"},
+		{"x86=a", "x86=b"},
+	} {
+		d := NewDriver("test", t.TempDir(), "test")
+		opts := &mockDriverOptions{
+			values: map[string]interface{}{
+				"hetzner-api-token": "token",
+				"hetzner-image-map": entries,
+			},
+		}
+		if err := d.SetConfigFromFlags(opts); err == nil {
+			t.Errorf("expected error for image map %v", entries)
+		}
+	}
+}
+
+func TestSetConfigFromFlags_InvalidServerTypeRequirements(t *testing.T) {
+	for name, values := range map[string]map[string]interface{}{
+		"cpu type":     {"hetzner-cpu-type": "burstable"},
+		"architecture": {"hetzner-architectures": []string{"riscv"}},
+		"negative":     {"hetzner-min-cores": -2},
+	} {
+		d := NewDriver("test", t.TempDir(), "test")
+		values["hetzner-api-token"] = "token"
+		if err := d.SetConfigFromFlags(&mockDriverOptions{values: values}); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
+
+func TestSetConfigFromFlags_InvalidDNSConfig(t *testing.T) {
+	for name, values := range map[string]map[string]interface{}{
+		"zone without token":      {"hetzner-dns-zone": "example.com"},
+		"api record without zone": {"hetzner-dns-api-record": true},
+		"private ip without zone": {"hetzner-dns-use-private-ip": true},
+		"reverse dns template":    {"hetzner-reverse-dns": "{{.MachineName"},
+	} {
+		d := NewDriver("test", t.TempDir(), "test")
+		values["hetzner-api-token"] = "token"
+		if err := d.SetConfigFromFlags(&mockDriverOptions{values: values}); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
+
+func TestSetConfigFromFlags_InvalidSSHUser(t *testing.T) {
+	for _, user := range []string{"Deploy", "deploy user", "1deploy"} {
+		d := NewDriver("test", t.TempDir(), "test")
+		opts := &mockDriverOptions{
+			values: map[string]interface{}{
+				"hetzner-api-token": "token",
+				"hetzner-ssh-user":  user,
+			},
+		}
+		if err := d.SetConfigFromFlags(opts); err == nil {
+			t.Errorf("expected error for SSH user %q", user)
+		}
+	}
+}
+
+func TestSetConfigFromFlags_InvalidSSHPort(t *testing.T) {
+	for _, port := range []int{-1, 65536} {
+		d := NewDriver("test", t.TempDir(), "test")
+		opts := &mockDriverOptions{
+			values: map[string]interface{}{
+				"hetzner-api-token": "token",
+				"hetzner-ssh-port":  port,
+			},
+		}
+		if err := d.SetConfigFromFlags(opts); err == nil {
+			t.Errorf("expected error for SSH port %d", port)
+		}
+	}
+}
+
+func TestSetConfigFromFlags_SSHPrivateKeyRequiresExistingKey(t *testing.T) {
+	d := NewDriver("test", t.TempDir(), "test")
+	opts := &mockDriverOptions{
+		values: map[string]interface{}{
+			"hetzner-api-token":       "token",
+			"hetzner-ssh-private-key": "/keys/id_ed25519",
+		},
+	}
+	err := d.SetConfigFromFlags(opts)
+	if err == nil || !strings.Contains(err.Error(), "hetzner-existing-ssh-key") {
+		t.Fatalf("SetConfigFromFlags() error = %v, want hetzner-existing-ssh-key required", err)
+	}
+}
+
+func TestSetConfigFromFlags_SSHWaitDefaults(t *testing.T) {
+	d := NewDriver("test", t.TempDir(), "test")
+	opts := &mockDriverOptions{values: map[string]interface{}{"hetzner-api-token": "token"}}
+	if err := d.SetConfigFromFlags(opts); err != nil {
+		t.Fatalf("SetConfigFromFlags() error: %v", err)
+	}
+	if d.SSHWaitAttempts != defaultSSHWaitAttempts || d.SSHWaitTimeout != defaultSSHWaitTimeout || d.CloudInitTimeout != defaultCloudInitTimeout {
+		t.Errorf("SSH wait = %d attempts, %ds, cloud-init %ds; want defaults", d.SSHWaitAttempts, d.SSHWaitTimeout, d.CloudInitTimeout)
+	}
+
+	opts.values["hetzner-cloud-init-timeout"] = -1
+	if err := d.SetConfigFromFlags(opts); err == nil {
+		t.Error("expected error for a negative cloud-init timeout")
+	}
+}
+
+func TestSetConfigFromFlags_BastionKeyRequiresHost(t *testing.T) {
+	d := NewDriver("test", t.TempDir(), "test")
+	opts := &mockDriverOptions{
+		values: map[string]interface{}{
+			"hetzner-api-token":   "token",
+			"hetzner-bastion-key": "/keys/bastion",
+		},
+	}
+	err := d.SetConfigFromFlags(opts)
+	if err == nil || !strings.Contains(err.Error(), "hetzner-bastion-host") {
+		t.Fatalf("SetConfigFromFlags() error = %v, want hetzner-bastion-host required", err)
+	}
+}
+
+func TestSetConfigFromFlags_BastionHostKeyRequiresHost(t *testing.T) {
+	d := NewDriver("test", t.TempDir(), "test")
+	opts := &mockDriverOptions{
+		values: map[string]interface{}{
+			"hetzner-api-token":        "token",
+			"hetzner-bastion-host-key": "ssh-ed25519 AAAAbastion",
+		},
+	}
+	err := d.SetConfigFromFlags(opts)
+	if err == nil || !strings.Contains(err.Error(), "hetzner-bastion-host") {
+		t.Fatalf("SetConfigFromFlags() error = %v, want hetzner-bastion-host required", err)
+	}
+}
+
+func TestSetConfigFromFlags_AddressFamily(t *testing.T) {
+	d := NewDriver("test", t.TempDir(), "test")
+	opts := &mockDriverOptions{values: map[string]interface{}{"hetzner-api-token": "token"}}
+	if err := d.SetConfigFromFlags(opts); err != nil {
+		t.Fatalf("SetConfigFromFlags() error: %v", err)
+	}
+	if d.AddressFamily != addressFamilyAuto {
+		t.Errorf("AddressFamily = %q, want %q", d.AddressFamily, addressFamilyAuto)
+	}
+
+	opts.values["hetzner-address-family"] = "inet6"
+	err := d.SetConfigFromFlags(opts)
+	if err == nil || !strings.Contains(err.Error(), "hetzner-address-family") {
+		t.Fatalf("SetConfigFromFlags() error = %v, want invalid hetzner-address-family", err)
+	}
+}
+
 func TestNewDriver_Defaults(t *testing.T) {
 	d := NewDriver("my-machine", "/tmp/store", "1.0.0")
 
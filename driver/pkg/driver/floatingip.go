@@ -0,0 +1,246 @@
+package driver
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/hetznercloud/hcloud-go/v2/hcloud"
+	"github.com/rancher/machine/libmachine/log"
+)
+
+// floatingIPLabel marks the servers that may hold the cluster's floating IP.
+const floatingIPLabel = "floating-ip"
+
+// floatingIPSelector selects the cluster's floating IP.
+func (d *Driver) floatingIPSelector() string {
+	return "managed-by=rancher-machine,cluster=" + d.ClusterID
+}
+
+// floatingIPServerSelector selects the servers that may hold the floating IP.
+func (d *Driver) floatingIPServerSelector() string {
+	return fmt.Sprintf("cluster=%s,%s=true", d.ClusterID, floatingIPLabel)
+}
+
+// findClusterFloatingIP looks up the cluster's floating IP by label.
+func (d *Driver) findClusterFloatingIP(ctx context.Context) (*hcloud.FloatingIP, error) {
+	selector := d.floatingIPSelector()
+	fips, err := d.getClient().FloatingIP.AllWithOpts(ctx, hcloud.FloatingIPListOpts{
+		ListOpts: hcloud.ListOpts{LabelSelector: selector},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list floating IPs: %w", err)
+	}
+	if len(fips) == 0 {
+		return nil, nil
+	}
+	if len(fips) > 1 {
+		return nil, fmt.Errorf("multiple floating IPs found for selector %q (count=%d); please delete or consolidate duplicates", selector, len(fips))
+	}
+	return fips[0], nil
+}
+
+// ensureClusterFloatingIP finds or creates the cluster's floating IP and
+// records its address, which the server's cloud-init configures on its
+// public interface. It runs before the server is created, so Create() deletes
+// a floating IP no server uses when it fails later on.
+func (d *Driver) ensureClusterFloatingIP(ctx context.Context) error {
+	fip, err := d.findClusterFloatingIP(ctx)
+	if err != nil {
+		return err
+	}
+	if fip == nil {
+		name := "rancher-" + d.ClusterID + "-vip"
+		log.Infof("Creating floating IP %q (home location=%s)...", name, d.ServerLocation)
+		result, _, err := d.getClient().FloatingIP.Create(ctx, hcloud.FloatingIPCreateOpts{
+			Type:         hcloud.FloatingIPTypeIPv4,
+			HomeLocation: &hcloud.Location{Name: d.ServerLocation},
+			Name:         hcloud.Ptr(name),
+			Description:  hcloud.Ptr("Control-plane virtual IP of cluster " + d.ClusterID),
+			Labels: map[string]string{
+				"managed-by": "rancher-machine",
+				"cluster":    d.ClusterID,
+			},
+		})
+		if err != nil {
+			// Another node may have created the floating IP concurrently.
+			log.Infof("Floating IP create failed (%v), checking if created concurrently...", err)
+			fip, findErr := d.findClusterFloatingIP(ctx)
+			if findErr != nil || fip == nil {
+				return fmt.Errorf("failed to create floating IP %q: %w", name, err)
+			}
+			log.Infof("Floating IP %q was created concurrently (ID=%d), using it", fip.Name, fip.ID)
+			d.FloatingIPID, d.FloatingIP = fip.ID, fip.IP.String()
+			return nil
+		}
+		d.FloatingIPID = result.FloatingIP.ID
+		if result.Action != nil {
+			if err := d.waitForAction(ctx, result.Action); err != nil {
+				return fmt.Errorf("floating IP %q creation action failed: %w", name, err)
+			}
+		}
+		fip = result.FloatingIP
+		log.Infof("Floating IP %q created (ID=%d, IP=%s)", fip.Name, fip.ID, fip.IP)
+	} else {
+		log.Infof("Found existing floating IP %q (ID=%d, IP=%s)", fip.Name, fip.ID, fip.IP)
+	}
+	d.FloatingIPID, d.FloatingIP = fip.ID, fip.IP.String()
+	return nil
+}
+
+// floatingIPCloudConfig returns a cloud-config snippet that configures the
+// floating IP on the interface of the default route. Images with netplan
+// (Ubuntu) get a netplan file; others get a systemd unit that adds the
+// address on boot. Every labelled node gets it, so the IP keeps working when
+// it is reassigned.
+func floatingIPCloudConfig(ip string) string {
+	return fmt.Sprintf(`#cloud-config
+write_files:
+  - path: /usr/local/sbin/floating-ip-setup
+    permissions: "0755"
+    content: |
+      #!/bin/sh
+      set -e
+      ip=%s
+      iface=$(ip -4 route show default | awk '{for (i = 1; i < NF; i++) if ($i == "dev") {print $(i+1); exit}}')
+      iface=${iface:-eth0}
+      if command -v netplan >/dev/null 2>&1; then
+        printf 'network:\n  version: 2\n  ethernets:\n    %%s:\n      addresses:\n        - %%s/32\n' "$iface" "$ip" >/etc/netplan/60-floating-ip.yaml
+        chmod 600 /etc/netplan/60-floating-ip.yaml
+        netplan apply
+      else
+        printf '[Unit]\nDescription=Cluster floating IP\nAfter=network-online.target\nWants=network-online.target\n\n[Service]\nType=oneshot\nRemainAfterExit=yes\nExecStart=%%s addr replace %%s/32 dev %%s\n\n[Install]\nWantedBy=multi-user.target\n' "$(command -v ip)" "$ip" "$iface" >/etc/systemd/system/floating-ip.service
+        systemctl daemon-reload
+        systemctl enable --now floating-ip.service
+      fi
+runcmd:
+  - /usr/local/sbin/floating-ip-setup
+`, ip)
+}
+
+// assignFloatingIP assigns the cluster's floating IP to this server unless
+// it is already held by another existing server, so the IP stays on the
+// first control-plane node.
+func (d *Driver) assignFloatingIP(ctx context.Context) error {
+	fip, _, err := d.getClient().FloatingIP.GetByID(ctx, d.FloatingIPID)
+	if err != nil {
+		return fmt.Errorf("failed to get floating IP %d: %w", d.FloatingIPID, err)
+	}
+	if fip == nil {
+		return fmt.Errorf("floating IP %d not found", d.FloatingIPID)
+	}
+	if fip.Server != nil {
+		if fip.Server.ID == d.ServerID {
+			return nil
+		}
+		holder, _, err := d.getClient().Server.GetByID(ctx, fip.Server.ID)
+		if err != nil {
+			return fmt.Errorf("failed to get server %d holding floating IP %s: %w", fip.Server.ID, fip.IP, err)
+		}
+		if holder != nil {
+			log.Infof("Floating IP %s stays assigned to server %q", fip.IP, holder.Name)
+			return nil
+		}
+	}
+
+	log.Infof("Assigning floating IP %s to server %d...", fip.IP, d.ServerID)
+	action, _, err := d.getClient().FloatingIP.Assign(ctx, fip, &hcloud.Server{ID: d.ServerID})
+	if err != nil {
+		return fmt.Errorf("failed to assign floating IP %s to server %d: %w", fip.IP, d.ServerID, err)
+	}
+	return d.waitForAction(ctx, action)
+}
+
+// otherFloatingIPServers lists the labelled servers other than this one,
+// running servers and older servers first.
+func (d *Driver) otherFloatingIPServers(ctx context.Context) ([]*hcloud.Server, error) {
+	servers, err := d.getClient().Server.AllWithOpts(ctx, hcloud.ServerListOpts{
+		ListOpts: hcloud.ListOpts{LabelSelector: d.floatingIPServerSelector()},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list floating IP servers: %w", err)
+	}
+	others := make([]*hcloud.Server, 0, len(servers))
+	for _, s := range servers {
+		if s.ID != d.ServerID {
+			others = append(others, s)
+		}
+	}
+	sort.SliceStable(others, func(i, j int) bool {
+		ri, rj := others[i].Status == hcloud.ServerStatusRunning, others[j].Status == hcloud.ServerStatusRunning
+		if ri != rj {
+			return ri
+		}
+		return others[i].ID < others[j].ID
+	})
+	return others, nil
+}
+
+// reassignFloatingIP moves the floating IP from this server to another
+// labelled server before this one is deleted. Without a surviving server the
+// IP is left alone; deleteFloatingIPIfUnused removes it afterwards.
+func (d *Driver) reassignFloatingIP(ctx context.Context) {
+	if d.FloatingIPID == 0 || d.ServerID == 0 {
+		return
+	}
+	fip, _, err := d.getClient().FloatingIP.GetByID(ctx, d.FloatingIPID)
+	if err != nil {
+		log.Warnf("Failed to get floating IP %d for reassignment: %v", d.FloatingIPID, err)
+		return
+	}
+	if fip == nil || (fip.Server != nil && fip.Server.ID != d.ServerID) {
+		return
+	}
+
+	others, err := d.otherFloatingIPServers(ctx)
+	if err != nil {
+		log.Warnf("Warning: cannot reassign floating IP %s: %v", fip.IP, err)
+		return
+	}
+	if len(others) == 0 {
+		return
+	}
+	target := others[0]
+	log.Infof("Reassigning floating IP %s to server %q...", fip.IP, target.Name)
+	action, _, err := d.getClient().FloatingIP.Assign(ctx, fip, target)
+	if err != nil {
+		log.Warnf("Failed to reassign floating IP %s to server %q: %v", fip.IP, target.Name, err)
+		return
+	}
+	if err := d.waitForAction(ctx, action); err != nil {
+		log.Warnf("Floating IP reassignment action failed: %v", err)
+		return
+	}
+	log.Infof("Floating IP %s reassigned to server %q", fip.IP, target.Name)
+}
+
+// deleteFloatingIPIfUnused deletes the floating IP once no other labelled
+// server of the cluster is left to hold it.
+func (d *Driver) deleteFloatingIPIfUnused(ctx context.Context) {
+	if d.FloatingIPID == 0 {
+		return
+	}
+	others, err := d.otherFloatingIPServers(ctx)
+	if err != nil {
+		log.Warnf("Warning: cannot check floating IP %d for cleanup: %v", d.FloatingIPID, err)
+		return
+	}
+	if len(others) > 0 {
+		log.Infof("%d servers may still hold floating IP %d, keeping it", len(others), d.FloatingIPID)
+		return
+	}
+
+	fip, _, err := d.getClient().FloatingIP.GetByID(ctx, d.FloatingIPID)
+	if err != nil {
+		log.Warnf("Failed to get floating IP %d for cleanup: %v", d.FloatingIPID, err)
+		return
+	}
+	if fip == nil {
+		return
+	}
+	if _, err := d.getClient().FloatingIP.Delete(ctx, fip); err != nil {
+		log.Warnf("Failed to delete unused floating IP %d: %v", d.FloatingIPID, err)
+		return
+	}
+	log.Infof("Deleted unused floating IP %q (%s)", fip.Name, fip.IP)
+}
@@ -0,0 +1,246 @@
+package driver
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/hetznercloud/hcloud-go/v2/hcloud"
+	"github.com/hetznercloud/hcloud-go/v2/hcloud/schema"
+)
+
+// fipMux serves a single floating IP (nil until created), the labelled
+// servers of the cluster, and records assignments.
+type fipMux struct {
+	*http.ServeMux
+	fip      *schema.FloatingIP
+	created  *schema.FloatingIPCreateRequest
+	assigned []int64
+	deleted  bool
+}
+
+func newFIPMux(t *testing.T, existing *schema.FloatingIP, servers ...schema.Server) *fipMux {
+	t.Helper()
+	m := &fipMux{ServeMux: http.NewServeMux(), fip: existing}
+	registerActionPoller(m.ServeMux, 1)
+	m.HandleFunc("/floating_ips", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			var req schema.FloatingIPCreateRequest
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				t.Fatalf("decode create request: %v", err)
+			}
+			m.created = &req
+			m.fip = &schema.FloatingIP{ID: 50, Name: *req.Name, IP: "203.0.113.10", Type: req.Type}
+			jsonResponse(w, http.StatusCreated, schema.FloatingIPCreateResponse{FloatingIP: *m.fip, Action: ptr(completedAction(1))})
+			return
+		}
+		if got := r.URL.Query().Get("label_selector"); got != "managed-by=rancher-machine,cluster=prod" {
+			t.Errorf("label_selector = %q", got)
+		}
+		var fips []schema.FloatingIP
+		if m.fip != nil {
+			fips = append(fips, *m.fip)
+		}
+		jsonResponse(w, http.StatusOK, schema.FloatingIPListResponse{FloatingIPs: fips})
+	})
+	m.HandleFunc("/floating_ips/50", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodDelete {
+			m.deleted = true
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		jsonResponse(w, http.StatusOK, schema.FloatingIPGetResponse{FloatingIP: *m.fip})
+	})
+	m.HandleFunc("/floating_ips/50/actions/assign", func(w http.ResponseWriter, r *http.Request) {
+		var req schema.FloatingIPActionAssignRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Fatalf("decode assign request: %v", err)
+		}
+		m.assigned = append(m.assigned, req.Server)
+		jsonResponse(w, http.StatusCreated, schema.FloatingIPActionAssignResponse{Action: completedAction(1)})
+	})
+	m.HandleFunc("/servers", func(w http.ResponseWriter, r *http.Request) {
+		if got := r.URL.Query().Get("label_selector"); got != "cluster=prod,floating-ip=true" {
+			t.Errorf("label_selector = %q, want cluster=prod,floating-ip=true", got)
+		}
+		jsonResponse(w, http.StatusOK, schema.ServerListResponse{Servers: servers})
+	})
+	registerServers(m.ServeMux, nil, servers...)
+	return m
+}
+
+func fipDriver(t *testing.T, m *fipMux) *Driver {
+	d := clusterNodeDriver(t, m.ServeMux)
+	d.CreateFloatingIP = true
+	d.FloatingIPID = 50
+	return d
+}
+
+func TestEnsureClusterFloatingIP_Creates(t *testing.T) {
+	m := newFIPMux(t, nil)
+	d := fipDriver(t, m)
+	d.FloatingIPID = 0
+
+	if err := d.ensureClusterFloatingIP(testCtx(t)); err != nil {
+		t.Fatalf("ensureClusterFloatingIP() error: %v", err)
+	}
+	if m.created == nil || *m.created.Name != "rancher-prod-vip" || m.created.Type != "ipv4" {
+		t.Fatalf("created = %+v, want ipv4 rancher-prod-vip", m.created)
+	}
+	if m.created.HomeLocation == nil || *m.created.HomeLocation != "fsn1" {
+		t.Errorf("home location = %v, want fsn1", m.created.HomeLocation)
+	}
+	if (*m.created.Labels)["cluster"] != "prod" {
+		t.Errorf("labels = %v, want cluster=prod", *m.created.Labels)
+	}
+	if d.FloatingIPID != 50 || d.FloatingIP != "203.0.113.10" {
+		t.Errorf("FloatingIPID = %d, FloatingIP = %q, want 50 and 203.0.113.10", d.FloatingIPID, d.FloatingIP)
+	}
+	if d.resourceLabels()["floating-ip"] != "true" {
+		t.Errorf("resourceLabels() = %v, want floating-ip=true", d.resourceLabels())
+	}
+}
+
+func TestAssignFloatingIP(t *testing.T) {
+	tests := []struct {
+		name   string
+		holder *int64
+		want   int
+	}{
+		{"unassigned", nil, 1},
+		{"held by existing server", ptr(int64(7)), 0},
+		{"held by deleted server", ptr(int64(8)), 1},
+		{"held by this server", ptr(int64(100)), 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newFIPMux(t, &schema.FloatingIP{ID: 50, IP: "203.0.113.10", Server: tt.holder}, standardServer(7, "running"))
+			d := fipDriver(t, m)
+
+			if err := d.assignFloatingIP(testCtx(t)); err != nil {
+				t.Fatalf("assignFloatingIP() error: %v", err)
+			}
+			if len(m.assigned) != tt.want {
+				t.Fatalf("assigned = %v, want %d assignments", m.assigned, tt.want)
+			}
+			if tt.want == 1 && m.assigned[0] != 100 {
+				t.Errorf("assigned to %d, want 100", m.assigned[0])
+			}
+		})
+	}
+}
+
+func TestRemove_ReassignsFloatingIP(t *testing.T) {
+	m := newFIPMux(t, &schema.FloatingIP{ID: 50, IP: "203.0.113.10", Server: ptr(int64(100))},
+		standardServer(100, "running"), standardServer(103, "running"), standardServer(101, "off"), standardServer(102, "running"))
+	d := fipDriver(t, m)
+
+	d.reassignFloatingIP(testCtx(t))
+	if len(m.assigned) != 1 || m.assigned[0] != 102 {
+		t.Errorf("assigned = %v, want [102] (oldest running server)", m.assigned)
+	}
+	d.deleteFloatingIPIfUnused(testCtx(t))
+	if m.deleted {
+		t.Error("floating IP should be kept while other servers remain")
+	}
+}
+
+func TestRemove_DeletesFloatingIPWithLastServer(t *testing.T) {
+	m := newFIPMux(t, &schema.FloatingIP{ID: 50, IP: "203.0.113.10", Server: ptr(int64(100))})
+	d := fipDriver(t, m)
+
+	if err := d.Remove(); err != nil {
+		t.Fatalf("Remove() error: %v", err)
+	}
+	if len(m.assigned) != 0 {
+		t.Errorf("assigned = %v, want no reassignment", m.assigned)
+	}
+	if !m.deleted {
+		t.Error("floating IP should be deleted with the last server")
+	}
+}
+
+func TestBuildServerCreateOpts_FloatingIPCloudConfig(t *testing.T) {
+	d, _ := newTestDriver(t, deprecationMux(standardServerType()))
+	d.FloatingIP = "203.0.113.10"
+
+	opts, err := d.buildServerCreateOpts(testCtx(t), &hcloud.SSHKey{ID: 1}, nil)
+	if err != nil {
+		t.Fatalf("buildServerCreateOpts() error: %v", err)
+	}
+	if !strings.HasPrefix(opts.UserData, "#cloud-config") || !strings.Contains(opts.UserData, "ip=203.0.113.10") {
+		t.Errorf("UserData = %q, want floating IP cloud-config", opts.UserData)
+	}
+
+	d.UserData = "#!/bin/bash\necho hello"
+	opts, err = d.buildServerCreateOpts(testCtx(t), &hcloud.SSHKey{ID: 1}, nil)
+	if err != nil {
+		t.Fatalf("buildServerCreateOpts() error: %v", err)
+	}
+	if !strings.HasPrefix(opts.UserData, "Content-Type: multipart/mixed") ||
+		!strings.Contains(opts.UserData, "echo hello") || !strings.Contains(opts.UserData, "ip=203.0.113.10") {
+		t.Errorf("UserData = %q, want multipart with user script and floating IP config", opts.UserData)
+	}
+}
+
+func TestFloatingIPCloudConfig_WithoutNetplan(t *testing.T) {
+	config := floatingIPCloudConfig("203.0.113.10")
+	for _, want := range []string{
+		"ip=203.0.113.10",
+		"ip -4 route show default",
+		"if command -v netplan",
+		"/etc/netplan/60-floating-ip.yaml",
+		"/etc/systemd/system/floating-ip.service",
+		"systemctl enable --now floating-ip.service",
+		"runcmd:\n  - /usr/local/sbin/floating-ip-setup\n",
+	} {
+		if !strings.Contains(config, want) {
+			t.Errorf("floatingIPCloudConfig() missing %q", want)
+		}
+	}
+	if strings.Contains(config, "eth0:") {
+		t.Error("floatingIPCloudConfig() should not hard-code the eth0 interface")
+	}
+}
+
+func TestCreate_FailureBeforeServer_DeletesUnusedFloatingIP(t *testing.T) {
+	m := newFIPMux(t, nil)
+	sshKeyDeleted := false
+	d := createDriver(t, createMux(m.ServeMux, &sshKeyDeleted))
+	d.CreateFloatingIP = true
+	d.Networks = []string{"missing"}
+
+	err := d.Create()
+	if err == nil || !strings.Contains(err.Error(), "failed to build server options") {
+		t.Fatalf("Create() error = %v, want server options error", err)
+	}
+	if m.created == nil || !m.deleted {
+		t.Errorf("created = %v, deleted = %v, want the new floating IP deleted", m.created != nil, m.deleted)
+	}
+	if !sshKeyDeleted {
+		t.Error("SSH key should be deleted")
+	}
+}
+
+func TestCreate_AssignFailure_DeletesUnusedFloatingIP(t *testing.T) {
+	m := newFIPMux(t, nil, standardServer(100, "running"))
+	mux := http.NewServeMux()
+	mux.HandleFunc("/floating_ips/50/actions/assign", func(w http.ResponseWriter, r *http.Request) {
+		jsonResponse(w, http.StatusForbidden, schema.ErrorResponse{
+			Error: schema.Error{Code: "forbidden", Message: "insufficient permissions"},
+		})
+	})
+	mux.Handle("/", m.ServeMux)
+	sshKeyDeleted := false
+	d := createDriver(t, createMux(mux, &sshKeyDeleted))
+	d.CreateFloatingIP = true
+
+	err := d.Create()
+	if err == nil || !strings.Contains(err.Error(), "failed to assign floating IP") {
+		t.Fatalf("Create() error = %v, want assign error", err)
+	}
+	if !m.deleted {
+		t.Error("the unused floating IP should be deleted")
+	}
+}
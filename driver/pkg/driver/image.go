@@ -0,0 +1,134 @@
+package driver
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/hetznercloud/hcloud-go/v2/hcloud"
+	"github.com/rancher/machine/libmachine/log"
+)
+
+// resolveImage returns the image to boot for the given architecture. When
+// ImageSelector is set it takes precedence and resolves to the newest
+// matching snapshot. Otherwise the per-architecture ImageMap entry is used,
+// falling back to Image. Plain references may be an image name or ID.
+func (d *Driver) resolveImage(ctx context.Context, arch hcloud.Architecture) (*hcloud.Image, error) {
+	if d.ImageSelector != "" {
+		return d.resolveImageBySelector(ctx, d.ImageSelector, arch)
+	}
+
+	ref := d.imageRefForArchitecture(arch)
+	image, _, err := d.getClient().Image.GetForArchitecture(ctx, ref, arch)
+	if err != nil {
+		return nil, fmt.Errorf("invalid image %q for architecture %s: %w", ref, arch, err)
+	}
+	if image == nil {
+		return nil, fmt.Errorf("image %q not found for architecture %s", ref, arch)
+	}
+	// Lookups by ID ignore the architecture, so a snapshot ID from the wrong
+	// pool would only fail once the server is created.
+	if image.Architecture != "" && image.Architecture != arch {
+		return nil, fmt.Errorf("image %q has architecture %s, but the server type requires %s",
+			ref, image.Architecture, arch)
+	}
+	return image, nil
+}
+
+// imageRefForArchitecture returns the image name or ID configured for the
+// given architecture.
+func (d *Driver) imageRefForArchitecture(arch hcloud.Architecture) string {
+	if ref, ok := d.ImageMap[string(arch)]; ok && ref != "" {
+		return ref
+	}
+	return d.Image
+}
+
+// checkImageMapping warns when ImageMap is configured but has no entry for
+// the selected architecture, so Image is used instead.
+func (d *Driver) checkImageMapping(arch hcloud.Architecture) {
+	if len(d.ImageMap) == 0 || d.ImageSelector != "" {
+		return
+	}
+	if _, ok := d.ImageMap[string(arch)]; !ok {
+		log.Warnf("Warning: --hetzner-image-map has no entry for architecture %s; falling back to image %q",
+			arch, d.Image)
+	}
+}
+
+// parseImageMap parses "<arch>=<image>" entries into a map keyed by
+// architecture. Only architectures known to Hetzner Cloud are accepted.
+func parseImageMap(entries []string) (map[string]string, error) {
+	if len(entries) == 0 {
+		return nil, nil
+	}
+	m := make(map[string]string, len(entries))
+	for _, entry := range entries {
+		arch, ref, ok := strings.Cut(entry, "=")
+		arch = strings.TrimSpace(arch)
+		ref = strings.TrimSpace(ref)
+		if !ok || ref == "" {
+			return nil, fmt.Errorf("invalid image map entry %q: expected <arch>=<image>", entry)
+		}
+		switch hcloud.Architecture(arch) {
+		case hcloud.ArchitectureX86, hcloud.ArchitectureARM:
+		default:
+			return nil, fmt.Errorf("invalid image map entry %q: architecture must be %q or %q",
+				entry, hcloud.ArchitectureX86, hcloud.ArchitectureARM)
+		}
+		if _, dup := m[arch]; dup {
+			return nil, fmt.Errorf("duplicate image map entry for architecture %s", arch)
+		}
+		m[arch] = ref
+	}
+	return m, nil
+}
+
+// resolveImageBySelector returns the most recently created available snapshot
+// matching the label selector for the given architecture. This lets pools
+// follow a regularly rebuilt golden image (e.g. from Packer) without updating
+// the image ID on every build.
+func (d *Driver) resolveImageBySelector(ctx context.Context, selector string, arch hcloud.Architecture) (*hcloud.Image, error) {
+	images, err := d.getClient().Image.AllWithOpts(ctx, hcloud.ImageListOpts{
+		ListOpts:     hcloud.ListOpts{LabelSelector: selector},
+		Type:         []hcloud.ImageType{hcloud.ImageTypeSnapshot},
+		Status:       []hcloud.ImageStatus{hcloud.ImageStatusAvailable},
+		Architecture: []hcloud.Architecture{arch},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list images for selector %q: %w", selector, err)
+	}
+
+	// The API filters by architecture already; re-check in case an older API
+	// version ignores the filter, since booting the wrong architecture fails late.
+	// Removal snapshots are backups of a node, never golden images.
+	var candidates []*hcloud.Image
+	for _, image := range images {
+		if image.Architecture == arch && image.Labels[snapshotBackupLabel] != "true" {
+			candidates = append(candidates, image)
+		}
+	}
+	if len(candidates) == 0 {
+		return nil, fmt.Errorf("no available snapshot matches image selector %q for architecture %s", selector, arch)
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].Created.After(candidates[j].Created)
+	})
+	image := candidates[0]
+	log.Infof("Image selector %q resolved to snapshot %d (%q, created %s) for architecture %s",
+		selector, image.ID, image.Description, image.Created.Format("2006-01-02 15:04"), arch)
+	return image, nil
+}
+
+// imageDescription returns a human-readable reference to the configured image.
+func (d *Driver) imageDescription() string {
+	if d.ImageSelector != "" {
+		return fmt.Sprintf("selector %q", d.ImageSelector)
+	}
+	if len(d.ImageMap) > 0 {
+		return fmt.Sprintf("%s (map %v)", d.Image, d.ImageMap)
+	}
+	return d.Image
+}
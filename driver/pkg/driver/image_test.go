@@ -0,0 +1,249 @@
+package driver
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hetznercloud/hcloud-go/v2/hcloud"
+	"github.com/hetznercloud/hcloud-go/v2/hcloud/schema"
+)
+
+// goldenSnapshot returns a snapshot image with the given architecture and creation time.
+func goldenSnapshot(id int64, arch string, created time.Time) schema.Image {
+	return schema.Image{
+		ID:           id,
+		Type:         "snapshot",
+		Status:       "available",
+		Description:  "rke2-node",
+		Architecture: arch,
+		Created:      &created,
+		Labels:       map[string]string{"role": "rke2-node"},
+	}
+}
+
+func TestResolveImageBySelector_PicksNewest(t *testing.T) {
+	now := time.Now()
+	var query string
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/images", func(w http.ResponseWriter, r *http.Request) {
+		query = r.URL.RawQuery
+		jsonResponse(w, http.StatusOK, schema.ImageListResponse{
+			Images: []schema.Image{
+				goldenSnapshot(10, "x86", now.Add(-14*24*time.Hour)),
+				goldenSnapshot(12, "x86", now.Add(-1*time.Hour)),
+				goldenSnapshot(11, "x86", now.Add(-7*24*time.Hour)),
+			},
+		})
+	})
+
+	d, _ := newTestDriver(t, mux)
+	d.ImageSelector = "role=rke2-node"
+
+	image, err := d.resolveImage(testCtx(t), hcloud.ArchitectureX86)
+	if err != nil {
+		t.Fatalf("resolveImage() error: %v", err)
+	}
+	if image.ID != 12 {
+		t.Errorf("image ID = %d, want 12 (newest)", image.ID)
+	}
+	for _, want := range []string{"label_selector=role%3Drke2-node", "type=snapshot", "architecture=x86"} {
+		if !strings.Contains(query, want) {
+			t.Errorf("query = %q, want it to contain %q", query, want)
+		}
+	}
+}
+
+func TestResolveImageBySelector_IgnoresOtherArchitecture(t *testing.T) {
+	now := time.Now()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/images", func(w http.ResponseWriter, r *http.Request) {
+		jsonResponse(w, http.StatusOK, schema.ImageListResponse{
+			Images: []schema.Image{
+				goldenSnapshot(20, "arm", now),
+				goldenSnapshot(21, "x86", now.Add(-time.Hour)),
+			},
+		})
+	})
+
+	d, _ := newTestDriver(t, mux)
+	d.ImageSelector = "role=rke2-node"
+
+	image, err := d.resolveImage(testCtx(t), hcloud.ArchitectureX86)
+	if err != nil {
+		t.Fatalf("resolveImage() error: %v", err)
+	}
+	if image.ID != 21 {
+		t.Errorf("image ID = %d, want 21", image.ID)
+	}
+}
+
+func TestResolveImageBySelector_IgnoresRemovalSnapshots(t *testing.T) {
+	now := time.Now()
+	backup := goldenSnapshot(31, "x86", now)
+	backup.Labels[snapshotBackupLabel] = "true"
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/images", func(w http.ResponseWriter, r *http.Request) {
+		jsonResponse(w, http.StatusOK, schema.ImageListResponse{
+			Images: []schema.Image{backup, goldenSnapshot(30, "x86", now.Add(-time.Hour))},
+		})
+	})
+
+	d, _ := newTestDriver(t, mux)
+	d.ImageSelector = "role=rke2-node"
+
+	image, err := d.resolveImage(testCtx(t), hcloud.ArchitectureX86)
+	if err != nil {
+		t.Fatalf("resolveImage() error: %v", err)
+	}
+	if image.ID != 30 {
+		t.Errorf("image ID = %d, want 30 (the newer image is a removal snapshot)", image.ID)
+	}
+}
+
+func TestResolveImageBySelector_NoMatch(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/images", func(w http.ResponseWriter, r *http.Request) {
+		jsonResponse(w, http.StatusOK, schema.ImageListResponse{Images: []schema.Image{}})
+	})
+
+	d, _ := newTestDriver(t, mux)
+	d.ImageSelector = "role=missing"
+
+	_, err := d.resolveImage(testCtx(t), hcloud.ArchitectureARM)
+	if err == nil {
+		t.Fatal("expected error when no snapshot matches")
+	}
+	if !strings.Contains(err.Error(), "role=missing") || !strings.Contains(err.Error(), "arm") {
+		t.Errorf("error = %q, want it to mention selector and architecture", err)
+	}
+}
+
+func TestBuildServerCreateOpts_ImageSelectorRecordsImageID(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/server_types", func(w http.ResponseWriter, r *http.Request) {
+		jsonResponse(w, http.StatusOK, schema.ServerTypeListResponse{
+			ServerTypes: []schema.ServerType{standardServerType()},
+		})
+	})
+	mux.HandleFunc("/locations", func(w http.ResponseWriter, r *http.Request) {
+		jsonResponse(w, http.StatusOK, schema.LocationListResponse{
+			Locations: []schema.Location{standardLocation()},
+		})
+	})
+	mux.HandleFunc("/images", func(w http.ResponseWriter, r *http.Request) {
+		jsonResponse(w, http.StatusOK, schema.ImageListResponse{
+			Images: []schema.Image{goldenSnapshot(77, "x86", time.Now())},
+		})
+	})
+
+	d, _ := newTestDriver(t, mux)
+	d.ImageSelector = "role=rke2-node"
+
+	opts, err := d.buildServerCreateOpts(testCtx(t), &hcloud.SSHKey{ID: 1}, nil)
+	if err != nil {
+		t.Fatalf("buildServerCreateOpts() error: %v", err)
+	}
+	if opts.Image.ID != 77 {
+		t.Errorf("opts.Image.ID = %d, want 77", opts.Image.ID)
+	}
+	if d.ImageID != 77 {
+		t.Errorf("ImageID = %d, want 77", d.ImageID)
+	}
+}
+
+func TestResolveImage_ImageMapByArchitecture(t *testing.T) {
+	var requested []string
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/images", func(w http.ResponseWriter, r *http.Request) {
+		requested = append(requested, r.URL.Query().Get("name"))
+		img := standardImage()
+		img.Architecture = r.URL.Query().Get("architecture")
+		jsonResponse(w, http.StatusOK, schema.ImageListResponse{Images: []schema.Image{img}})
+	})
+	mux.HandleFunc("/images/4711", func(w http.ResponseWriter, r *http.Request) {
+		requested = append(requested, "4711")
+		jsonResponse(w, http.StatusOK, schema.ImageGetResponse{
+			Image: goldenSnapshot(4711, "arm", time.Now()),
+		})
+	})
+
+	d, _ := newTestDriver(t, mux)
+	d.ImageMap = map[string]string{"x86": "debian-12", "arm": "4711"}
+
+	image, err := d.resolveImage(testCtx(t), hcloud.ArchitectureARM)
+	if err != nil {
+		t.Fatalf("resolveImage(arm) error: %v", err)
+	}
+	if image.ID != 4711 {
+		t.Errorf("arm image ID = %d, want 4711", image.ID)
+	}
+
+	if _, err := d.resolveImage(testCtx(t), hcloud.ArchitectureX86); err != nil {
+		t.Fatalf("resolveImage(x86) error: %v", err)
+	}
+
+	if strings.Join(requested, ",") != "4711,debian-12" {
+		t.Errorf("requested = %v, want [4711 debian-12]", requested)
+	}
+}
+
+func TestResolveImage_IDWithWrongArchitecture(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/images/4711", func(w http.ResponseWriter, r *http.Request) {
+		jsonResponse(w, http.StatusOK, schema.ImageGetResponse{
+			Image: goldenSnapshot(4711, "arm", time.Now()),
+		})
+	})
+
+	d, _ := newTestDriver(t, mux)
+	d.Image = "4711"
+
+	_, err := d.resolveImage(testCtx(t), hcloud.ArchitectureX86)
+	if err == nil {
+		t.Fatal("expected error for image with wrong architecture")
+	}
+	if !strings.Contains(err.Error(), "architecture") {
+		t.Errorf("error = %q, want it to mention 'architecture'", err)
+	}
+}
+
+func TestResolveImage_ImageMapFallsBackToImage(t *testing.T) {
+	var requested string
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/images", func(w http.ResponseWriter, r *http.Request) {
+		requested = r.URL.Query().Get("name")
+		jsonResponse(w, http.StatusOK, schema.ImageListResponse{Images: []schema.Image{standardImage()}})
+	})
+
+	d, _ := newTestDriver(t, mux)
+	d.ImageMap = map[string]string{"arm": "4711"}
+
+	if _, err := d.resolveImage(testCtx(t), hcloud.ArchitectureX86); err != nil {
+		t.Fatalf("resolveImage() error: %v", err)
+	}
+	if requested != defaultImage {
+		t.Errorf("requested image = %q, want fallback %q", requested, defaultImage)
+	}
+}
+
+func TestParseImageMap(t *testing.T) {
+	m, err := parseImageMap([]string{"x86=ubuntu-24.04", " arm = 123 "})
+	if err != nil {
+		t.Fatalf("parseImageMap() error: %v", err)
+	}
+	if m["x86"] != "ubuntu-24.04" || m["arm"] != "123" {
+		t.Errorf("parseImageMap() = %v", m)
+	}
+
+	m, err = parseImageMap(nil)
+	if err != nil || m != nil {
+		t.Errorf("parseImageMap(nil) = %v, %v; want nil, nil", m, err)
+	}
+}
@@ -0,0 +1,413 @@
+package driver
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/hetznercloud/hcloud-go/v2/hcloud"
+	"github.com/rancher/machine/libmachine/log"
+)
+
+const (
+	defaultLoadBalancerType = "lb11"
+
+	// loadBalancerRoleLabel distinguishes the cluster's load balancers.
+	loadBalancerRoleLabel        = "load-balancer"
+	loadBalancerRoleControlPlane = "control-plane"
+	loadBalancerRoleIngress      = "ingress"
+
+	// ingressLabel marks the servers of ingress pools; the ingress load
+	// balancer targets them with a label selector.
+	ingressLabel = "ingress"
+)
+
+// controlPlanePorts are forwarded by the control-plane load balancer:
+// the Kubernetes API and the RKE2 supervisor (node registration).
+var controlPlanePorts = []int{6443, 9345}
+
+// defaultIngressPorts are forwarded by the ingress load balancer unless
+// --hetzner-ingress-ports is set.
+var defaultIngressPorts = []int{80, 443}
+
+// parseIngressPorts parses --hetzner-ingress-ports entries.
+func parseIngressPorts(entries []string) ([]int, error) {
+	if len(entries) == 0 {
+		return nil, nil
+	}
+	ports := make([]int, 0, len(entries))
+	seen := make(map[int]bool, len(entries))
+	for _, entry := range entries {
+		port, err := strconv.Atoi(entry)
+		if err != nil || port < 1 || port > 65535 {
+			return nil, fmt.Errorf("hetzner-ingress-ports entry %q must be a port between 1 and 65535", entry)
+		}
+		if seen[port] {
+			return nil, fmt.Errorf("hetzner-ingress-ports contains %d more than once", port)
+		}
+		seen[port] = true
+		ports = append(ports, port)
+	}
+	return ports, nil
+}
+
+// loadBalancerSelector selects the cluster's load balancer with the given role.
+func (d *Driver) loadBalancerSelector(role string) string {
+	return fmt.Sprintf("managed-by=rancher-machine,cluster=%s,%s=%s", d.ClusterID, loadBalancerRoleLabel, role)
+}
+
+// findClusterLoadBalancer looks up the cluster's load balancer with the given role by label.
+func (d *Driver) findClusterLoadBalancer(ctx context.Context, role string) (*hcloud.LoadBalancer, error) {
+	selector := d.loadBalancerSelector(role)
+	lbs, err := d.getClient().LoadBalancer.AllWithOpts(ctx, hcloud.LoadBalancerListOpts{
+		ListOpts: hcloud.ListOpts{LabelSelector: selector},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list load balancers: %w", err)
+	}
+	if len(lbs) == 0 {
+		return nil, nil
+	}
+	if len(lbs) > 1 {
+		return nil, fmt.Errorf("multiple load balancers found for selector %q (count=%d); please delete or consolidate duplicates", selector, len(lbs))
+	}
+	return lbs[0], nil
+}
+
+// tcpServices returns pass-through TCP services with TCP health checks for the ports.
+func tcpServices(ports []int) []hcloud.LoadBalancerCreateOptsService {
+	services := make([]hcloud.LoadBalancerCreateOptsService, 0, len(ports))
+	for _, port := range ports {
+		services = append(services, hcloud.LoadBalancerCreateOptsService{
+			Protocol:        hcloud.LoadBalancerServiceProtocolTCP,
+			ListenPort:      hcloud.Ptr(port),
+			DestinationPort: hcloud.Ptr(port),
+			HealthCheck: &hcloud.LoadBalancerCreateOptsServiceHealthCheck{
+				Protocol: hcloud.LoadBalancerServiceProtocolTCP,
+				Port:     hcloud.Ptr(port),
+			},
+		})
+	}
+	return services
+}
+
+// loadBalancerNetwork returns the private network load balancer targets are
+// reached through, or nil when the public network is used.
+func (d *Driver) loadBalancerNetwork(ctx context.Context) (*hcloud.Network, error) {
+	if !d.UsePrivateNetwork || len(d.Networks) == 0 {
+		return nil, nil
+	}
+	network, err := d.resolveNetwork(ctx, d.Networks[0])
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve network %q for load balancer: %w", d.Networks[0], err)
+	}
+	return network, nil
+}
+
+// findOrCreateClusterLoadBalancer finds the cluster's load balancer with the
+// given role or creates it in ServerLocation, attached to network if set.
+func (d *Driver) findOrCreateClusterLoadBalancer(ctx context.Context, role, name string,
+	services []hcloud.LoadBalancerCreateOptsService, network *hcloud.Network) (*hcloud.LoadBalancer, error) {
+	lb, err := d.findClusterLoadBalancer(ctx, role)
+	if err != nil {
+		return nil, err
+	}
+	if lb != nil {
+		log.Infof("Found existing %s load balancer %q (ID=%d)", role, lb.Name, lb.ID)
+		if err := d.ensureLoadBalancerNetwork(ctx, lb, network); err != nil {
+			return nil, err
+		}
+		return lb, nil
+	}
+
+	lbType := d.LoadBalancerType
+	if lbType == "" {
+		lbType = defaultLoadBalancerType
+	}
+	log.Infof("Creating %s load balancer %q (type=%s, location=%s)...", role, name, lbType, d.ServerLocation)
+	result, _, err := d.getClient().LoadBalancer.Create(ctx, hcloud.LoadBalancerCreateOpts{
+		Name:             name,
+		LoadBalancerType: &hcloud.LoadBalancerType{Name: lbType},
+		Location:         &hcloud.Location{Name: d.ServerLocation},
+		Labels: map[string]string{
+			"managed-by":          "rancher-machine",
+			"cluster":             d.ClusterID,
+			loadBalancerRoleLabel: role,
+		},
+		Services: services,
+		Network:  network,
+	})
+	if err != nil {
+		// Another node may have created the load balancer concurrently.
+		log.Infof("Load balancer create failed (%v), checking if created concurrently...", err)
+		lb, findErr := d.findClusterLoadBalancer(ctx, role)
+		if findErr != nil || lb == nil {
+			return nil, fmt.Errorf("failed to create load balancer %q: %w", name, err)
+		}
+		log.Infof("Load balancer %q was created concurrently (ID=%d), using it", lb.Name, lb.ID)
+		if err := d.ensureLoadBalancerNetwork(ctx, lb, network); err != nil {
+			return nil, err
+		}
+		return lb, nil
+	}
+	if result.Action != nil {
+		if err := d.waitForAction(ctx, result.Action); err != nil {
+			return nil, fmt.Errorf("load balancer %q creation action failed: %w", name, err)
+		}
+	}
+
+	log.Infof("Load balancer %q created (ID=%d, IPv4=%s)", name, result.LoadBalancer.ID, result.LoadBalancer.PublicNet.IPv4.IP)
+	return result.LoadBalancer, nil
+}
+
+// ensureLoadBalancerNetwork attaches an existing load balancer to the network
+// so that private-IP targets are reachable.
+func (d *Driver) ensureLoadBalancerNetwork(ctx context.Context, lb *hcloud.LoadBalancer, network *hcloud.Network) error {
+	if network == nil {
+		return nil
+	}
+	for _, pn := range lb.PrivateNet {
+		if pn.Network != nil && pn.Network.ID == network.ID {
+			return nil
+		}
+	}
+	log.Infof("Attaching load balancer %q to network %q...", lb.Name, network.Name)
+	action, _, err := d.getClient().LoadBalancer.AttachToNetwork(ctx, lb, hcloud.LoadBalancerAttachToNetworkOpts{Network: network})
+	if err != nil {
+		if hcloud.IsError(err, hcloud.ErrorCodeLoadBalancerAlreadyAttached) {
+			return nil
+		}
+		return fmt.Errorf("failed to attach load balancer %q to network %q: %w", lb.Name, network.Name, err)
+	}
+	return d.waitForAction(ctx, action)
+}
+
+// registerWithLoadBalancer adds this server as a target of the cluster's
+// control-plane load balancer, creating the load balancer if needed. A load
+// balancer left without targets after a failure is deleted again.
+func (d *Driver) registerWithLoadBalancer(ctx context.Context) error {
+	network, err := d.loadBalancerNetwork(ctx)
+	if err != nil {
+		return err
+	}
+
+	name := d.LoadBalancerName
+	if name == "" {
+		name = "rancher-" + d.ClusterID + "-cp"
+	}
+	lb, err := d.findOrCreateClusterLoadBalancer(ctx, loadBalancerRoleControlPlane, name, tcpServices(controlPlanePorts), network)
+	if err != nil {
+		return fmt.Errorf("failed to set up load balancer: %w", err)
+	}
+	d.LoadBalancerID = lb.ID
+
+	usePrivateIP := network != nil
+	log.Infof("Adding server %d to load balancer %q (private IP: %t)...", d.ServerID, lb.Name, usePrivateIP)
+	action, _, err := d.getClient().LoadBalancer.AddServerTarget(ctx, lb, hcloud.LoadBalancerAddServerTargetOpts{
+		Server:       &hcloud.Server{ID: d.ServerID},
+		UsePrivateIP: hcloud.Ptr(usePrivateIP),
+	})
+	if err == nil {
+		err = d.waitForAction(ctx, action)
+	} else if hcloud.IsError(err, hcloud.ErrorCodeTargetAlreadyDefined) {
+		log.Infof("Server %d is already a target of load balancer %q", d.ServerID, lb.Name)
+		err = nil
+	}
+	if err != nil {
+		cleanupCtx, cleanupCancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cleanupCancel()
+		d.deleteLoadBalancerIfOrphaned(cleanupCtx)
+		return fmt.Errorf("failed to add server %d to load balancer %q: %w", d.ServerID, lb.Name, err)
+	}
+
+	log.Infof("Server %d is a target of load balancer %q", d.ServerID, lb.Name)
+	return nil
+}
+
+// removeLoadBalancerTarget removes this server from the control-plane load
+// balancer. Deleting the server would drop the target as well; removing it
+// first stops new connections before the node goes away.
+func (d *Driver) removeLoadBalancerTarget(ctx context.Context) {
+	if d.LoadBalancerID == 0 || d.ServerID == 0 {
+		return
+	}
+	lb, _, err := d.getClient().LoadBalancer.GetByID(ctx, d.LoadBalancerID)
+	if err != nil {
+		log.Warnf("Failed to get load balancer %d for target removal: %v", d.LoadBalancerID, err)
+		return
+	}
+	if lb == nil {
+		return
+	}
+
+	action, _, err := d.getClient().LoadBalancer.RemoveServerTarget(ctx, lb, &hcloud.Server{ID: d.ServerID})
+	if err != nil {
+		if !hcloud.IsError(err, hcloud.ErrorCodeNotFound) {
+			log.Warnf("Failed to remove server %d from load balancer %q: %v", d.ServerID, lb.Name, err)
+		}
+		return
+	}
+	if err := d.waitForAction(ctx, action); err != nil {
+		log.Warnf("Load balancer target removal action failed: %v", err)
+		return
+	}
+	log.Infof("Removed server %d from load balancer %q", d.ServerID, lb.Name)
+}
+
+// deleteLoadBalancerIfOrphaned deletes the control-plane load balancer once
+// it has no targets left.
+func (d *Driver) deleteLoadBalancerIfOrphaned(ctx context.Context) {
+	if d.LoadBalancerID == 0 {
+		return
+	}
+
+	lb, _, err := d.getClient().LoadBalancer.GetByID(ctx, d.LoadBalancerID)
+	if err != nil {
+		log.Warnf("Failed to get load balancer %d for orphan check: %v", d.LoadBalancerID, err)
+		return
+	}
+	if lb == nil {
+		return
+	}
+
+	if len(lb.Targets) > 0 {
+		log.Infof("Load balancer %q still has %d targets, keeping it", lb.Name, len(lb.Targets))
+		return
+	}
+
+	if _, err := d.getClient().LoadBalancer.Delete(ctx, lb); err != nil {
+		log.Warnf("Failed to delete orphaned load balancer %d: %v", d.LoadBalancerID, err)
+		return
+	}
+	log.Infof("Deleted orphaned load balancer %q (ID=%d)", lb.Name, lb.ID)
+}
+
+// ingressTargetSelector selects the servers of the cluster's ingress pools.
+func (d *Driver) ingressTargetSelector() string {
+	return fmt.Sprintf("cluster=%s,%s=true", d.ClusterID, ingressLabel)
+}
+
+// ensureIngressLoadBalancer makes sure the cluster's ingress load balancer
+// exists, forwards the ingress ports, and targets the ingress pools by label
+// selector. This server carries the ingress label (see resourceLabels), so it
+// becomes a target without being added explicitly.
+func (d *Driver) ensureIngressLoadBalancer(ctx context.Context) error {
+	network, err := d.loadBalancerNetwork(ctx)
+	if err != nil {
+		return err
+	}
+
+	ports := d.IngressPorts
+	if len(ports) == 0 {
+		ports = defaultIngressPorts
+	}
+	name := "rancher-" + d.ClusterID + "-ingress"
+	lb, err := d.findOrCreateClusterLoadBalancer(ctx, loadBalancerRoleIngress, name, tcpServices(ports), network)
+	if err != nil {
+		return fmt.Errorf("failed to set up ingress load balancer: %w", err)
+	}
+	d.IngressLoadBalancerID = lb.ID
+
+	if err := d.ensureLoadBalancerServices(ctx, lb, ports); err != nil {
+		return err
+	}
+	return d.ensureLabelSelectorTarget(ctx, lb, d.ingressTargetSelector(), network != nil)
+}
+
+// ensureLoadBalancerServices adds TCP services for ports the load balancer
+// does not listen on yet, e.g. when a pool was configured with more ports
+// than the pool that created the load balancer. Existing services are left
+// as they are.
+func (d *Driver) ensureLoadBalancerServices(ctx context.Context, lb *hcloud.LoadBalancer, ports []int) error {
+	listening := make(map[int]bool, len(lb.Services))
+	for _, svc := range lb.Services {
+		listening[svc.ListenPort] = true
+	}
+	for _, port := range ports {
+		if listening[port] {
+			continue
+		}
+		log.Infof("Adding TCP service for port %d to load balancer %q...", port, lb.Name)
+		action, _, err := d.getClient().LoadBalancer.AddService(ctx, lb, hcloud.LoadBalancerAddServiceOpts{
+			Protocol:        hcloud.LoadBalancerServiceProtocolTCP,
+			ListenPort:      hcloud.Ptr(port),
+			DestinationPort: hcloud.Ptr(port),
+			HealthCheck: &hcloud.LoadBalancerAddServiceOptsHealthCheck{
+				Protocol: hcloud.LoadBalancerServiceProtocolTCP,
+				Port:     hcloud.Ptr(port),
+			},
+		})
+		if err != nil {
+			if hcloud.IsError(err, hcloud.ErrorCodeSourcePortAlreadyUsed) {
+				continue
+			}
+			return fmt.Errorf("failed to add port %d to load balancer %q: %w", port, lb.Name, err)
+		}
+		if err := d.waitForAction(ctx, action); err != nil {
+			return fmt.Errorf("adding port %d to load balancer %q failed: %w", port, lb.Name, err)
+		}
+	}
+	return nil
+}
+
+// ensureLabelSelectorTarget adds a label selector target unless the load
+// balancer already has it.
+func (d *Driver) ensureLabelSelectorTarget(ctx context.Context, lb *hcloud.LoadBalancer, selector string, usePrivateIP bool) error {
+	for _, target := range lb.Targets {
+		if target.Type == hcloud.LoadBalancerTargetTypeLabelSelector && target.LabelSelector != nil &&
+			target.LabelSelector.Selector == selector {
+			return nil
+		}
+	}
+	log.Infof("Adding label selector target %q to load balancer %q (private IP: %t)...", selector, lb.Name, usePrivateIP)
+	action, _, err := d.getClient().LoadBalancer.AddLabelSelectorTarget(ctx, lb, hcloud.LoadBalancerAddLabelSelectorTargetOpts{
+		Selector:     selector,
+		UsePrivateIP: hcloud.Ptr(usePrivateIP),
+	})
+	if err != nil {
+		if hcloud.IsError(err, hcloud.ErrorCodeTargetAlreadyDefined) {
+			return nil
+		}
+		return fmt.Errorf("failed to add label selector target %q to load balancer %q: %w", selector, lb.Name, err)
+	}
+	return d.waitForAction(ctx, action)
+}
+
+// deleteIngressLoadBalancerIfUnused deletes the ingress load balancer once no
+// server of the cluster carries the ingress label any more. The label
+// selector target stays on the load balancer after its servers are gone, so
+// the servers are counted instead of the targets.
+func (d *Driver) deleteIngressLoadBalancerIfUnused(ctx context.Context) {
+	if d.IngressLoadBalancerID == 0 {
+		return
+	}
+
+	servers, err := d.getClient().Server.AllWithOpts(ctx, hcloud.ServerListOpts{
+		ListOpts: hcloud.ListOpts{LabelSelector: d.ingressTargetSelector()},
+	})
+	if err != nil {
+		log.Warnf("Failed to list ingress servers for load balancer cleanup: %v", err)
+		return
+	}
+	for _, s := range servers {
+		if s.ID != d.ServerID {
+			log.Infof("%d ingress servers remain, keeping ingress load balancer %d", len(servers), d.IngressLoadBalancerID)
+			return
+		}
+	}
+
+	lb, _, err := d.getClient().LoadBalancer.GetByID(ctx, d.IngressLoadBalancerID)
+	if err != nil {
+		log.Warnf("Failed to get ingress load balancer %d for cleanup: %v", d.IngressLoadBalancerID, err)
+		return
+	}
+	if lb == nil {
+		return
+	}
+	if _, err := d.getClient().LoadBalancer.Delete(ctx, lb); err != nil {
+		log.Warnf("Failed to delete unused ingress load balancer %d: %v", d.IngressLoadBalancerID, err)
+		return
+	}
+	log.Infof("Deleted unused ingress load balancer %q (ID=%d)", lb.Name, lb.ID)
+}
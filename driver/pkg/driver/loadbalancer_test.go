@@ -0,0 +1,382 @@
+package driver
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/hetznercloud/hcloud-go/v2/hcloud/schema"
+)
+
+// lbMux serves a single load balancer (nil until created) and records the
+// calls made against it.
+type lbMux struct {
+	*http.ServeMux
+	role     string // expected load-balancer label in list selectors
+	lb       *schema.LoadBalancer
+	created  *schema.LoadBalancerCreateRequest
+	targets  []schema.LoadBalancerActionAddTargetRequest
+	services []int
+	removed  bool
+	attached bool
+	deleted  bool
+}
+
+func newLBMux(t *testing.T, existing *schema.LoadBalancer) *lbMux {
+	t.Helper()
+	m := &lbMux{ServeMux: http.NewServeMux(), role: "control-plane", lb: existing}
+	registerActionPoller(m.ServeMux, 1)
+	m.HandleFunc("/load_balancers", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			var req schema.LoadBalancerCreateRequest
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				t.Fatalf("decode create request: %v", err)
+			}
+			m.created = &req
+			m.lb = &schema.LoadBalancer{ID: 40, Name: req.Name}
+			for _, svc := range req.Services {
+				m.lb.Services = append(m.lb.Services, schema.LoadBalancerService{
+					Protocol: svc.Protocol, ListenPort: *svc.ListenPort, DestinationPort: *svc.DestinationPort,
+				})
+			}
+			jsonResponse(w, http.StatusCreated, schema.LoadBalancerCreateResponse{LoadBalancer: *m.lb, Action: completedAction(1)})
+			return
+		}
+		if got := r.URL.Query().Get("label_selector"); got != "managed-by=rancher-machine,cluster=prod,load-balancer="+m.role {
+			t.Errorf("label_selector = %q", got)
+		}
+		var lbs []schema.LoadBalancer
+		if m.lb != nil {
+			lbs = append(lbs, *m.lb)
+		}
+		jsonResponse(w, http.StatusOK, schema.LoadBalancerListResponse{LoadBalancers: lbs})
+	})
+	m.HandleFunc("/load_balancers/40", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodDelete {
+			m.deleted = true
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		jsonResponse(w, http.StatusOK, schema.LoadBalancerGetResponse{LoadBalancer: *m.lb})
+	})
+	m.HandleFunc("/load_balancers/40/actions/add_target", func(w http.ResponseWriter, r *http.Request) {
+		var req schema.LoadBalancerActionAddTargetRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Fatalf("decode add_target request: %v", err)
+		}
+		m.targets = append(m.targets, req)
+		jsonResponse(w, http.StatusCreated, schema.LoadBalancerActionAddTargetResponse{Action: completedAction(1)})
+	})
+	m.HandleFunc("/load_balancers/40/actions/remove_target", func(w http.ResponseWriter, r *http.Request) {
+		var req schema.LoadBalancerActionRemoveTargetRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Fatalf("decode remove_target request: %v", err)
+		}
+		m.removed = true
+		var kept []schema.LoadBalancerTarget
+		for _, target := range m.lb.Targets {
+			if target.Server == nil || target.Server.ID != req.Server.ID {
+				kept = append(kept, target)
+			}
+		}
+		m.lb.Targets = kept
+		jsonResponse(w, http.StatusCreated, schema.LoadBalancerActionRemoveTargetResponse{Action: completedAction(1)})
+	})
+	m.HandleFunc("/load_balancers/40/actions/add_service", func(w http.ResponseWriter, r *http.Request) {
+		var req schema.LoadBalancerActionAddServiceRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Fatalf("decode add_service request: %v", err)
+		}
+		m.services = append(m.services, *req.ListenPort)
+		jsonResponse(w, http.StatusCreated, schema.LoadBalancerActionAddServiceResponse{Action: completedAction(1)})
+	})
+	m.HandleFunc("/load_balancers/40/actions/attach_to_network", func(w http.ResponseWriter, r *http.Request) {
+		m.attached = true
+		jsonResponse(w, http.StatusCreated, schema.LoadBalancerActionAttachToNetworkResponse{Action: completedAction(1)})
+	})
+	return m
+}
+
+func lbDriver(t *testing.T, m *lbMux) *Driver {
+	d := clusterNodeDriver(t, m.ServeMux)
+	d.CreateLoadBalancer = true
+	return d
+}
+
+func TestRegisterWithLoadBalancer_CreatesLoadBalancer(t *testing.T) {
+	m := newLBMux(t, nil)
+	d := lbDriver(t, m)
+
+	if err := d.registerWithLoadBalancer(testCtx(t)); err != nil {
+		t.Fatalf("registerWithLoadBalancer() error: %v", err)
+	}
+	if m.created == nil {
+		t.Fatal("load balancer was not created")
+	}
+	if m.created.Name != "rancher-prod-cp" || m.created.LoadBalancerType.Name != "lb11" {
+		t.Errorf("created %q of type %+v, want rancher-prod-cp of type lb11", m.created.Name, m.created.LoadBalancerType)
+	}
+	if m.created.Labels == nil || (*m.created.Labels)["load-balancer"] != "control-plane" {
+		t.Errorf("labels = %v, want load-balancer=control-plane", m.created.Labels)
+	}
+	var ports []int
+	for _, svc := range m.created.Services {
+		ports = append(ports, *svc.ListenPort)
+	}
+	if len(ports) != 2 || ports[0] != 6443 || ports[1] != 9345 {
+		t.Errorf("service ports = %v, want [6443 9345]", ports)
+	}
+	if len(m.targets) != 1 || m.targets[0].Server.ID != 100 {
+		t.Fatalf("targets = %+v, want server 100", m.targets)
+	}
+	if m.targets[0].UsePrivateIP == nil || *m.targets[0].UsePrivateIP {
+		t.Error("target should use the public IP without a private network")
+	}
+	if d.LoadBalancerID != 40 {
+		t.Errorf("LoadBalancerID = %d, want 40", d.LoadBalancerID)
+	}
+}
+
+func TestRegisterWithLoadBalancer_ExistingPrivateNetwork(t *testing.T) {
+	m := newLBMux(t, &schema.LoadBalancer{ID: 40, Name: "rancher-prod-cp"})
+	m.HandleFunc("/networks", func(w http.ResponseWriter, r *http.Request) {
+		jsonResponse(w, http.StatusOK, schema.NetworkListResponse{
+			Networks: []schema.Network{{ID: 8, Name: "private", IPRange: "10.0.0.0/16"}},
+		})
+	})
+	d := lbDriver(t, m)
+	d.UsePrivateNetwork = true
+	d.Networks = []string{"private"}
+
+	if err := d.registerWithLoadBalancer(testCtx(t)); err != nil {
+		t.Fatalf("registerWithLoadBalancer() error: %v", err)
+	}
+	if m.created != nil {
+		t.Error("existing load balancer should be reused")
+	}
+	if !m.attached {
+		t.Error("load balancer should be attached to the private network")
+	}
+	if len(m.targets) != 1 || m.targets[0].UsePrivateIP == nil || !*m.targets[0].UsePrivateIP {
+		t.Errorf("targets = %+v, want private IP target", m.targets)
+	}
+}
+
+func TestRegisterWithLoadBalancer_TargetAlreadyDefined(t *testing.T) {
+	m := newLBMux(t, &schema.LoadBalancer{ID: 40, Name: "rancher-prod-cp"})
+	mux := http.NewServeMux()
+	mux.HandleFunc("/load_balancers/40/actions/add_target", func(w http.ResponseWriter, r *http.Request) {
+		jsonResponse(w, http.StatusUnprocessableEntity, schema.ErrorResponse{
+			Error: schema.Error{Code: "target_already_defined", Message: "target already defined"},
+		})
+	})
+	mux.Handle("/", m.ServeMux)
+	d := clusterNodeDriver(t, mux)
+	d.CreateLoadBalancer = true
+
+	if err := d.registerWithLoadBalancer(testCtx(t)); err != nil {
+		t.Fatalf("registerWithLoadBalancer() error: %v", err)
+	}
+}
+
+func TestRemove_RemovesLoadBalancerTargetAndDeletesOrphan(t *testing.T) {
+	for _, otherTargets := range []bool{false, true} {
+		lb := &schema.LoadBalancer{ID: 40, Name: "rancher-prod-cp", Targets: []schema.LoadBalancerTarget{
+			{Type: "server", Server: &schema.LoadBalancerTargetServer{ID: 100}},
+		}}
+		if otherTargets {
+			lb.Targets = append(lb.Targets, schema.LoadBalancerTarget{Type: "server", Server: &schema.LoadBalancerTargetServer{ID: 101}})
+		}
+		m := newLBMux(t, lb)
+		registerServers(m.ServeMux, nil)
+		d := lbDriver(t, m)
+		d.LoadBalancerID = 40
+
+		if err := d.Remove(); err != nil {
+			t.Fatalf("Remove() error: %v", err)
+		}
+		if !m.removed {
+			t.Error("server was not removed from the load balancer")
+		}
+		if m.deleted == otherTargets {
+			t.Errorf("other targets %v: deleted = %v", otherTargets, m.deleted)
+		}
+	}
+}
+
+func TestPreCreateCheck_LoadBalancerPrivateNetworkRequiresNetworks(t *testing.T) {
+	d, _ := newTestDriver(t, http.NewServeMux())
+	d.CreateLoadBalancer = true
+	d.UsePrivateNetwork = true
+
+	err := d.PreCreateCheck()
+	if err == nil || !strings.Contains(err.Error(), "requires --hetzner-networks") {
+		t.Fatalf("PreCreateCheck() error = %v, want networks error", err)
+	}
+}
+
+// ingressDriver returns a driver for an ingress pool; servers lists the
+// remaining servers carrying the ingress label.
+func ingressDriver(t *testing.T, m *lbMux, servers ...int64) *Driver {
+	registerIngressServers(t, m, servers...)
+	d := clusterNodeDriver(t, m.ServeMux)
+	d.IngressLoadBalancer = true
+	return d
+}
+
+// registerIngressServers switches m to the ingress load balancer and lists
+// servers as the servers carrying the ingress label.
+func registerIngressServers(t *testing.T, m *lbMux, servers ...int64) {
+	m.role = "ingress"
+	m.HandleFunc("/servers", func(w http.ResponseWriter, r *http.Request) {
+		if got := r.URL.Query().Get("label_selector"); got != "cluster=prod,ingress=true" {
+			t.Errorf("label_selector = %q, want cluster=prod,ingress=true", got)
+		}
+		var resp []schema.Server
+		for _, id := range servers {
+			resp = append(resp, standardServer(id, "running"))
+		}
+		jsonResponse(w, http.StatusOK, schema.ServerListResponse{Servers: resp})
+	})
+}
+
+func TestEnsureIngressLoadBalancer_CreatesWithLabelSelectorTarget(t *testing.T) {
+	m := newLBMux(t, nil)
+	d := ingressDriver(t, m)
+
+	if err := d.ensureIngressLoadBalancer(testCtx(t)); err != nil {
+		t.Fatalf("ensureIngressLoadBalancer() error: %v", err)
+	}
+	if m.created == nil || m.created.Name != "rancher-prod-ingress" {
+		t.Fatalf("created = %+v, want rancher-prod-ingress", m.created)
+	}
+	if (*m.created.Labels)["load-balancer"] != "ingress" {
+		t.Errorf("labels = %v, want load-balancer=ingress", *m.created.Labels)
+	}
+	if len(m.created.Services) != 2 || *m.created.Services[0].ListenPort != 80 || *m.created.Services[1].ListenPort != 443 {
+		t.Errorf("services = %+v, want ports 80 and 443", m.created.Services)
+	}
+	if len(m.services) != 0 {
+		t.Errorf("added services %v after create, want none", m.services)
+	}
+	if len(m.targets) != 1 || m.targets[0].Type != "label_selector" || m.targets[0].LabelSelector.Selector != "cluster=prod,ingress=true" {
+		t.Fatalf("targets = %+v, want label selector cluster=prod,ingress=true", m.targets)
+	}
+	if d.IngressLoadBalancerID != 40 {
+		t.Errorf("IngressLoadBalancerID = %d, want 40", d.IngressLoadBalancerID)
+	}
+	if d.resourceLabels()["ingress"] != "true" {
+		t.Errorf("resourceLabels() = %v, want ingress=true", d.resourceLabels())
+	}
+}
+
+func TestEnsureIngressLoadBalancer_ExistingAddsMissingPorts(t *testing.T) {
+	m := newLBMux(t, &schema.LoadBalancer{
+		ID: 40, Name: "rancher-prod-ingress",
+		Services: []schema.LoadBalancerService{{Protocol: "tcp", ListenPort: 80, DestinationPort: 80}},
+		Targets: []schema.LoadBalancerTarget{{
+			Type: "label_selector", LabelSelector: &schema.LoadBalancerTargetLabelSelector{Selector: "cluster=prod,ingress=true"},
+		}},
+	})
+	d := ingressDriver(t, m)
+	d.IngressPorts = []int{80, 443, 8443}
+
+	if err := d.ensureIngressLoadBalancer(testCtx(t)); err != nil {
+		t.Fatalf("ensureIngressLoadBalancer() error: %v", err)
+	}
+	if m.created != nil {
+		t.Error("existing load balancer should be reused")
+	}
+	if len(m.services) != 2 || m.services[0] != 443 || m.services[1] != 8443 {
+		t.Errorf("added services %v, want [443 8443]", m.services)
+	}
+	if len(m.targets) != 0 {
+		t.Errorf("targets = %+v, want existing label selector target kept", m.targets)
+	}
+}
+
+func TestRemove_DeletesIngressLoadBalancerWithLastIngressServer(t *testing.T) {
+	for _, remaining := range [][]int64{nil, {101}} {
+		m := newLBMux(t, &schema.LoadBalancer{ID: 40, Name: "rancher-prod-ingress"})
+		registerServers(m.ServeMux, nil)
+		d := ingressDriver(t, m, remaining...)
+		d.IngressLoadBalancerID = 40
+
+		if err := d.Remove(); err != nil {
+			t.Fatalf("Remove() error: %v", err)
+		}
+		if m.deleted != (len(remaining) == 0) {
+			t.Errorf("remaining servers %v: deleted = %v", remaining, m.deleted)
+		}
+	}
+}
+
+func TestParseIngressPorts(t *testing.T) {
+	ports, err := parseIngressPorts([]string{"80", "443"})
+	if err != nil || len(ports) != 2 || ports[0] != 80 || ports[1] != 443 {
+		t.Errorf("parseIngressPorts() = %v, %v, want [80 443]", ports, err)
+	}
+	for _, entries := range [][]string{{"http"}, {"0"}, {"70000"}, {"80", "80"}} {
+		if _, err := parseIngressPorts(entries); err == nil {
+			t.Errorf("parseIngressPorts(%v) expected error", entries)
+		}
+	}
+}
+
+// failAddTarget wraps m so that adding a load balancer target fails.
+func failAddTarget(m *lbMux) *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/load_balancers/40/actions/add_target", func(w http.ResponseWriter, r *http.Request) {
+		jsonResponse(w, http.StatusForbidden, schema.ErrorResponse{
+			Error: schema.Error{Code: "forbidden", Message: "insufficient permissions"},
+		})
+	})
+	mux.Handle("/", m.ServeMux)
+	return mux
+}
+
+func TestCreate_LoadBalancerFailure_ReleasesResources(t *testing.T) {
+	m := newLBMux(t, nil)
+	var deleted []int64
+	registerServers(m.ServeMux, &deleted, standardServer(100, "running"))
+	sshKeyDeleted := false
+	d := createDriver(t, createMux(failAddTarget(m), &sshKeyDeleted))
+	d.CreateLoadBalancer = true
+
+	if err := d.Create(); err == nil {
+		t.Fatal("expected error from Create()")
+	}
+	if len(deleted) != 1 || deleted[0] != 100 {
+		t.Errorf("deleted servers %v, want [100]", deleted)
+	}
+	if !m.deleted {
+		t.Error("the load balancer created for the node should be deleted")
+	}
+	if !sshKeyDeleted {
+		t.Error("SSH key should be deleted")
+	}
+}
+
+func TestCreate_IngressLoadBalancerFailure_ReleasesResources(t *testing.T) {
+	m := newLBMux(t, nil)
+	var deleted []int64
+	registerServers(m.ServeMux, &deleted, standardServer(100, "running"))
+	registerIngressServers(t, m)
+	sshKeyDeleted := false
+	d := createDriver(t, createMux(failAddTarget(m), &sshKeyDeleted))
+	d.IngressLoadBalancer = true
+
+	if err := d.Create(); err == nil {
+		t.Fatal("expected error from Create()")
+	}
+	if len(deleted) != 1 || deleted[0] != 100 {
+		t.Errorf("deleted servers %v, want [100]", deleted)
+	}
+	if !m.deleted {
+		t.Error("the unused ingress load balancer should be deleted")
+	}
+	if !sshKeyDeleted {
+		t.Error("SSH key should be deleted")
+	}
+}
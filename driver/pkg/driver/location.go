@@ -0,0 +1,150 @@
+package driver
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"strings"
+
+	"github.com/hetznercloud/hcloud-go/v2/hcloud"
+	"github.com/rancher/machine/libmachine/log"
+)
+
+// parseServerLocations normalizes the --hetzner-server-locations entries and
+// rejects empty and duplicate ones.
+func parseServerLocations(entries []string) ([]string, error) {
+	if len(entries) == 0 {
+		return nil, nil
+	}
+	locations := make([]string, 0, len(entries))
+	seen := make(map[string]bool, len(entries))
+	for _, entry := range entries {
+		loc := strings.ToLower(strings.TrimSpace(entry))
+		if loc == "" {
+			return nil, fmt.Errorf("hetzner-server-locations must not contain empty entries")
+		}
+		if seen[loc] {
+			return nil, fmt.Errorf("hetzner-server-locations contains %q more than once", loc)
+		}
+		seen[loc] = true
+		locations = append(locations, loc)
+	}
+	return locations, nil
+}
+
+// selectServerLocation picks one of ServerLocations for this machine and
+// stores it in ServerLocation, which is persisted with the machine config.
+//
+// The location with the fewest servers of the same cluster and pool wins,
+// which spreads a pool round-robin across the list. PreCreateCheck derives
+// ClusterID and Pool from the machine name when they are not set, so the
+// server carries the labels counted here. Ties, and lists that fail, are
+// broken by a hash of the machine name, so machines created at the same time
+// still tend to land in different locations.
+func (d *Driver) selectServerLocation(ctx context.Context) error {
+	locations := make([]*hcloud.Location, 0, len(d.ServerLocations))
+	for _, name := range d.ServerLocations {
+		loc, _, err := d.getClient().Location.GetByName(ctx, name)
+		if err != nil {
+			return fmt.Errorf("invalid location %q: %w", name, err)
+		}
+		if loc == nil {
+			return fmt.Errorf("location %q not found", name)
+		}
+		locations = append(locations, loc)
+	}
+	if err := d.checkLocationNetworkZones(ctx, locations); err != nil {
+		return err
+	}
+
+	counts, err := d.poolServersPerLocation(ctx)
+	if err != nil {
+		log.Warnf("Warning: cannot count pool servers per location, choosing by machine name: %v", err)
+	}
+
+	fewest := -1
+	var candidates []string
+	for _, loc := range locations {
+		n := counts[loc.Name]
+		switch {
+		case fewest < 0 || n < fewest:
+			fewest = n
+			candidates = []string{loc.Name}
+		case n == fewest:
+			candidates = append(candidates, loc.Name)
+		}
+	}
+
+	h := fnv.New32a()
+	h.Write([]byte(d.MachineName))
+	d.ServerLocation = candidates[h.Sum32()%uint32(len(candidates))]
+
+	if counts != nil {
+		log.Infof("Selected location %q from %s (%d servers of pool %q there; counts: %v)",
+			d.ServerLocation, strings.Join(d.ServerLocations, ","), fewest, d.Pool, counts)
+	} else {
+		log.Infof("Selected location %q from %s by machine name", d.ServerLocation, strings.Join(d.ServerLocations, ","))
+	}
+	return nil
+}
+
+// poolServersPerLocation counts the servers labelled with the machine's
+// cluster and pool per location.
+func (d *Driver) poolServersPerLocation(ctx context.Context) (map[string]int, error) {
+	if d.ClusterID == "" || d.Pool == "" {
+		return nil, fmt.Errorf("cluster ID and pool are not set")
+	}
+	servers, err := d.getClient().Server.AllWithOpts(ctx, hcloud.ServerListOpts{
+		ListOpts: hcloud.ListOpts{LabelSelector: fmt.Sprintf("cluster=%s,pool=%s", d.ClusterID, d.Pool)},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list pool servers: %w", err)
+	}
+	counts := make(map[string]int)
+	for _, s := range servers {
+		if s.Location != nil {
+			counts[s.Location.Name]++
+		}
+	}
+	return counts, nil
+}
+
+// checkLocationNetworkZones makes sure a pool spread across locations can
+// still join its private networks. Networks only span a single network zone,
+// so all locations must share one, and every configured network needs a
+// subnet in it. Without private networks a zone mismatch only warns.
+func (d *Driver) checkLocationNetworkZones(ctx context.Context, locations []*hcloud.Location) error {
+	zone := locations[0].NetworkZone
+	for _, loc := range locations[1:] {
+		if loc.NetworkZone == zone {
+			continue
+		}
+		if len(d.Networks) > 0 {
+			return fmt.Errorf("locations %q (%s) and %q (%s) are in different network zones; "+
+				"private networks cannot span network zones, so all --hetzner-server-locations must share one",
+				locations[0].Name, zone, loc.Name, loc.NetworkZone)
+		}
+		log.Warnf("Warning: locations %q (%s) and %q (%s) are in different network zones; "+
+			"nodes will only be able to reach each other over public IPs",
+			locations[0].Name, zone, loc.Name, loc.NetworkZone)
+		return nil
+	}
+
+	for _, ref := range d.Networks {
+		network, err := d.resolveNetwork(ctx, ref)
+		if err != nil {
+			return fmt.Errorf("failed to resolve network %q: %w", ref, err)
+		}
+		hasZone := false
+		for _, subnet := range network.Subnets {
+			if subnet.NetworkZone == zone {
+				hasZone = true
+				break
+			}
+		}
+		if !hasZone {
+			return fmt.Errorf("network %q has no subnet in network zone %s used by --hetzner-server-locations", network.Name, zone)
+		}
+	}
+	return nil
+}
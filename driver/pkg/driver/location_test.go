@@ -0,0 +1,224 @@
+package driver
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/hetznercloud/hcloud-go/v2/hcloud/schema"
+)
+
+var testLocations = []schema.Location{
+	{ID: 1, Name: "fsn1", NetworkZone: "eu-central"},
+	{ID: 2, Name: "nbg1", NetworkZone: "eu-central"},
+	{ID: 3, Name: "hel1", NetworkZone: "eu-central"},
+	{ID: 4, Name: "ash", NetworkZone: "us-east"},
+}
+
+// locationMux serves the test locations by name and lists pool servers in
+// the given locations.
+func locationMux(t *testing.T, serverLocations ...string) *http.ServeMux {
+	t.Helper()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/locations", func(w http.ResponseWriter, r *http.Request) {
+		name := r.URL.Query().Get("name")
+		var resp []schema.Location
+		for _, loc := range testLocations {
+			if loc.Name == name {
+				resp = append(resp, loc)
+			}
+		}
+		jsonResponse(w, http.StatusOK, schema.LocationListResponse{Locations: resp})
+	})
+	mux.HandleFunc("/servers", func(w http.ResponseWriter, r *http.Request) {
+		if got := r.URL.Query().Get("label_selector"); got != "cluster=prod,pool=cp" {
+			t.Errorf("label_selector = %q, want cluster=prod,pool=cp", got)
+		}
+		var servers []schema.Server
+		for i, name := range serverLocations {
+			s := standardServer(int64(i+1), "running")
+			for _, loc := range testLocations {
+				if loc.Name == name {
+					s.Location = loc
+				}
+			}
+			servers = append(servers, s)
+		}
+		jsonResponse(w, http.StatusOK, schema.ServerListResponse{Servers: servers})
+	})
+	return mux
+}
+
+func TestSelectServerLocation_FewestPoolServers(t *testing.T) {
+	d, _ := newTestDriver(t, locationMux(t, "fsn1", "hel1", "fsn1"))
+	d.MachineName = "prod-cp-abc12-xyz34"
+	d.ClusterID, d.Pool = "prod", "cp"
+	d.ServerLocations = []string{"fsn1", "nbg1", "hel1"}
+
+	if err := d.selectServerLocation(testCtx(t)); err != nil {
+		t.Fatalf("selectServerLocation() error: %v", err)
+	}
+	if d.ServerLocation != "nbg1" {
+		t.Errorf("ServerLocation = %q, want nbg1", d.ServerLocation)
+	}
+}
+
+func TestCreate_ServerLocationsLabelsCountedPool(t *testing.T) {
+	locations := locationMux(t, "fsn1", "hel1", "fsn1")
+	next := http.NewServeMux()
+	registerServers(next, nil, standardServer(100, "running"))
+	sshKeyDeleted := false
+	create := createMux(next, &sshKeyDeleted)
+
+	var sent schema.ServerCreateRequest
+	mux := http.NewServeMux()
+	mux.Handle("/locations", locations)
+	mux.HandleFunc("/servers", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			locations.ServeHTTP(w, r)
+			return
+		}
+		if err := json.NewDecoder(r.Body).Decode(&sent); err != nil {
+			t.Fatalf("decode server create request: %v", err)
+		}
+		create.ServeHTTP(w, r)
+	})
+	mux.Handle("/", create)
+
+	d := createDriver(t, mux)
+	d.MachineName = "prod-cp-abc12-xyz34"
+	d.ClusterID = ""
+	d.ServerLocations = []string{"fsn1", "nbg1", "hel1"}
+
+	if err := d.PreCreateCheck(); err != nil {
+		t.Fatalf("PreCreateCheck() error: %v", err)
+	}
+	acceptSSH(t)
+	if err := d.Create(); err != nil {
+		t.Fatalf("Create() error: %v", err)
+	}
+	if d.ServerLocation != "nbg1" || sent.Location != "2" {
+		t.Errorf("ServerLocation = %q, sent location %q, want nbg1 (ID 2)", d.ServerLocation, sent.Location)
+	}
+	if sent.Labels == nil || (*sent.Labels)["cluster"] != "prod" || (*sent.Labels)["pool"] != "cp" {
+		t.Errorf("labels = %v, want the counted cluster=prod and pool=cp", sent.Labels)
+	}
+}
+
+func TestPreCreateCheck_ServerLocationsRequirePool(t *testing.T) {
+	d, _ := newTestDriver(t, locationMux(t))
+	d.MachineName = "standalone"
+	d.ServerLocations = []string{"fsn1", "nbg1"}
+
+	err := d.PreCreateCheck()
+	if err == nil || !strings.Contains(err.Error(), "--hetzner-pool is required") {
+		t.Fatalf("PreCreateCheck() error = %v, want hetzner-pool required", err)
+	}
+}
+
+func TestSelectServerLocation_TieIsDeterministic(t *testing.T) {
+	var first string
+	for i := 0; i < 3; i++ {
+		d, _ := newTestDriver(t, locationMux(t, "fsn1"))
+		d.MachineName = "prod-cp-abc12-xyz34"
+		d.ClusterID, d.Pool = "prod", "cp"
+		d.ServerLocations = []string{"fsn1", "nbg1", "hel1"}
+
+		if err := d.selectServerLocation(testCtx(t)); err != nil {
+			t.Fatalf("selectServerLocation() error: %v", err)
+		}
+		if d.ServerLocation == "fsn1" {
+			t.Errorf("ServerLocation = fsn1, want one of the empty locations")
+		}
+		if first == "" {
+			first = d.ServerLocation
+		} else if d.ServerLocation != first {
+			t.Errorf("ServerLocation = %q, want stable %q", d.ServerLocation, first)
+		}
+	}
+}
+
+func TestSelectServerLocation_HashWithoutPool(t *testing.T) {
+	d, _ := newTestDriver(t, locationMux(t))
+	d.MachineName = "standalone"
+	d.ServerLocations = []string{"fsn1", "nbg1", "hel1"}
+
+	if err := d.selectServerLocation(testCtx(t)); err != nil {
+		t.Fatalf("selectServerLocation() error: %v", err)
+	}
+	found := false
+	for _, loc := range d.ServerLocations {
+		found = found || loc == d.ServerLocation
+	}
+	if !found {
+		t.Errorf("ServerLocation = %q, want one of %v", d.ServerLocation, d.ServerLocations)
+	}
+}
+
+func TestSelectServerLocation_NetworkZoneMismatch(t *testing.T) {
+	d, _ := newTestDriver(t, locationMux(t))
+	d.MachineName = "prod-cp-abc12-xyz34"
+	d.ServerLocations = []string{"fsn1", "ash"}
+	d.Networks = []string{"private"}
+
+	err := d.selectServerLocation(testCtx(t))
+	if err == nil || !strings.Contains(err.Error(), "different network zones") {
+		t.Fatalf("selectServerLocation() error = %v, want network zone error", err)
+	}
+
+	// Without private networks a mismatch is allowed
+	d.Networks = nil
+	if err := d.selectServerLocation(testCtx(t)); err != nil {
+		t.Fatalf("selectServerLocation() without networks error: %v", err)
+	}
+}
+
+func TestSelectServerLocation_NetworkWithoutSubnetInZone(t *testing.T) {
+	mux := locationMux(t)
+	mux.HandleFunc("/networks", func(w http.ResponseWriter, r *http.Request) {
+		jsonResponse(w, http.StatusOK, schema.NetworkListResponse{
+			Networks: []schema.Network{{
+				ID: 8, Name: "private", IPRange: "10.0.0.0/16",
+				Subnets: []schema.NetworkSubnet{{Type: "cloud", IPRange: "10.0.1.0/24", NetworkZone: "us-east"}},
+			}},
+		})
+	})
+	d, _ := newTestDriver(t, mux)
+	d.MachineName = "prod-cp-abc12-xyz34"
+	d.ServerLocations = []string{"fsn1", "nbg1"}
+	d.Networks = []string{"private"}
+
+	err := d.selectServerLocation(testCtx(t))
+	if err == nil || !strings.Contains(err.Error(), "no subnet in network zone eu-central") {
+		t.Fatalf("selectServerLocation() error = %v, want missing subnet error", err)
+	}
+}
+
+func TestSelectServerLocation_UnknownLocation(t *testing.T) {
+	d, _ := newTestDriver(t, locationMux(t))
+	d.ServerLocations = []string{"fsn1", "mars1"}
+
+	if err := d.selectServerLocation(testCtx(t)); err == nil {
+		t.Fatal("expected error for unknown location")
+	}
+}
+
+func TestParseServerLocations(t *testing.T) {
+	locations, err := parseServerLocations([]string{"fsn1", " NBG1 "})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if strings.Join(locations, ",") != "fsn1,nbg1" {
+		t.Errorf("parseServerLocations() = %q, want fsn1,nbg1", locations)
+	}
+	if _, err := parseServerLocations([]string{"fsn1", "fsn1"}); err == nil {
+		t.Error("expected error for duplicate location")
+	}
+	if _, err := parseServerLocations([]string{"fsn1", " FSN1"}); err == nil {
+		t.Error("expected error for duplicate location after trimming")
+	}
+	if _, err := parseServerLocations([]string{"fsn1", " "}); err == nil {
+		t.Error("expected error for empty location")
+	}
+}
@@ -0,0 +1,87 @@
+package driver
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// MachineConfig is a rancher-machine host config (machines/<name>/config.json)
+// with the Hetzner driver state decoded. It lets day-2 operations such as
+// Resize run outside of the plugin server and persist updated driver state.
+// Fields other than "Driver" are preserved verbatim.
+type MachineConfig struct {
+	Driver *Driver
+
+	path string
+	raw  map[string]json.RawMessage
+}
+
+// LoadMachineConfig reads a rancher-machine host config file.
+func LoadMachineConfig(path, version string) (*MachineConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read machine config %q: %w", path, err)
+	}
+
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("failed to parse machine config %q: %w", path, err)
+	}
+	driverData, ok := raw["Driver"]
+	if !ok {
+		return nil, fmt.Errorf("machine config %q has no driver state", path)
+	}
+
+	d := NewDriver("", "", version)
+	if err := json.Unmarshal(driverData, d); err != nil {
+		return nil, fmt.Errorf("failed to parse driver state in %q: %w", path, err)
+	}
+	if d.BaseDriver == nil || d.MachineName == "" {
+		return nil, fmt.Errorf("machine config %q has incomplete driver state", path)
+	}
+
+	return &MachineConfig{Driver: d, path: path, raw: raw}, nil
+}
+
+// Save writes the driver state back to the config file. The file is replaced
+// atomically so a crash never leaves a truncated config behind.
+func (c *MachineConfig) Save() error {
+	driverData, err := json.Marshal(c.Driver)
+	if err != nil {
+		return fmt.Errorf("failed to encode driver state: %w", err)
+	}
+	c.raw["Driver"] = driverData
+
+	data, err := json.MarshalIndent(c.raw, "", "    ")
+	if err != nil {
+		return fmt.Errorf("failed to encode machine config: %w", err)
+	}
+
+	mode := os.FileMode(0600)
+	if fi, err := os.Stat(c.path); err == nil {
+		mode = fi.Mode().Perm()
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(c.path), "config.json.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to write machine config: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write machine config: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write machine config: %w", err)
+	}
+	if err := os.Chmod(tmp.Name(), mode); err != nil {
+		return fmt.Errorf("failed to write machine config: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), c.path); err != nil {
+		return fmt.Errorf("failed to replace machine config %q: %w", c.path, err)
+	}
+	return nil
+}
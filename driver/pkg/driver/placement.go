@@ -0,0 +1,182 @@
+package driver
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/hetznercloud/hcloud-go/v2/hcloud"
+	"github.com/rancher/machine/libmachine/log"
+)
+
+// placementGroupMaxServers is Hetzner's hard limit for spread placement groups.
+const placementGroupMaxServers = 10
+
+// placementGroupCreateAttempts bounds how often a server create moves on to
+// the next spread group after concurrent scale-ups filled the chosen one.
+const placementGroupCreateAttempts = 3
+
+// poolPlacementGroupSelector selects the driver-managed spread groups of this
+// machine's cluster and pool.
+func (d *Driver) poolPlacementGroupSelector() string {
+	return fmt.Sprintf("managed-by=rancher-machine,cluster=%s,pool=%s", d.ClusterID, d.Pool)
+}
+
+// poolPlacementGroupName returns the name of the n-th spread group of the
+// pool: <cluster>-<pool>, then <cluster>-<pool>-2, -3, ...
+func (d *Driver) poolPlacementGroupName(n int) string {
+	name := d.ClusterID + "-" + d.Pool
+	if n > 1 {
+		name += "-" + strconv.Itoa(n)
+	}
+	return name
+}
+
+// findOrCreatePoolPlacementGroup returns the first spread group of the pool
+// with room for another server, creating the next group in the sequence when
+// all existing ones are full. The chosen group is recorded in PlacementGroupID
+// so Remove() can delete it once it is empty.
+func (d *Driver) findOrCreatePoolPlacementGroup(ctx context.Context) (*hcloud.PlacementGroup, error) {
+	groups, err := d.listPoolPlacementGroups(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, pg := range groups {
+		if len(pg.Servers) < placementGroupMaxServers {
+			log.Infof("Using placement group %q (ID=%d, %d/%d servers)", pg.Name, pg.ID, len(pg.Servers), placementGroupMaxServers)
+			d.PlacementGroupID = pg.ID
+			return pg, nil
+		}
+	}
+
+	taken := make(map[string]bool, len(groups))
+	for _, pg := range groups {
+		taken[pg.Name] = true
+	}
+	n := 1
+	for taken[d.poolPlacementGroupName(n)] {
+		n++
+	}
+	name := d.poolPlacementGroupName(n)
+	if len(groups) > 0 {
+		log.Infof("All %d placement groups of pool %q are full, creating %q", len(groups), d.Pool, name)
+	} else {
+		log.Infof("Creating placement group %q for pool %q", name, d.Pool)
+	}
+
+	labels := map[string]string{
+		"managed-by": "rancher-machine",
+		"cluster":    d.ClusterID,
+		"pool":       d.Pool,
+	}
+	result, _, err := d.getClient().PlacementGroup.Create(ctx, hcloud.PlacementGroupCreateOpts{
+		Name:   name,
+		Labels: labels,
+		Type:   hcloud.PlacementGroupTypeSpread,
+	})
+	if err != nil {
+		// Another machine of the pool may have created it concurrently.
+		log.Infof("Placement group create failed (%v), checking if created concurrently...", err)
+		pg, _, getErr := d.getClient().PlacementGroup.GetByName(ctx, name)
+		if getErr != nil || pg == nil || len(pg.Servers) >= placementGroupMaxServers {
+			return nil, fmt.Errorf("failed to create placement group %q: %w", name, err)
+		}
+		log.Infof("Placement group %q was created concurrently (ID=%d), using it", pg.Name, pg.ID)
+		d.PlacementGroupID = pg.ID
+		return pg, nil
+	}
+	if result.Action != nil {
+		if err := d.waitForAction(ctx, result.Action); err != nil {
+			return nil, fmt.Errorf("placement group %q creation action failed: %w", name, err)
+		}
+	}
+
+	log.Infof("Placement group %q created (ID=%d)", name, result.PlacementGroup.ID)
+	d.PlacementGroupID = result.PlacementGroup.ID
+	return result.PlacementGroup, nil
+}
+
+// createServerInPoolPlacementGroup creates the server in the pool's spread
+// group. Choosing the group is read-then-create, so another machine of the
+// pool can fill it first; the create then fails at the API and is retried in
+// the next group with room.
+func (d *Driver) createServerInPoolPlacementGroup(ctx context.Context, opts *hcloud.ServerCreateOpts) (hcloud.ServerCreateResult, error) {
+	for attempt := 1; ; attempt++ {
+		result, _, err := d.getClient().Server.Create(ctx, *opts)
+		if err == nil || attempt == placementGroupCreateAttempts || !d.placementGroupFull(ctx, opts.PlacementGroup.ID) {
+			return result, err
+		}
+		log.Infof("Placement group %q filled up concurrently (%v), retrying in the next group", opts.PlacementGroup.Name, err)
+		pg, err := d.findOrCreatePoolPlacementGroup(ctx)
+		if err != nil {
+			return result, err
+		}
+		opts.PlacementGroup = pg
+	}
+}
+
+// placementGroupFull reports whether the placement group has no room left.
+func (d *Driver) placementGroupFull(ctx context.Context, id int64) bool {
+	pg, _, err := d.getClient().PlacementGroup.GetByID(ctx, id)
+	return err == nil && pg != nil && len(pg.Servers) >= placementGroupMaxServers
+}
+
+// listPoolPlacementGroups returns the pool's spread groups in sequence order.
+func (d *Driver) listPoolPlacementGroups(ctx context.Context) ([]*hcloud.PlacementGroup, error) {
+	groups, err := d.getClient().PlacementGroup.AllWithOpts(ctx, hcloud.PlacementGroupListOpts{
+		ListOpts: hcloud.ListOpts{LabelSelector: d.poolPlacementGroupSelector()},
+		Type:     hcloud.PlacementGroupTypeSpread,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list placement groups: %w", err)
+	}
+	sort.SliceStable(groups, func(i, j int) bool {
+		return d.placementGroupIndex(groups[i].Name) < d.placementGroupIndex(groups[j].Name)
+	})
+	return groups, nil
+}
+
+// placementGroupIndex returns n for a name produced by poolPlacementGroupName,
+// so that "-10" sorts after "-9". Foreign names sort last.
+func (d *Driver) placementGroupIndex(name string) int {
+	base := d.poolPlacementGroupName(1)
+	if name == base {
+		return 1
+	}
+	if suffix, ok := strings.CutPrefix(name, base+"-"); ok {
+		if n, err := strconv.Atoi(suffix); err == nil {
+			return n
+		}
+	}
+	return math.MaxInt
+}
+
+// deletePlacementGroupIfEmpty deletes the auto-managed placement group this
+// server was placed in once no servers are left in it.
+func (d *Driver) deletePlacementGroupIfEmpty(ctx context.Context) {
+	if d.PlacementGroupID == 0 {
+		return
+	}
+
+	pg, _, err := d.getClient().PlacementGroup.GetByID(ctx, d.PlacementGroupID)
+	if err != nil {
+		log.Warnf("Failed to get placement group %d for cleanup: %v", d.PlacementGroupID, err)
+		return
+	}
+	if pg == nil {
+		return
+	}
+	if len(pg.Servers) > 0 {
+		log.Infof("Placement group %q still has %d servers, keeping it", pg.Name, len(pg.Servers))
+		return
+	}
+
+	if _, err := d.getClient().PlacementGroup.Delete(ctx, pg); err != nil {
+		log.Warnf("Failed to delete empty placement group %d: %v", d.PlacementGroupID, err)
+		return
+	}
+	log.Infof("Deleted empty placement group %q (ID=%d)", pg.Name, pg.ID)
+}
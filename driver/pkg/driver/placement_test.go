@@ -0,0 +1,281 @@
+package driver
+
+import (
+	"encoding/json"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/hetznercloud/hcloud-go/v2/hcloud"
+	"github.com/hetznercloud/hcloud-go/v2/hcloud/schema"
+)
+
+func poolGroup(id int64, name string, servers int) schema.PlacementGroup {
+	pg := schema.PlacementGroup{ID: id, Name: name, Type: "spread", Servers: []int64{}}
+	for i := 0; i < servers; i++ {
+		pg.Servers = append(pg.Servers, int64(1000*id)+int64(i))
+	}
+	return pg
+}
+
+// placementMux serves the given pool groups and records created groups.
+func placementMux(t *testing.T, groups []schema.PlacementGroup, created *[]schema.PlacementGroupCreateRequest) *http.ServeMux {
+	t.Helper()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/placement_groups", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			var req schema.PlacementGroupCreateRequest
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				t.Fatalf("decode create request: %v", err)
+			}
+			*created = append(*created, req)
+			jsonResponse(w, http.StatusCreated, schema.PlacementGroupCreateResponse{
+				PlacementGroup: schema.PlacementGroup{ID: 99, Name: req.Name, Type: req.Type, Servers: []int64{}},
+			})
+			return
+		}
+		if got := r.URL.Query().Get("label_selector"); got != "managed-by=rancher-machine,cluster=prod,pool=cp" {
+			t.Errorf("label_selector = %q", got)
+		}
+		jsonResponse(w, http.StatusOK, schema.PlacementGroupListResponse{PlacementGroups: groups})
+	})
+	return mux
+}
+
+func poolDriver(t *testing.T, mux *http.ServeMux) *Driver {
+	d, _ := newTestDriver(t, mux)
+	d.AutoPlacementGroup = true
+	d.ClusterID = "prod"
+	d.Pool = "cp"
+	return d
+}
+
+func TestFindOrCreatePoolPlacementGroup_UsesGroupWithRoom(t *testing.T) {
+	var created []schema.PlacementGroupCreateRequest
+	d := poolDriver(t, placementMux(t, []schema.PlacementGroup{
+		poolGroup(2, "prod-cp-2", 4),
+		poolGroup(1, "prod-cp", 10),
+	}, &created))
+
+	pg, err := d.findOrCreatePoolPlacementGroup(testCtx(t))
+	if err != nil {
+		t.Fatalf("findOrCreatePoolPlacementGroup() error: %v", err)
+	}
+	if pg.Name != "prod-cp-2" || d.PlacementGroupID != 2 {
+		t.Errorf("got %q (PlacementGroupID=%d), want prod-cp-2 (2)", pg.Name, d.PlacementGroupID)
+	}
+	if len(created) != 0 {
+		t.Errorf("created %d groups, want none", len(created))
+	}
+}
+
+func TestFindOrCreatePoolPlacementGroup_OverflowsToNewGroup(t *testing.T) {
+	var created []schema.PlacementGroupCreateRequest
+	d := poolDriver(t, placementMux(t, []schema.PlacementGroup{
+		poolGroup(1, "prod-cp", 10),
+		poolGroup(2, "prod-cp-2", 10),
+	}, &created))
+
+	pg, err := d.findOrCreatePoolPlacementGroup(testCtx(t))
+	if err != nil {
+		t.Fatalf("findOrCreatePoolPlacementGroup() error: %v", err)
+	}
+	if len(created) != 1 {
+		t.Fatalf("created %d groups, want 1", len(created))
+	}
+	req := created[0]
+	if req.Name != "prod-cp-3" || req.Type != "spread" {
+		t.Errorf("created %q of type %q, want spread group prod-cp-3", req.Name, req.Type)
+	}
+	if req.Labels == nil || (*req.Labels)["cluster"] != "prod" || (*req.Labels)["pool"] != "cp" {
+		t.Errorf("labels = %v, want cluster and pool labels", req.Labels)
+	}
+	if pg.ID != 99 || d.PlacementGroupID != 99 {
+		t.Errorf("got ID=%d PlacementGroupID=%d, want 99", pg.ID, d.PlacementGroupID)
+	}
+}
+
+func TestFindOrCreatePoolPlacementGroup_ReusesFreeName(t *testing.T) {
+	var created []schema.PlacementGroupCreateRequest
+	d := poolDriver(t, placementMux(t, []schema.PlacementGroup{poolGroup(2, "prod-cp-2", 10)}, &created))
+
+	if _, err := d.findOrCreatePoolPlacementGroup(testCtx(t)); err != nil {
+		t.Fatalf("findOrCreatePoolPlacementGroup() error: %v", err)
+	}
+	if len(created) != 1 || created[0].Name != "prod-cp" {
+		t.Errorf("created = %+v, want prod-cp", created)
+	}
+}
+
+func TestCreateServerInPoolPlacementGroup_RetriesWhenGroupFilled(t *testing.T) {
+	var created []schema.PlacementGroupCreateRequest
+	// prod-cp was chosen with room, but a concurrent scale-up filled it
+	mux := placementMux(t, []schema.PlacementGroup{poolGroup(1, "prod-cp", 10)}, &created)
+	mux.HandleFunc("/placement_groups/1", func(w http.ResponseWriter, r *http.Request) {
+		jsonResponse(w, http.StatusOK, schema.PlacementGroupGetResponse{PlacementGroup: poolGroup(1, "prod-cp", 10)})
+	})
+	var groups []int64
+	mux.HandleFunc("/servers", func(w http.ResponseWriter, r *http.Request) {
+		var req schema.ServerCreateRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Fatalf("decode create request: %v", err)
+		}
+		groups = append(groups, req.PlacementGroup)
+		if req.PlacementGroup == 1 {
+			jsonResponse(w, http.StatusUnprocessableEntity, schema.ErrorResponse{
+				Error: schema.Error{Code: "placement_error", Message: "placement group is full"},
+			})
+			return
+		}
+		jsonResponse(w, http.StatusCreated, schema.ServerCreateResponse{
+			Server: standardServer(200, "initializing"),
+			Action: completedAction(50),
+		})
+	})
+	d := poolDriver(t, mux)
+	d.PlacementGroupID = 1
+
+	opts := &hcloud.ServerCreateOpts{
+		Name:           "prod-cp-abc12-xyz34",
+		ServerType:     &hcloud.ServerType{ID: 1},
+		Image:          &hcloud.Image{ID: 1},
+		PlacementGroup: &hcloud.PlacementGroup{ID: 1, Name: "prod-cp"},
+	}
+	result, err := d.createServerInPoolPlacementGroup(testCtx(t), opts)
+	if err != nil {
+		t.Fatalf("createServerInPoolPlacementGroup() error: %v", err)
+	}
+	if result.Server == nil || result.Server.ID != 200 {
+		t.Errorf("result server = %+v, want 200", result.Server)
+	}
+	if len(groups) != 2 || groups[1] != 99 {
+		t.Errorf("create requests used groups %v, want [1 99]", groups)
+	}
+	if len(created) != 1 || created[0].Name != "prod-cp-2" || d.PlacementGroupID != 99 {
+		t.Errorf("created = %+v, PlacementGroupID = %d, want prod-cp-2 (99)", created, d.PlacementGroupID)
+	}
+}
+
+func TestCreate_ServerFailure_DeletesEmptyPlacementGroup(t *testing.T) {
+	var created []schema.PlacementGroupCreateRequest
+	mux := placementMux(t, []schema.PlacementGroup{}, &created)
+	registerStandardEndpoints(mux)
+	mux.HandleFunc("/ssh_keys", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			jsonResponse(w, http.StatusCreated, schema.SSHKeyCreateResponse{
+				SSHKey: schema.SSHKey{ID: 100, Name: "rancher-machine-test-machine"},
+			})
+			return
+		}
+		jsonResponse(w, http.StatusOK, schema.SSHKeyListResponse{SSHKeys: []schema.SSHKey{}})
+	})
+	mux.HandleFunc("/ssh_keys/100", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+	deleted := false
+	mux.HandleFunc("/placement_groups/99", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodDelete {
+			deleted = true
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		jsonResponse(w, http.StatusOK, schema.PlacementGroupGetResponse{PlacementGroup: poolGroup(99, "prod-cp", 0)})
+	})
+	mux.HandleFunc("/servers", func(w http.ResponseWriter, r *http.Request) {
+		jsonResponse(w, http.StatusConflict, schema.ErrorResponse{
+			Error: schema.Error{Code: "conflict", Message: "quota exceeded"},
+		})
+	})
+	d := poolDriver(t, mux)
+	sshDir := t.TempDir()
+	d.BaseDriver.SSHKeyPath = filepath.Join(sshDir, "id_rsa")
+	d.BaseDriver.StorePath = sshDir
+
+	if err := d.Create(); err == nil {
+		t.Fatal("expected error from Create()")
+	}
+	if len(created) != 1 {
+		t.Fatalf("created %d placement groups, want 1", len(created))
+	}
+	if !deleted {
+		t.Error("the empty placement group should be deleted after server creation failure")
+	}
+}
+
+func TestPlacementGroupIndex_SortsNumerically(t *testing.T) {
+	d := &Driver{ClusterID: "prod", Pool: "cp"}
+	if d.placementGroupIndex("prod-cp-10") <= d.placementGroupIndex("prod-cp-9") {
+		t.Error("prod-cp-10 should sort after prod-cp-9")
+	}
+	if d.placementGroupIndex("prod-cp") != 1 {
+		t.Error("prod-cp should be the first group")
+	}
+	if d.placementGroupIndex("other") <= d.placementGroupIndex("prod-cp-10") {
+		t.Error("foreign names should sort last")
+	}
+}
+
+func TestRemove_DeletesEmptyPlacementGroup(t *testing.T) {
+	for _, servers := range []int{0, 3} {
+		deleted := false
+		mux := http.NewServeMux()
+		mux.HandleFunc("/placement_groups/7", func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == http.MethodDelete {
+				deleted = true
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+			jsonResponse(w, http.StatusOK, schema.PlacementGroupGetResponse{PlacementGroup: poolGroup(7, "prod-cp", servers)})
+		})
+		d := poolDriver(t, mux)
+		d.PlacementGroupID = 7
+
+		if err := d.Remove(); err != nil {
+			t.Fatalf("Remove() error: %v", err)
+		}
+		if deleted != (servers == 0) {
+			t.Errorf("%d servers left: deleted = %v", servers, deleted)
+		}
+	}
+}
+
+func TestPreCreateCheck_AutoPlacementGroupConflict(t *testing.T) {
+	d, _ := newTestDriver(t, http.NewServeMux())
+	d.AutoPlacementGroup = true
+	d.PlacementGroup = "spread-1"
+
+	err := d.PreCreateCheck()
+	if err == nil || !strings.Contains(err.Error(), "choose one placement mode") {
+		t.Fatalf("PreCreateCheck() error = %v, want placement mode conflict", err)
+	}
+}
+
+func TestPreCreateCheck_AutoPlacementGroupDerivesPool(t *testing.T) {
+	d, _ := newTestDriver(t, deprecationMux(standardServerType()))
+	d.MachineName = "prod-cp-abc12-xyz34"
+	d.AutoPlacementGroup = true
+
+	if err := d.PreCreateCheck(); err != nil {
+		t.Fatalf("PreCreateCheck() error: %v", err)
+	}
+	if d.Pool != "cp" || d.ClusterID != "prod" {
+		t.Errorf("Pool = %q, ClusterID = %q, want cp and prod", d.Pool, d.ClusterID)
+	}
+	if d.resourceLabels()["pool"] != "cp" {
+		t.Errorf("resourceLabels() = %v, want pool label", d.resourceLabels())
+	}
+}
+
+func TestPoolFromMachineName(t *testing.T) {
+	tests := map[string]string{
+		"prod-cp-abc12-xyz34":         "cp",
+		"my-cluster-workers-abc12-xy": "",
+		"standalone":                  "",
+	}
+	for name, want := range tests {
+		if got := poolFromMachineName(name); got != want {
+			t.Errorf("poolFromMachineName(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
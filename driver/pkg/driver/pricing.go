@@ -0,0 +1,207 @@
+package driver
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"github.com/hetznercloud/hcloud-go/v2/hcloud"
+	"github.com/rancher/machine/libmachine/log"
+)
+
+// costEstimate is a gross (VAT-inclusive) price. Volumes only have a monthly
+// price in the Hetzner API, so they contribute to Monthly only.
+type costEstimate struct {
+	Currency string
+	Hourly   float64
+	Monthly  float64
+}
+
+func (c *costEstimate) add(o costEstimate) {
+	c.Hourly += o.Hourly
+	c.Monthly += o.Monthly
+}
+
+// serverCostInput describes what a server is billed for.
+type serverCostInput struct {
+	serverType *hcloud.ServerType
+	location   string
+	ipv4       bool
+	ipv6       bool
+	volumeGB   int
+}
+
+// estimateCost computes the price of a server, its primary IPs and volumes
+// from the pricing data.
+func estimateCost(pricing hcloud.Pricing, in serverCostInput) (costEstimate, error) {
+	est := costEstimate{Currency: pricing.Currency}
+
+	stPrice, ok := serverTypeLocationPricing(in.serverType, in.location)
+	if !ok {
+		return est, fmt.Errorf("no price for server type %q in %s", in.serverType.Name, in.location)
+	}
+	hourly, monthly, err := parsePrices(stPrice.Hourly.Gross, stPrice.Monthly.Gross)
+	if err != nil {
+		return est, fmt.Errorf("invalid price for server type %q: %w", in.serverType.Name, err)
+	}
+	est.add(costEstimate{Hourly: hourly, Monthly: monthly})
+
+	for ipType, enabled := range map[string]bool{"ipv4": in.ipv4, "ipv6": in.ipv6} {
+		if !enabled {
+			continue
+		}
+		ipCost, err := primaryIPCost(pricing, ipType, in.location)
+		if err != nil {
+			return est, err
+		}
+		est.add(ipCost)
+	}
+
+	if in.volumeGB > 0 {
+		perGB, err := strconv.ParseFloat(pricing.Volume.PerGBMonthly.Gross, 64)
+		if err != nil {
+			return est, fmt.Errorf("invalid volume price %q: %w", pricing.Volume.PerGBMonthly.Gross, err)
+		}
+		est.Monthly += perGB * float64(in.volumeGB)
+	}
+
+	return est, nil
+}
+
+// primaryIPCost returns the price of a primary IP of the given type
+// ("ipv4" or "ipv6") in the location. A type without pricing is free.
+func primaryIPCost(pricing hcloud.Pricing, ipType, location string) (costEstimate, error) {
+	for _, p := range pricing.PrimaryIPs {
+		if p.Type != ipType {
+			continue
+		}
+		for _, lp := range p.Pricings {
+			if lp.Location != location {
+				continue
+			}
+			hourly, monthly, err := parsePrices(lp.Hourly.Gross, lp.Monthly.Gross)
+			if err != nil {
+				return costEstimate{}, fmt.Errorf("invalid %s primary IP price: %w", ipType, err)
+			}
+			return costEstimate{Hourly: hourly, Monthly: monthly}, nil
+		}
+	}
+	return costEstimate{}, nil
+}
+
+func parsePrices(hourly, monthly string) (float64, float64, error) {
+	h, err := strconv.ParseFloat(hourly, 64)
+	if err != nil {
+		return 0, 0, fmt.Errorf("hourly price %q: %w", hourly, err)
+	}
+	m, err := strconv.ParseFloat(monthly, 64)
+	if err != nil {
+		return 0, 0, fmt.Errorf("monthly price %q: %w", monthly, err)
+	}
+	return h, m, nil
+}
+
+// estimateNodeCost returns the price of the node this driver is about to create.
+func (d *Driver) estimateNodeCost(pricing hcloud.Pricing, serverType *hcloud.ServerType) (costEstimate, error) {
+	return estimateCost(pricing, serverCostInput{
+		serverType: serverType,
+		location:   d.ServerLocation,
+		ipv4:       !d.DisablePublicIPv4,
+		ipv6:       !d.DisablePublicIPv6,
+	})
+}
+
+// logCostEstimate logs the expected price of the node. It is informational
+// only, so failures are logged and ignored.
+func (d *Driver) logCostEstimate(ctx context.Context) {
+	serverType, _, err := d.getClient().ServerType.GetByName(ctx, d.ServerType)
+	if err != nil || serverType == nil {
+		log.Warnf("Could not estimate cost: server type %q unavailable: %v", d.ServerType, err)
+		return
+	}
+	pricing, _, err := d.getClient().Pricing.Get(ctx)
+	if err != nil {
+		log.Warnf("Could not estimate cost: failed to get pricing: %v", err)
+		return
+	}
+	est, err := d.estimateNodeCost(pricing, serverType)
+	if err != nil {
+		log.Warnf("Could not estimate cost: %v", err)
+		return
+	}
+	log.Infof("Estimated cost of %q (%s in %s, incl. primary IPs): %.4f %s/hour, %.2f %s/month (gross)",
+		d.MachineName, d.ServerType, d.ServerLocation, est.Hourly, est.Currency, est.Monthly, est.Currency)
+}
+
+// clusterMonthlyCost sums the monthly price of all servers labelled with the
+// cluster ID, including their primary IPs and attached volumes.
+func (d *Driver) clusterMonthlyCost(ctx context.Context, pricing hcloud.Pricing) (costEstimate, int, error) {
+	total := costEstimate{Currency: pricing.Currency}
+
+	servers, err := d.getClient().Server.AllWithOpts(ctx, hcloud.ServerListOpts{
+		ListOpts: hcloud.ListOpts{LabelSelector: "cluster=" + d.ClusterID},
+	})
+	if err != nil {
+		return total, 0, fmt.Errorf("failed to list cluster servers: %w", err)
+	}
+	if len(servers) == 0 {
+		return total, 0, nil
+	}
+
+	volumeGB := make(map[int64]int)
+	volumes, err := d.getClient().Volume.All(ctx)
+	if err != nil {
+		return total, 0, fmt.Errorf("failed to list volumes: %w", err)
+	}
+	for _, v := range volumes {
+		if v.Server != nil {
+			volumeGB[v.Server.ID] += v.Size
+		}
+	}
+
+	for _, s := range servers {
+		if s.ServerType == nil || s.Location == nil {
+			continue
+		}
+		est, err := estimateCost(pricing, serverCostInput{
+			serverType: s.ServerType,
+			location:   s.Location.Name,
+			ipv4:       !s.PublicNet.IPv4.IsUnspecified(),
+			ipv6:       !s.PublicNet.IPv6.IsUnspecified(),
+			volumeGB:   volumeGB[s.ID],
+		})
+		if err != nil {
+			return total, 0, fmt.Errorf("failed to price server %q: %w", s.Name, err)
+		}
+		total.add(est)
+	}
+	return total, len(servers), nil
+}
+
+// checkClusterBudget refuses to create a node that would push the cluster's
+// monthly cost above ClusterMonthlyBudget.
+func (d *Driver) checkClusterBudget(ctx context.Context, serverType *hcloud.ServerType) error {
+	pricing, _, err := d.getClient().Pricing.Get(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get pricing for budget check: %w", err)
+	}
+	node, err := d.estimateNodeCost(pricing, serverType)
+	if err != nil {
+		return fmt.Errorf("failed to estimate node cost for budget check: %w", err)
+	}
+	existing, count, err := d.clusterMonthlyCost(ctx, pricing)
+	if err != nil {
+		return fmt.Errorf("budget check failed: %w", err)
+	}
+
+	total := existing.Monthly + node.Monthly
+	if total > d.ClusterMonthlyBudget {
+		return fmt.Errorf("creating %q would raise the monthly cost of cluster %q to %.2f %s, exceeding the budget of %.2f %s "+
+			"(existing: %.2f across %d servers, this node: %.2f); raise --hetzner-cluster-monthly-budget or choose a smaller server type",
+			d.MachineName, d.ClusterID, total, pricing.Currency, d.ClusterMonthlyBudget, pricing.Currency,
+			existing.Monthly, count, node.Monthly)
+	}
+	log.Infof("Cluster %q monthly cost after creating this node: %.2f of %.2f %s budget",
+		d.ClusterID, total, d.ClusterMonthlyBudget, pricing.Currency)
+	return nil
+}
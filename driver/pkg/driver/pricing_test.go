@@ -0,0 +1,144 @@
+package driver
+
+import (
+	"math"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/hetznercloud/hcloud-go/v2/hcloud/schema"
+)
+
+// testPricing charges 0.60/month for a primary IPv4 and 0.05/GB/month for
+// volumes in fsn1; primary IPv6 is free.
+func testPricing() schema.Pricing {
+	return schema.Pricing{
+		Currency: "EUR",
+		VATRate:  "19.00",
+		// hcloud-go sizes the converted primary IP list by the number of
+		// floating IP types, which the real API always returns.
+		FloatingIPs: []schema.PricingFloatingIPType{{Type: "ipv4"}, {Type: "ipv6"}},
+		PrimaryIPs: []schema.PricingPrimaryIP{{
+			Type: "ipv4",
+			Prices: []schema.PricingPrimaryIPTypePrice{{
+				Location:     "fsn1",
+				PriceHourly:  schema.Price{Net: "0.001", Gross: "0.001"},
+				PriceMonthly: schema.Price{Net: "0.60", Gross: "0.60"},
+			}},
+		}},
+		Volume: schema.PricingVolume{PricePerGBPerMonth: schema.Price{Net: "0.05", Gross: "0.05"}},
+	}
+}
+
+// budgetMux serves cx23 at 4.00/month, the test pricing, two servers of the
+// cluster "prod" and a 10 GB volume attached to the first one.
+func budgetMux(t *testing.T) *http.ServeMux {
+	t.Helper()
+	cx23 := pricedServerType(1, "cx23", 2, 4, 40, "shared", "x86", "4.00")
+	mux := deprecationMux(cx23)
+	mux.HandleFunc("/pricing", func(w http.ResponseWriter, r *http.Request) {
+		jsonResponse(w, http.StatusOK, schema.PricingGetResponse{Pricing: testPricing()})
+	})
+	mux.HandleFunc("/servers", func(w http.ResponseWriter, r *http.Request) {
+		if got := r.URL.Query().Get("label_selector"); got != "cluster=prod" {
+			t.Errorf("label_selector = %q, want cluster=prod", got)
+		}
+		var servers []schema.Server
+		for _, id := range []int64{1, 2} {
+			s := standardServer(id, "running")
+			s.ServerType = cx23
+			servers = append(servers, s)
+		}
+		jsonResponse(w, http.StatusOK, schema.ServerListResponse{Servers: servers})
+	})
+	mux.HandleFunc("/volumes", func(w http.ResponseWriter, r *http.Request) {
+		jsonResponse(w, http.StatusOK, schema.VolumeListResponse{
+			Volumes: []schema.Volume{{ID: 10, Name: "data", Size: 10, Server: ptr(int64(1)), Location: standardLocation()}},
+		})
+	})
+	return mux
+}
+
+func TestEstimateNodeCost(t *testing.T) {
+	d, _ := newTestDriver(t, budgetMux(t))
+	ctx := testCtx(t)
+
+	pricing, _, err := d.getClient().Pricing.Get(ctx)
+	if err != nil {
+		t.Fatalf("Pricing.Get() error: %v", err)
+	}
+	serverType, _, err := d.getClient().ServerType.GetByName(ctx, "cx23")
+	if err != nil {
+		t.Fatalf("ServerType.GetByName() error: %v", err)
+	}
+
+	est, err := d.estimateNodeCost(pricing, serverType)
+	if err != nil {
+		t.Fatalf("estimateNodeCost() error: %v", err)
+	}
+	if math.Abs(est.Monthly-4.60) > 1e-9 || math.Abs(est.Hourly-0.011) > 1e-9 || est.Currency != "EUR" {
+		t.Errorf("estimate = %+v, want 4.60 EUR/month, 0.011 EUR/hour", est)
+	}
+
+	d.DisablePublicIPv4 = true
+	est, err = d.estimateNodeCost(pricing, serverType)
+	if err != nil {
+		t.Fatalf("estimateNodeCost() error: %v", err)
+	}
+	if math.Abs(est.Monthly-4.00) > 1e-9 {
+		t.Errorf("Monthly without IPv4 = %v, want 4.00", est.Monthly)
+	}
+}
+
+func TestEstimateNodeCost_NoPriceInLocation(t *testing.T) {
+	d, _ := newTestDriver(t, budgetMux(t))
+	ctx := testCtx(t)
+	d.ServerLocation = "hel1"
+
+	pricing, _, _ := d.getClient().Pricing.Get(ctx)
+	serverType, _, _ := d.getClient().ServerType.GetByName(ctx, "cx23")
+	if _, err := d.estimateNodeCost(pricing, serverType); err == nil {
+		t.Fatal("expected error for server type without price in hel1")
+	}
+}
+
+func TestPreCreateCheck_ClusterBudget(t *testing.T) {
+	// Existing: 2 x (4.00 + 0.60 IPv4) + 10 GB x 0.05 = 9.70; new node: 4.60
+	tests := []struct {
+		budget  float64
+		wantErr bool
+	}{
+		{budget: 14.31, wantErr: false},
+		{budget: 14.29, wantErr: true},
+	}
+	for _, tt := range tests {
+		d, _ := newTestDriver(t, budgetMux(t))
+		d.ClusterID = "prod"
+		d.ClusterMonthlyBudget = tt.budget
+
+		err := d.PreCreateCheck()
+		if tt.wantErr {
+			if err == nil {
+				t.Fatalf("budget %.2f: expected error", tt.budget)
+			}
+			if !strings.Contains(err.Error(), "14.30 EUR") || !strings.Contains(err.Error(), "across 2 servers") {
+				t.Errorf("budget %.2f: error = %q, want total and server count", tt.budget, err)
+			}
+		} else if err != nil {
+			t.Fatalf("budget %.2f: PreCreateCheck() error: %v", tt.budget, err)
+		}
+	}
+}
+
+func TestPreCreateCheck_ClusterBudget_DerivesClusterID(t *testing.T) {
+	d, _ := newTestDriver(t, budgetMux(t))
+	d.MachineName = "prod-pool1-abc12-xyz34"
+	d.ClusterMonthlyBudget = 100
+
+	if err := d.PreCreateCheck(); err != nil {
+		t.Fatalf("PreCreateCheck() error: %v", err)
+	}
+	if d.ClusterID != "prod" {
+		t.Errorf("ClusterID = %q, want prod", d.ClusterID)
+	}
+}
@@ -0,0 +1,177 @@
+package driver
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/hetznercloud/hcloud-go/v2/hcloud"
+	"github.com/rancher/machine/libmachine/log"
+)
+
+// Resources checked by the quota pre-flight.
+const (
+	quotaServers               = "servers"
+	quotaCores                 = "cores"
+	quotaPrimaryIPs            = "primary-ips"
+	quotaFirewalls             = "firewalls"
+	quotaPlacementGroupServers = "placement-group-servers"
+)
+
+// quotaResourceNames are the resources --hetzner-quota-limits accepts.
+var quotaResourceNames = map[string]bool{
+	quotaServers:               true,
+	quotaCores:                 true,
+	quotaPrimaryIPs:            true,
+	quotaFirewalls:             true,
+	quotaPlacementGroupServers: true,
+}
+
+// parseQuotaLimits parses "<resource>=<limit>" entries. A limit of 0
+// disables the check for that resource.
+func parseQuotaLimits(entries []string) (map[string]int, error) {
+	if len(entries) == 0 {
+		return nil, nil
+	}
+	m := make(map[string]int, len(entries))
+	for _, entry := range entries {
+		resource, value, ok := strings.Cut(entry, "=")
+		resource = strings.TrimSpace(resource)
+		if !ok {
+			return nil, fmt.Errorf("invalid quota limit %q: expected <resource>=<limit>", entry)
+		}
+		if !quotaResourceNames[resource] {
+			return nil, fmt.Errorf("invalid quota limit %q: resource must be one of %s", entry, strings.Join(quotaResources(), ", "))
+		}
+		limit, err := strconv.Atoi(strings.TrimSpace(value))
+		if err != nil || limit < 0 {
+			return nil, fmt.Errorf("invalid quota limit %q: limit must be a non-negative integer", entry)
+		}
+		m[resource] = limit
+	}
+	return m, nil
+}
+
+func quotaResources() []string {
+	resources := make([]string, 0, len(quotaResourceNames))
+	for r := range quotaResourceNames {
+		resources = append(resources, r)
+	}
+	sort.Strings(resources)
+	return resources
+}
+
+// quotaLimit returns the limit set for the resource with --hetzner-quota-limits,
+// or 0 (not checked). The API does not expose a project's limits and they are
+// raised per project, so project limits are only checked when configured. The
+// spread placement group size is the same everywhere and always checked.
+func (d *Driver) quotaLimit(resource string) int {
+	if limit, ok := d.QuotaLimits[resource]; ok {
+		return limit
+	}
+	if resource == quotaPlacementGroupServers {
+		return placementGroupMaxServers
+	}
+	return 0
+}
+
+// checkQuota fails when creating the server would exceed the limit.
+func (d *Driver) checkQuota(resource string, used, needed int) error {
+	limit := d.quotaLimit(resource)
+	if limit == 0 || needed == 0 || used+needed <= limit {
+		return nil
+	}
+	return fmt.Errorf("project limit for %s would be exceeded: %d in use, %d needed, limit %d; "+
+		"free up %s, request a limit increase in the Hetzner Console, "+
+		"or raise --hetzner-quota-limits %s=<limit> if the project already has a higher limit",
+		resource, used, needed, limit, resource, resource)
+}
+
+// checkProjectQuotas counts the project's current usage of everything Create
+// is about to allocate and fails before any resource is created if a limit
+// would be exceeded. Only resources with a limit (see quotaLimit) are counted.
+// Usage that cannot be listed is skipped with a warning, since the API error
+// would surface again during Create anyway.
+func (d *Driver) checkProjectQuotas(ctx context.Context, serverType *hcloud.ServerType) error {
+	if d.quotaLimit(quotaServers) > 0 || d.quotaLimit(quotaCores) > 0 {
+		if err := d.checkServerQuotas(ctx, serverType); err != nil {
+			return err
+		}
+	}
+
+	primaryIPs := 0
+	if !d.DisablePublicIPv4 {
+		primaryIPs++
+	}
+	if !d.DisablePublicIPv6 {
+		primaryIPs++
+	}
+	if primaryIPs > 0 && d.quotaLimit(quotaPrimaryIPs) > 0 {
+		ips, err := d.getClient().PrimaryIP.All(ctx)
+		if err != nil {
+			log.Warnf("Warning: skipping primary IP quota check: failed to list primary IPs: %v", err)
+		} else if err := d.checkQuota(quotaPrimaryIPs, len(ips), primaryIPs); err != nil {
+			return err
+		}
+	}
+
+	if d.CreateFirewall && d.quotaLimit(quotaFirewalls) > 0 {
+		if err := d.checkFirewallQuota(ctx); err != nil {
+			return err
+		}
+	}
+
+	if d.PlacementGroup != "" {
+		pg, err := d.resolvePlacementGroup(ctx, d.PlacementGroup)
+		if err != nil {
+			log.Warnf("Warning: skipping placement group quota check: %v", err)
+		} else if err := d.checkQuota(quotaPlacementGroupServers, len(pg.Servers), 1); err != nil {
+			return fmt.Errorf("placement group %q is full: %w", pg.Name, err)
+		}
+	}
+
+	return nil
+}
+
+// checkServerQuotas counts the project's servers and their cores.
+func (d *Driver) checkServerQuotas(ctx context.Context, serverType *hcloud.ServerType) error {
+	servers, err := d.getClient().Server.All(ctx)
+	if err != nil {
+		log.Warnf("Warning: skipping server and core quota check: failed to list servers: %v", err)
+	} else {
+		cores := 0
+		for _, s := range servers {
+			if s.ServerType != nil {
+				cores += s.ServerType.Cores
+			}
+		}
+		if err := d.checkQuota(quotaServers, len(servers), 1); err != nil {
+			return err
+		}
+		if err := d.checkQuota(quotaCores, cores, serverType.Cores); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkFirewallQuota counts firewalls only when the shared cluster firewall
+// does not exist yet and would be created.
+func (d *Driver) checkFirewallQuota(ctx context.Context) error {
+	fw, err := d.findSharedFirewall(ctx)
+	if err != nil {
+		log.Warnf("Warning: skipping firewall quota check: %v", err)
+		return nil
+	}
+	if fw != nil {
+		return nil
+	}
+	firewalls, err := d.getClient().Firewall.All(ctx)
+	if err != nil {
+		log.Warnf("Warning: skipping firewall quota check: failed to list firewalls: %v", err)
+		return nil
+	}
+	return d.checkQuota(quotaFirewalls, len(firewalls), 1)
+}
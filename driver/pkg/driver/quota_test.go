@@ -0,0 +1,167 @@
+package driver
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/hetznercloud/hcloud-go/v2/hcloud/schema"
+)
+
+// quotaMux serves the standard server type, location and image plus the
+// given number of existing servers and primary IPs.
+func quotaMux(servers, primaryIPs int) *http.ServeMux {
+	mux := deprecationMux(standardServerType())
+	mux.HandleFunc("/servers", func(w http.ResponseWriter, r *http.Request) {
+		resp := make([]schema.Server, servers)
+		for i := range resp {
+			resp[i] = standardServer(int64(i+1), "running")
+		}
+		jsonResponse(w, http.StatusOK, schema.ServerListResponse{Servers: resp})
+	})
+	mux.HandleFunc("/primary_ips", func(w http.ResponseWriter, r *http.Request) {
+		resp := make([]schema.PrimaryIP, primaryIPs)
+		for i := range resp {
+			resp[i] = schema.PrimaryIP{ID: int64(i + 1), Type: "ipv4"}
+		}
+		jsonResponse(w, http.StatusOK, schema.PrimaryIPListResponse{PrimaryIPs: resp})
+	})
+	return mux
+}
+
+func TestPreCreateCheck_Quota_ServersExhausted(t *testing.T) {
+	d, _ := newTestDriver(t, quotaMux(10, 0))
+	d.QuotaLimits = map[string]int{quotaServers: 10}
+
+	err := d.PreCreateCheck()
+	if err == nil {
+		t.Fatal("expected error when the server limit is reached")
+	}
+	if !strings.Contains(err.Error(), "project limit for servers") || !strings.Contains(err.Error(), "servers=<limit>") {
+		t.Errorf("error = %q, want resource and override hint", err)
+	}
+}
+
+func TestPreCreateCheck_Quota_ConfiguredLimitNotReached(t *testing.T) {
+	d, _ := newTestDriver(t, quotaMux(10, 0))
+	d.QuotaLimits = map[string]int{quotaServers: 25}
+
+	if err := d.PreCreateCheck(); err != nil {
+		t.Fatalf("PreCreateCheck() error: %v", err)
+	}
+}
+
+func TestPreCreateCheck_Quota_OnlyConfiguredLimitsChecked(t *testing.T) {
+	// Projects often have raised limits the API does not report; without
+	// --hetzner-quota-limits nothing is counted, however much is in use.
+	mux := deprecationMux(standardServerType())
+	mux.HandleFunc("/servers", func(w http.ResponseWriter, r *http.Request) {
+		t.Error("servers listed without a configured server or core limit")
+	})
+	mux.HandleFunc("/primary_ips", func(w http.ResponseWriter, r *http.Request) {
+		t.Error("primary IPs listed without a configured primary IP limit")
+	})
+	d, _ := newTestDriver(t, mux)
+
+	if err := d.PreCreateCheck(); err != nil {
+		t.Fatalf("PreCreateCheck() error: %v", err)
+	}
+}
+
+func TestPreCreateCheck_Quota_Cores(t *testing.T) {
+	tests := []struct {
+		servers int
+		wantErr bool
+	}{
+		{servers: 3, wantErr: false}, // 3*2 + 2 = 8
+		{servers: 4, wantErr: true},  // 4*2 + 2 = 10
+	}
+	for _, tt := range tests {
+		d, _ := newTestDriver(t, quotaMux(tt.servers, 0))
+		d.QuotaLimits = map[string]int{quotaCores: 8}
+
+		err := d.PreCreateCheck()
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%d servers: PreCreateCheck() error = %v, wantErr %v", tt.servers, err, tt.wantErr)
+		}
+	}
+}
+
+func TestPreCreateCheck_Quota_PrimaryIPs(t *testing.T) {
+	d, _ := newTestDriver(t, quotaMux(1, 19))
+	d.QuotaLimits = map[string]int{quotaPrimaryIPs: 20}
+	if err := d.PreCreateCheck(); err == nil || !strings.Contains(err.Error(), "primary-ips") {
+		t.Fatalf("PreCreateCheck() error = %v, want primary IP limit error", err)
+	}
+
+	// Only one primary IP is needed without IPv6
+	d, _ = newTestDriver(t, quotaMux(1, 19))
+	d.QuotaLimits = map[string]int{quotaPrimaryIPs: 20}
+	d.DisablePublicIPv6 = true
+	if err := d.PreCreateCheck(); err != nil {
+		t.Fatalf("PreCreateCheck() IPv4-only error: %v", err)
+	}
+}
+
+func TestPreCreateCheck_Quota_DisabledLimit(t *testing.T) {
+	d, _ := newTestDriver(t, quotaMux(50, 0))
+	d.QuotaLimits = map[string]int{quotaServers: 0, quotaCores: 0}
+
+	if err := d.PreCreateCheck(); err != nil {
+		t.Fatalf("PreCreateCheck() error: %v", err)
+	}
+}
+
+func TestPreCreateCheck_Quota_PlacementGroupFull(t *testing.T) {
+	mux := quotaMux(1, 0)
+	mux.HandleFunc("/placement_groups", func(w http.ResponseWriter, r *http.Request) {
+		jsonResponse(w, http.StatusOK, schema.PlacementGroupListResponse{
+			PlacementGroups: []schema.PlacementGroup{{
+				ID: 5, Name: "spread-1", Type: "spread",
+				Servers: []int64{1, 2, 3, 4, 5, 6, 7, 8, 9, 10},
+			}},
+		})
+	})
+	d, _ := newTestDriver(t, mux)
+	d.PlacementGroup = "spread-1"
+
+	err := d.PreCreateCheck()
+	if err == nil || !strings.Contains(err.Error(), `placement group "spread-1" is full`) {
+		t.Fatalf("PreCreateCheck() error = %v, want placement group full error", err)
+	}
+}
+
+func TestPreCreateCheck_Quota_FirewallOnlyCountedWhenCreated(t *testing.T) {
+	firewalls := make([]schema.Firewall, 3)
+	for i := range firewalls {
+		firewalls[i] = schema.Firewall{ID: int64(i + 1), Name: "fw"}
+	}
+	shared := schema.Firewall{ID: 9, Name: "rancher-prod", Labels: map[string]string{"cluster": "prod"}}
+
+	for _, exists := range []bool{false, true} {
+		mux := quotaMux(1, 0)
+		mux.HandleFunc("/firewalls", func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Query().Get("label_selector") != "" {
+				var resp []schema.Firewall
+				if exists {
+					resp = append(resp, shared)
+				}
+				jsonResponse(w, http.StatusOK, schema.FirewallListResponse{Firewalls: resp})
+				return
+			}
+			jsonResponse(w, http.StatusOK, schema.FirewallListResponse{Firewalls: firewalls})
+		})
+		d, _ := newTestDriver(t, mux)
+		d.CreateFirewall = true
+		d.ClusterID = "prod"
+		d.QuotaLimits = map[string]int{quotaFirewalls: 3}
+
+		err := d.PreCreateCheck()
+		if exists && err != nil {
+			t.Errorf("shared firewall exists: PreCreateCheck() error: %v", err)
+		}
+		if !exists && (err == nil || !strings.Contains(err.Error(), "firewalls")) {
+			t.Errorf("shared firewall missing: PreCreateCheck() error = %v, want firewall limit error", err)
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package driver
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"text/template"
+
+	"github.com/rancher/machine/libmachine/log"
+)
+
+// reverseDNSNameRe matches a fully qualified host name as accepted for PTR
+// records: dot-separated labels of letters, digits and inner hyphens.
+var reverseDNSNameRe = regexp.MustCompile(`^([a-zA-Z0-9]([a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?\.)+[a-zA-Z]{2,63}$`)
+
+// parseReverseDNSTemplate parses a --hetzner-reverse-dns template.
+func parseReverseDNSTemplate(text string) (*template.Template, error) {
+	tmpl, err := template.New("reverse-dns").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid hetzner-reverse-dns template: %w", err)
+	}
+	return tmpl, nil
+}
+
+// reverseDNSName renders the reverse DNS template for this machine.
+func (d *Driver) reverseDNSName() (string, error) {
+	tmpl, err := parseReverseDNSTemplate(d.ReverseDNSTemplate)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, d.templateData()); err != nil {
+		return "", fmt.Errorf("failed to render hetzner-reverse-dns template: %w", err)
+	}
+	name := strings.TrimSuffix(buf.String(), ".")
+	if !reverseDNSNameRe.MatchString(name) || len(name) > 253 {
+		return "", fmt.Errorf("hetzner-reverse-dns template renders %q, which is not a valid host name", name)
+	}
+	return name, nil
+}
+
+// setReverseDNS points the PTR records of the server's public IPv4 and IPv6
+// addresses at the rendered reverse DNS name. Failures are only logged: the
+// server works without custom PTR records.
+func (d *Driver) setReverseDNS(ctx context.Context) {
+	name, err := d.reverseDNSName()
+	if err != nil {
+		log.Warnf("Warning: not setting reverse DNS: %v", err)
+		return
+	}
+	server, _, err := d.getClient().Server.GetByID(ctx, d.ServerID)
+	if err != nil || server == nil {
+		log.Warnf("Warning: not setting reverse DNS: failed to get server %d: %v", d.ServerID, err)
+		return
+	}
+
+	var ips []string
+	if ip := server.PublicNet.IPv4.IP; len(ip) > 0 && !ip.IsUnspecified() {
+		ips = append(ips, ip.String())
+	}
+	if ip := server.PublicNet.IPv6.IP; len(ip) > 0 && !ip.IsUnspecified() {
+		ips = append(ips, ipv6HostAddress(ip).String())
+	}
+	for _, ip := range ips {
+		action, _, err := d.getClient().Server.ChangeDNSPtr(ctx, server, ip, &name)
+		if err == nil {
+			err = d.waitForAction(ctx, action)
+		}
+		if err != nil {
+			log.Warnf("Warning: failed to set reverse DNS of %s to %q: %v", ip, name, err)
+			continue
+		}
+		log.Infof("Reverse DNS of %s set to %q", ip, name)
+	}
+}
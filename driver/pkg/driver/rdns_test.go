@@ -0,0 +1,83 @@
+package driver
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/hetznercloud/hcloud-go/v2/hcloud/schema"
+)
+
+func TestReverseDNSName(t *testing.T) {
+	d := NewDriver("prod-cp-abc12-xyz34", t.TempDir(), "test")
+	d.ClusterID = "prod"
+	d.Pool = "cp"
+
+	tests := map[string]string{
+		"{{.MachineName}}.nodes.example.com":            "prod-cp-abc12-xyz34.nodes.example.com",
+		"{{.Pool}}-{{.MachineName}}.{{.ClusterID}}.io.": "cp-prod-cp-abc12-xyz34.prod.io",
+	}
+	for tmpl, want := range tests {
+		d.ReverseDNSTemplate = tmpl
+		got, err := d.reverseDNSName()
+		if err != nil || got != want {
+			t.Errorf("reverseDNSName(%q) = %q, %v, want %q", tmpl, got, err, want)
+		}
+	}
+
+	for _, tmpl := range []string{"{{.Machine}}.example.com", "{{.MachineName}}", "{{.MachineName}}_x.example.com"} {
+		d.ReverseDNSTemplate = tmpl
+		if _, err := d.reverseDNSName(); err == nil {
+			t.Errorf("reverseDNSName(%q) expected error", tmpl)
+		}
+	}
+}
+
+func TestSetReverseDNS(t *testing.T) {
+	var changed []schema.ServerActionChangeDNSPtrRequest
+	mux := http.NewServeMux()
+	registerActionPoller(mux, 1)
+	registerServers(mux, nil, standardServer(100, "running"))
+	mux.HandleFunc("/servers/100/actions/change_dns_ptr", func(w http.ResponseWriter, r *http.Request) {
+		var req schema.ServerActionChangeDNSPtrRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Fatalf("decode change_dns_ptr request: %v", err)
+		}
+		changed = append(changed, req)
+		if req.IP == "1.2.3.4" {
+			// A failure for one address must not stop the other
+			jsonResponse(w, http.StatusUnprocessableEntity, schema.ErrorResponse{
+				Error: schema.Error{Code: "invalid_input", Message: "dns_ptr does not resolve"},
+			})
+			return
+		}
+		jsonResponse(w, http.StatusCreated, schema.ServerActionChangeDNSPtrResponse{Action: completedAction(1)})
+	})
+	d, _ := newTestDriver(t, mux)
+	d.MachineName = "node-1"
+	d.ServerID = 100
+	d.ReverseDNSTemplate = "{{.MachineName}}.nodes.example.com"
+
+	d.setReverseDNS(testCtx(t))
+
+	if len(changed) != 2 {
+		t.Fatalf("changed = %+v, want IPv4 and IPv6", changed)
+	}
+	if changed[0].IP != "1.2.3.4" || changed[1].IP != "2001:db8::1" {
+		t.Errorf("IPs = %q, %q, want 1.2.3.4 and 2001:db8::1", changed[0].IP, changed[1].IP)
+	}
+	if changed[1].DNSPtr == nil || *changed[1].DNSPtr != "node-1.nodes.example.com" {
+		t.Errorf("DNSPtr = %v, want node-1.nodes.example.com", changed[1].DNSPtr)
+	}
+}
+
+func TestPreCreateCheck_InvalidReverseDNSTemplate(t *testing.T) {
+	d, _ := newTestDriver(t, deprecationMux(standardServerType()))
+	d.ReverseDNSTemplate = "{{.Hostname}}.example.com"
+
+	err := d.PreCreateCheck()
+	if err == nil || !strings.Contains(err.Error(), "hetzner-reverse-dns") {
+		t.Fatalf("PreCreateCheck() error = %v, want reverse DNS template error", err)
+	}
+}
@@ -0,0 +1,219 @@
+package driver
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/hetznercloud/hcloud-go/v2/hcloud"
+	"github.com/rancher/machine/libmachine/log"
+)
+
+const (
+	// resizeTimeout bounds the whole resize operation (shutdown, change type,
+	// power on). Changing the type with a disk upgrade can take several minutes.
+	resizeTimeout = 15 * time.Minute
+
+	// shutdownGracePeriod is how long we wait for a graceful ACPI shutdown
+	// before falling back to a hard power off.
+	shutdownGracePeriod = 2 * time.Minute
+)
+
+// serverStatusPollInterval is the delay between server status polls while
+// waiting for a power state transition. Overridden in tests.
+var serverStatusPollInterval = 2 * time.Second
+
+// Resize changes the server type of an existing machine in place. The server
+// is shut down (if running), its type is changed via Server.ChangeType and it
+// is powered on again. When upgradeDisk is false the disk keeps its current
+// size, which allows downgrading back to a smaller type later.
+//
+// On failure the server's previous power state is restored on a best-effort
+// basis. On success d.ServerType is updated so the caller can persist it.
+func (d *Driver) Resize(serverTypeName string, upgradeDisk bool) error {
+	if d.ServerID == 0 {
+		return fmt.Errorf("cannot resize: machine has no server ID")
+	}
+	if serverTypeName == "" {
+		return fmt.Errorf("cannot resize: target server type is required")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), resizeTimeout)
+	defer cancel()
+
+	server, _, err := d.getClient().Server.GetByID(ctx, d.ServerID)
+	if err != nil {
+		return fmt.Errorf("failed to get server %d: %w", d.ServerID, err)
+	}
+	if server == nil {
+		return fmt.Errorf("server %d not found", d.ServerID)
+	}
+
+	if server.ServerType != nil && server.ServerType.Name == serverTypeName {
+		log.Infof("Server %d already has type %q, nothing to do", d.ServerID, serverTypeName)
+		d.ServerType = serverTypeName
+		return nil
+	}
+
+	target, _, err := d.getClient().ServerType.GetByName(ctx, serverTypeName)
+	if err != nil {
+		return fmt.Errorf("invalid server type %q: %w", serverTypeName, err)
+	}
+	if target == nil {
+		return fmt.Errorf("server type %q not found", serverTypeName)
+	}
+	if err := validateResizeArchitecture(server, target); err != nil {
+		return err
+	}
+
+	wasRunning := server.Status != hcloud.ServerStatusOff
+	log.Infof("Resizing server %d from %q to %q (upgrade disk: %t)...",
+		d.ServerID, serverTypeNameOf(server), target.Name, upgradeDisk)
+
+	if wasRunning {
+		if err := d.shutdownAndWait(ctx, server); err != nil {
+			d.restorePowerState(wasRunning)
+			return fmt.Errorf("failed to stop server %d for resize: %w", d.ServerID, err)
+		}
+	}
+
+	action, _, err := d.getClient().Server.ChangeType(ctx, server, hcloud.ServerChangeTypeOpts{
+		ServerType:  target,
+		UpgradeDisk: upgradeDisk,
+	})
+	if err != nil {
+		d.restorePowerState(wasRunning)
+		return fmt.Errorf("failed to change type of server %d to %q: %w", d.ServerID, target.Name, err)
+	}
+	if err := d.waitForAction(ctx, action); err != nil {
+		d.restorePowerState(wasRunning)
+		return fmt.Errorf("change type of server %d to %q failed: %w", d.ServerID, target.Name, err)
+	}
+
+	// The type change itself succeeded, so record it before powering on —
+	// a failed power on does not undo the new type.
+	d.ServerType = target.Name
+
+	if wasRunning {
+		action, _, err := d.getClient().Server.Poweron(ctx, server)
+		if err != nil {
+			return fmt.Errorf("server %d resized to %q but failed to power on: %w", d.ServerID, target.Name, err)
+		}
+		if err := d.waitForAction(ctx, action); err != nil {
+			return fmt.Errorf("server %d resized to %q but power on failed: %w", d.ServerID, target.Name, err)
+		}
+	}
+
+	log.Infof("Server %d resized to %q", d.ServerID, target.Name)
+	return nil
+}
+
+// validateResizeArchitecture ensures the target server type can run the
+// server's image. The image architecture is authoritative; when the image is
+// no longer available (deleted snapshot) the current server type's
+// architecture is used instead.
+func validateResizeArchitecture(server *hcloud.Server, target *hcloud.ServerType) error {
+	var arch hcloud.Architecture
+	switch {
+	case server.Image != nil && server.Image.Architecture != "":
+		arch = server.Image.Architecture
+	case server.ServerType != nil:
+		arch = server.ServerType.Architecture
+	}
+	if arch == "" || arch == target.Architecture {
+		return nil
+	}
+	return fmt.Errorf("cannot resize server %d to %q: server type architecture %s does not match image architecture %s",
+		server.ID, target.Name, target.Architecture, arch)
+}
+
+// shutdownAndWait gracefully shuts the server down and waits until it reports
+// status "off". If the guest does not react within shutdownGracePeriod the
+// server is powered off forcefully.
+func (d *Driver) shutdownAndWait(ctx context.Context, server *hcloud.Server) error {
+	action, _, err := d.getClient().Server.Shutdown(ctx, server)
+	if err != nil {
+		return fmt.Errorf("failed to shutdown server: %w", err)
+	}
+	if err := d.waitForAction(ctx, action); err != nil {
+		return err
+	}
+
+	graceCtx, graceCancel := context.WithTimeout(ctx, shutdownGracePeriod)
+	defer graceCancel()
+	if err := d.waitForServerStatus(graceCtx, hcloud.ServerStatusOff); err == nil {
+		return nil
+	}
+
+	log.Warnf("Server %d did not shut down within %v, powering off", d.ServerID, shutdownGracePeriod)
+	action, _, err = d.getClient().Server.Poweroff(ctx, server)
+	if err != nil {
+		return fmt.Errorf("failed to power off server: %w", err)
+	}
+	if err := d.waitForAction(ctx, action); err != nil {
+		return err
+	}
+	return d.waitForServerStatus(ctx, hcloud.ServerStatusOff)
+}
+
+// waitForServerStatus polls the server until it reaches the given status.
+func (d *Driver) waitForServerStatus(ctx context.Context, status hcloud.ServerStatus) error {
+	for {
+		server, _, err := d.getClient().Server.GetByID(ctx, d.ServerID)
+		if err != nil {
+			return fmt.Errorf("failed to get server %d: %w", d.ServerID, err)
+		}
+		if server == nil {
+			return fmt.Errorf("server %d not found", d.ServerID)
+		}
+		if server.Status == status {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("timed out waiting for server %d to reach status %q (current: %q): %w",
+				d.ServerID, status, server.Status, ctx.Err())
+		case <-time.After(serverStatusPollInterval):
+		}
+	}
+}
+
+// restorePowerState powers the server back on after a failed resize if it
+// was running before. Uses a fresh context since the resize context may
+// already be exhausted.
+func (d *Driver) restorePowerState(wasRunning bool) {
+	if !wasRunning {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+
+	server, _, err := d.getClient().Server.GetByID(ctx, d.ServerID)
+	if err != nil || server == nil {
+		log.Warnf("Failed to get server %d to restore power state: %v", d.ServerID, err)
+		return
+	}
+	if server.Status != hcloud.ServerStatusOff {
+		return
+	}
+
+	action, _, err := d.getClient().Server.Poweron(ctx, server)
+	if err != nil {
+		log.Warnf("Failed to power server %d back on after failed resize: %v", d.ServerID, err)
+		return
+	}
+	if err := d.waitForAction(ctx, action); err != nil {
+		log.Warnf("Power on of server %d after failed resize failed: %v", d.ServerID, err)
+		return
+	}
+	log.Infof("Restored server %d to running state after failed resize", d.ServerID)
+}
+
+func serverTypeNameOf(server *hcloud.Server) string {
+	if server.ServerType == nil {
+		return ""
+	}
+	return server.ServerType.Name
+}
@@ -0,0 +1,248 @@
+package driver
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/hetznercloud/hcloud-go/v2/hcloud/schema"
+)
+
+// armServerType returns a minimal ARM server type response for cax21.
+func armServerType() schema.ServerType {
+	return schema.ServerType{
+		ID:           3,
+		Name:         "cax21",
+		Description:  "CAX21",
+		Cores:        4,
+		Memory:       8,
+		Disk:         80,
+		Architecture: "arm",
+	}
+}
+
+// largerServerType returns a minimal x86 server type response for cx33.
+func largerServerType() schema.ServerType {
+	return schema.ServerType{
+		ID:           2,
+		Name:         "cx33",
+		Description:  "CX33",
+		Cores:        4,
+		Memory:       8,
+		Disk:         80,
+		Architecture: "x86",
+	}
+}
+
+// resizeMux registers the endpoints used by Resize. The server status is
+// tracked in *status so shutdown/poweron transitions are observable.
+func resizeMux(t *testing.T, status *string, changeTypeStatus int, calls *[]string) *http.ServeMux {
+	t.Helper()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/servers/123", func(w http.ResponseWriter, r *http.Request) {
+		jsonResponse(w, http.StatusOK, schema.ServerGetResponse{
+			Server: standardServer(123, *status),
+		})
+	})
+	mux.HandleFunc("/server_types", func(w http.ResponseWriter, r *http.Request) {
+		types := map[string]schema.ServerType{
+			"cx23":  standardServerType(),
+			"cx33":  largerServerType(),
+			"cax21": armServerType(),
+		}
+		var resp []schema.ServerType
+		if st, ok := types[r.URL.Query().Get("name")]; ok {
+			resp = append(resp, st)
+		}
+		jsonResponse(w, http.StatusOK, schema.ServerTypeListResponse{ServerTypes: resp})
+	})
+	mux.HandleFunc("/servers/123/actions/shutdown", func(w http.ResponseWriter, r *http.Request) {
+		*calls = append(*calls, "shutdown")
+		*status = "off"
+		jsonResponse(w, http.StatusOK, schema.ServerActionShutdownResponse{Action: completedAction(1)})
+	})
+	mux.HandleFunc("/servers/123/actions/change_type", func(w http.ResponseWriter, r *http.Request) {
+		var req schema.ServerActionChangeTypeRequest
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		*calls = append(*calls, fmt.Sprintf("change_type:%d", req.ServerType.ID))
+		if changeTypeStatus != http.StatusCreated {
+			jsonResponse(w, changeTypeStatus, schema.ErrorResponse{
+				Error: schema.Error{Code: "resource_unavailable", Message: "server type unavailable"},
+			})
+			return
+		}
+		jsonResponse(w, http.StatusCreated, schema.ServerActionChangeTypeResponse{Action: completedAction(1)})
+	})
+	mux.HandleFunc("/servers/123/actions/poweron", func(w http.ResponseWriter, r *http.Request) {
+		*calls = append(*calls, "poweron")
+		*status = "running"
+		jsonResponse(w, http.StatusOK, schema.ServerActionPoweronResponse{Action: completedAction(1)})
+	})
+	registerActionPoller(mux, 1)
+	return mux
+}
+
+func TestResize_RunningServer(t *testing.T) {
+	status := "running"
+	var calls []string
+	d, _ := newTestDriver(t, resizeMux(t, &status, http.StatusCreated, &calls))
+	d.ServerID = 123
+
+	if err := d.Resize("cx33", false); err != nil {
+		t.Fatalf("Resize() error: %v", err)
+	}
+
+	want := []string{"shutdown", "change_type:2", "poweron"}
+	if strings.Join(calls, ",") != strings.Join(want, ",") {
+		t.Errorf("calls = %v, want %v", calls, want)
+	}
+	if d.ServerType != "cx33" {
+		t.Errorf("ServerType = %q, want %q", d.ServerType, "cx33")
+	}
+	if status != "running" {
+		t.Errorf("server status = %q, want running", status)
+	}
+}
+
+func TestResize_StoppedServerStaysOff(t *testing.T) {
+	status := "off"
+	var calls []string
+	d, _ := newTestDriver(t, resizeMux(t, &status, http.StatusCreated, &calls))
+	d.ServerID = 123
+
+	if err := d.Resize("cx33", true); err != nil {
+		t.Fatalf("Resize() error: %v", err)
+	}
+
+	want := []string{"change_type:2"}
+	if strings.Join(calls, ",") != strings.Join(want, ",") {
+		t.Errorf("calls = %v, want %v", calls, want)
+	}
+	if status != "off" {
+		t.Errorf("server status = %q, want off", status)
+	}
+}
+
+func TestResize_ArchitectureMismatch(t *testing.T) {
+	status := "running"
+	var calls []string
+	d, _ := newTestDriver(t, resizeMux(t, &status, http.StatusCreated, &calls))
+	d.ServerID = 123
+
+	err := d.Resize("cax21", false)
+	if err == nil {
+		t.Fatal("expected error for architecture mismatch")
+	}
+	if !strings.Contains(err.Error(), "architecture") {
+		t.Errorf("error = %q, want it to mention 'architecture'", err)
+	}
+	if len(calls) != 0 {
+		t.Errorf("no server actions expected, got %v", calls)
+	}
+	if d.ServerType != defaultServerType {
+		t.Errorf("ServerType = %q, want unchanged %q", d.ServerType, defaultServerType)
+	}
+}
+
+func TestResize_ChangeTypeFails_RestoresPowerState(t *testing.T) {
+	status := "running"
+	var calls []string
+	d, _ := newTestDriver(t, resizeMux(t, &status, http.StatusConflict, &calls))
+	d.ServerID = 123
+
+	err := d.Resize("cx33", false)
+	if err == nil {
+		t.Fatal("expected error when change_type fails")
+	}
+
+	want := []string{"shutdown", "change_type:2", "poweron"}
+	if strings.Join(calls, ",") != strings.Join(want, ",") {
+		t.Errorf("calls = %v, want %v", calls, want)
+	}
+	if status != "running" {
+		t.Errorf("server status = %q, want running after restore", status)
+	}
+	if d.ServerType != defaultServerType {
+		t.Errorf("ServerType = %q, want unchanged %q", d.ServerType, defaultServerType)
+	}
+}
+
+func TestResize_SameType(t *testing.T) {
+	status := "running"
+	var calls []string
+	d, _ := newTestDriver(t, resizeMux(t, &status, http.StatusCreated, &calls))
+	d.ServerID = 123
+
+	if err := d.Resize("cx23", false); err != nil {
+		t.Fatalf("Resize() error: %v", err)
+	}
+	if len(calls) != 0 {
+		t.Errorf("no server actions expected, got %v", calls)
+	}
+}
+
+func TestResize_NoServerID(t *testing.T) {
+	d := NewDriver("test", t.TempDir(), "test")
+	if err := d.Resize("cx33", false); err == nil {
+		t.Fatal("expected error without server ID")
+	}
+}
+
+func TestMachineConfig_LoadSave(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	content := `{
+  "ConfigVersion": 3,
+  "Driver": {"MachineName": "m1", "ServerType": "cx23", "ServerID": 123, "APIToken": "tok"},
+  "DriverName": "hetzner",
+  "HostOptions": {"Driver": "", "Memory": 0},
+  "Name": "m1"
+}`
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := LoadMachineConfig(path, "test")
+	if err != nil {
+		t.Fatalf("LoadMachineConfig() error: %v", err)
+	}
+	if cfg.Driver.ServerID != 123 || cfg.Driver.APIToken != "tok" || cfg.Driver.MachineName != "m1" {
+		t.Errorf("unexpected driver state: %+v", cfg.Driver)
+	}
+
+	cfg.Driver.ServerType = "cx33"
+	if err := cfg.Save(); err != nil {
+		t.Fatalf("Save() error: %v", err)
+	}
+
+	reloaded, err := LoadMachineConfig(path, "test")
+	if err != nil {
+		t.Fatalf("reload error: %v", err)
+	}
+	if reloaded.Driver.ServerType != "cx33" {
+		t.Errorf("ServerType = %q, want %q", reloaded.Driver.ServerType, "cx33")
+	}
+	if _, ok := reloaded.raw["HostOptions"]; !ok {
+		t.Error("HostOptions were not preserved")
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode().Perm() != 0600 {
+		t.Errorf("mode = %v, want 0600", fi.Mode().Perm())
+	}
+}
+
+func TestMachineConfig_MissingDriver(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(path, []byte(`{"Name": "m1"}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadMachineConfig(path, "test"); err == nil {
+		t.Fatal("expected error for config without driver state")
+	}
+}
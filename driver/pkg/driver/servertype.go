@@ -0,0 +1,157 @@
+package driver
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/hetznercloud/hcloud-go/v2/hcloud"
+	"github.com/rancher/machine/libmachine/log"
+)
+
+// hasServerTypeRequirements reports whether the server type should be chosen
+// from requirements instead of taken from ServerType.
+func (d *Driver) hasServerTypeRequirements() bool {
+	return d.MinCores > 0 || d.MinMemory > 0 || d.MinDisk > 0 ||
+		d.CPUType != "" || len(d.Architectures) > 0
+}
+
+// serverTypeRequirements describes the configured requirements for logs and errors.
+func (d *Driver) serverTypeRequirements() string {
+	var parts []string
+	if d.MinCores > 0 {
+		parts = append(parts, fmt.Sprintf(">=%d cores", d.MinCores))
+	}
+	if d.MinMemory > 0 {
+		parts = append(parts, fmt.Sprintf(">=%d GB memory", d.MinMemory))
+	}
+	if d.MinDisk > 0 {
+		parts = append(parts, fmt.Sprintf(">=%d GB disk", d.MinDisk))
+	}
+	if d.CPUType != "" {
+		parts = append(parts, d.CPUType+" CPU")
+	}
+	if len(d.Architectures) > 0 {
+		parts = append(parts, "architecture "+strings.Join(d.Architectures, "/"))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// selectServerType picks the cheapest server type in ServerLocation that
+// satisfies the requirements and is neither deprecated nor unavailable there,
+// and stores it in ServerType.
+func (d *Driver) selectServerType(ctx context.Context) error {
+	all, err := d.getClient().ServerType.All(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to list server types: %w", err)
+	}
+
+	type candidate struct {
+		serverType *hcloud.ServerType
+		monthly    float64
+	}
+	var candidates []candidate
+	for _, st := range all {
+		if !d.serverTypeMatchesRequirements(st) {
+			continue
+		}
+		if !serverTypeAvailableIn(st, d.ServerLocation) || serverTypeDeprecation(st, d.ServerLocation) != nil {
+			continue
+		}
+		pricing, ok := serverTypeLocationPricing(st, d.ServerLocation)
+		if !ok {
+			continue
+		}
+		monthly, err := strconv.ParseFloat(pricing.Monthly.Gross, 64)
+		if err != nil {
+			continue
+		}
+		candidates = append(candidates, candidate{serverType: st, monthly: monthly})
+	}
+	if len(candidates) == 0 {
+		return fmt.Errorf("no server type in %s matches requirements (%s)", d.ServerLocation, d.serverTypeRequirements())
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		if candidates[i].monthly != candidates[j].monthly {
+			return candidates[i].monthly < candidates[j].monthly
+		}
+		return candidates[i].serverType.Name < candidates[j].serverType.Name
+	})
+
+	chosen := candidates[0].serverType
+	log.Infof("Selected server type %q (%d cores, %.0f GB memory, %d GB disk, %s CPU, %s) at %.2f/month gross in %s: "+
+		"cheapest of %d types matching %s",
+		chosen.Name, chosen.Cores, chosen.Memory, chosen.Disk, chosen.CPUType, chosen.Architecture,
+		candidates[0].monthly, d.ServerLocation, len(candidates), d.serverTypeRequirements())
+	d.ServerType = chosen.Name
+	return nil
+}
+
+// serverTypeMatchesRequirements checks the hardware requirements only.
+func (d *Driver) serverTypeMatchesRequirements(st *hcloud.ServerType) bool {
+	if st.Cores < d.MinCores || st.Memory < float32(d.MinMemory) || st.Disk < d.MinDisk {
+		return false
+	}
+	if d.CPUType != "" && string(st.CPUType) != d.CPUType {
+		return false
+	}
+	if len(d.Architectures) > 0 {
+		allowed := false
+		for _, arch := range d.Architectures {
+			if string(st.Architecture) == arch {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return false
+		}
+	}
+	return true
+}
+
+// serverTypeAvailableIn reports whether the server type is offered in the
+// location. Older API responses without per-location data are treated as
+// available; pricing is checked separately.
+func serverTypeAvailableIn(st *hcloud.ServerType, location string) bool {
+	if len(st.Locations) == 0 {
+		return true
+	}
+	for _, loc := range st.Locations {
+		if loc.Location != nil && loc.Location.Name == location {
+			return true
+		}
+	}
+	return false
+}
+
+// serverTypeLocationPricing returns the server type's price in the location.
+func serverTypeLocationPricing(st *hcloud.ServerType, location string) (hcloud.ServerTypeLocationPricing, bool) {
+	for _, p := range st.Pricings {
+		if p.Location != nil && p.Location.Name == location {
+			return p, true
+		}
+	}
+	return hcloud.ServerTypeLocationPricing{}, false
+}
+
+// validateServerTypeRequirementFlags checks the CPU type and architecture flags.
+func validateServerTypeRequirementFlags(cpuType string, architectures []string) error {
+	switch hcloud.CPUType(cpuType) {
+	case "", hcloud.CPUTypeShared, hcloud.CPUTypeDedicated:
+	default:
+		return fmt.Errorf("hetzner-cpu-type must be %q or %q, got %q", hcloud.CPUTypeShared, hcloud.CPUTypeDedicated, cpuType)
+	}
+	for _, arch := range architectures {
+		switch hcloud.Architecture(arch) {
+		case hcloud.ArchitectureX86, hcloud.ArchitectureARM:
+		default:
+			return fmt.Errorf("hetzner-architectures entries must be %q or %q, got %q",
+				hcloud.ArchitectureX86, hcloud.ArchitectureARM, arch)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,152 @@
+package driver
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hetznercloud/hcloud-go/v2/hcloud/schema"
+)
+
+// pricedServerType returns a server type offered in fsn1 at the given monthly gross price.
+func pricedServerType(id int64, name string, cores int, memory float32, disk int, cpuType, arch, monthly string) schema.ServerType {
+	return schema.ServerType{
+		ID:           id,
+		Name:         name,
+		Cores:        cores,
+		Memory:       memory,
+		Disk:         disk,
+		CPUType:      cpuType,
+		Architecture: arch,
+		Prices: []schema.PricingServerTypePrice{{
+			Location:     "fsn1",
+			PriceHourly:  schema.Price{Net: "0.01", Gross: "0.01"},
+			PriceMonthly: schema.Price{Net: monthly, Gross: monthly},
+		}},
+		Locations: []schema.ServerTypeLocation{{ID: 1, Name: "fsn1"}},
+	}
+}
+
+func selectionTypes() []schema.ServerType {
+	notInFsn1 := pricedServerType(6, "ccx13", 2, 8, 80, "dedicated", "x86", "1.00")
+	notInFsn1.Locations = []schema.ServerTypeLocation{{ID: 2, Name: "hel1"}}
+
+	deprecated := pricedServerType(7, "cx32", 4, 8, 80, "shared", "x86", "2.00")
+	deprecated.Locations[0].Deprecation = &schema.DeprecationInfo{
+		Announced:        time.Now().Add(-time.Hour),
+		UnavailableAfter: time.Now().Add(time.Hour),
+	}
+
+	return []schema.ServerType{
+		pricedServerType(1, "cx23", 2, 4, 40, "shared", "x86", "4.00"),
+		pricedServerType(2, "cx33", 4, 8, 80, "shared", "x86", "7.00"),
+		pricedServerType(3, "cax21", 4, 8, 80, "shared", "arm", "6.50"),
+		pricedServerType(4, "ccx23", 4, 16, 160, "dedicated", "x86", "30.00"),
+		pricedServerType(5, "cpx31", 4, 8, 160, "shared", "x86", "15.00"),
+		notInFsn1,
+		deprecated,
+	}
+}
+
+func TestSelectServerType_CheapestMatch(t *testing.T) {
+	d, _ := newTestDriver(t, deprecationMux(selectionTypes()...))
+	d.MinCores = 4
+	d.MinMemory = 8
+
+	if err := d.selectServerType(testCtx(t)); err != nil {
+		t.Fatalf("selectServerType() error: %v", err)
+	}
+	if d.ServerType != "cax21" {
+		t.Errorf("ServerType = %q, want cax21", d.ServerType)
+	}
+}
+
+func TestSelectServerType_ArchitectureAndCPUType(t *testing.T) {
+	d, _ := newTestDriver(t, deprecationMux(selectionTypes()...))
+	d.MinCores = 2
+	d.Architectures = []string{"x86"}
+	d.CPUType = "dedicated"
+
+	if err := d.selectServerType(testCtx(t)); err != nil {
+		t.Fatalf("selectServerType() error: %v", err)
+	}
+	// ccx13 is cheaper but not offered in fsn1
+	if d.ServerType != "ccx23" {
+		t.Errorf("ServerType = %q, want ccx23", d.ServerType)
+	}
+}
+
+func TestSelectServerType_SkipsDeprecated(t *testing.T) {
+	d, _ := newTestDriver(t, deprecationMux(selectionTypes()...))
+	d.MinCores = 4
+	d.Architectures = []string{"x86"}
+	d.CPUType = "shared"
+
+	if err := d.selectServerType(testCtx(t)); err != nil {
+		t.Fatalf("selectServerType() error: %v", err)
+	}
+	// cx32 is cheaper but deprecated in fsn1
+	if d.ServerType != "cx33" {
+		t.Errorf("ServerType = %q, want cx33", d.ServerType)
+	}
+}
+
+func TestSelectServerType_NoMatch(t *testing.T) {
+	d, _ := newTestDriver(t, deprecationMux(selectionTypes()...))
+	d.MinCores = 64
+
+	err := d.selectServerType(testCtx(t))
+	if err == nil {
+		t.Fatal("expected error when no server type matches")
+	}
+	if !strings.Contains(err.Error(), ">=64 cores") {
+		t.Errorf("error = %q, want it to describe the requirements", err)
+	}
+}
+
+func TestPreCreateCheck_SelectsServerTypeFromRequirements(t *testing.T) {
+	mux := http.NewServeMux()
+	types := selectionTypes()
+	mux.HandleFunc("/server_types", func(w http.ResponseWriter, r *http.Request) {
+		name := r.URL.Query().Get("name")
+		var resp []schema.ServerType
+		for _, st := range types {
+			if name == "" || st.Name == name {
+				resp = append(resp, st)
+			}
+		}
+		jsonResponse(w, http.StatusOK, schema.ServerTypeListResponse{ServerTypes: resp})
+	})
+	mux.HandleFunc("/locations", func(w http.ResponseWriter, r *http.Request) {
+		jsonResponse(w, http.StatusOK, schema.LocationListResponse{
+			Locations: []schema.Location{standardLocation()},
+		})
+	})
+	mux.HandleFunc("/images", func(w http.ResponseWriter, r *http.Request) {
+		img := standardImage()
+		img.Architecture = r.URL.Query().Get("architecture")
+		jsonResponse(w, http.StatusOK, schema.ImageListResponse{Images: []schema.Image{img}})
+	})
+
+	d, _ := newTestDriver(t, mux)
+	d.MinMemory = 16
+
+	if err := d.PreCreateCheck(); err != nil {
+		t.Fatalf("PreCreateCheck() error: %v", err)
+	}
+	if d.ServerType != "ccx23" {
+		t.Errorf("ServerType = %q, want ccx23", d.ServerType)
+	}
+}
+
+func TestHasServerTypeRequirements(t *testing.T) {
+	d := NewDriver("test", t.TempDir(), "test")
+	if d.hasServerTypeRequirements() {
+		t.Error("new driver should have no server type requirements")
+	}
+	d.Architectures = []string{"arm"}
+	if !d.hasServerTypeRequirements() {
+		t.Error("architectures should count as a requirement")
+	}
+}
@@ -0,0 +1,134 @@
+package driver
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/hetznercloud/hcloud-go/v2/hcloud"
+	"github.com/rancher/machine/libmachine/log"
+)
+
+const (
+	// snapshotTimeout bounds snapshot creation. Snapshot duration scales with
+	// disk usage, so this is much longer than defaultTimeout.
+	snapshotTimeout = 30 * time.Minute
+
+	// snapshotTimestampLabel marks images created by the driver before
+	// removal. Its value is the creation time as Unix seconds, which is also
+	// used to order snapshots for retention pruning.
+	snapshotTimestampLabel = "snapshot-timestamp"
+
+	// snapshotBackupLabel marks removal snapshots as backups, so they are not
+	// mistaken for images to boot from.
+	snapshotBackupLabel = "backup"
+
+	// snapshotSourceLabel names the machine a removal snapshot was taken of.
+	snapshotSourceLabel = "source-machine"
+)
+
+// snapshotBeforeRemove creates a labelled snapshot of the server and waits
+// for it to finish, then prunes old driver-created snapshots according to
+// SnapshotRetention. Pruning failures are logged but never returned.
+func (d *Driver) snapshotBeforeRemove() error {
+	ctx, cancel := context.WithTimeout(context.Background(), snapshotTimeout)
+	defer cancel()
+
+	server, _, err := d.getClient().Server.GetByID(ctx, d.ServerID)
+	if err != nil {
+		return fmt.Errorf("failed to get server %d for snapshot: %w", d.ServerID, err)
+	}
+	if server == nil {
+		log.Infof("Server %d no longer exists, skipping snapshot", d.ServerID)
+		return nil
+	}
+
+	now := time.Now().UTC()
+	labels := d.snapshotLabels(now)
+	description := fmt.Sprintf("%s before removal (%s)", d.MachineName, now.Format(time.RFC3339))
+
+	log.Infof("Creating snapshot of server %d before removal...", d.ServerID)
+	result, _, err := d.getClient().Server.CreateImage(ctx, server, &hcloud.ServerCreateImageOpts{
+		Type:        hcloud.ImageTypeSnapshot,
+		Description: &description,
+		Labels:      labels,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create snapshot of server %d: %w", d.ServerID, err)
+	}
+	if err := d.waitForAction(ctx, result.Action); err != nil {
+		return fmt.Errorf("snapshot of server %d failed: %w", d.ServerID, err)
+	}
+	log.Infof("Created snapshot %d of server %d", result.Image.ID, d.ServerID)
+
+	if d.SnapshotRetention > 0 {
+		d.pruneSnapshots(ctx)
+	}
+	return nil
+}
+
+// snapshotLabels returns the labels of a removal snapshot. They are not the
+// server's resourceLabels: role labels such as machine, ingress or floating-ip
+// would make the backup match lookups meant for live nodes.
+func (d *Driver) snapshotLabels(now time.Time) map[string]string {
+	labels := map[string]string{
+		"managed-by":           "rancher-machine",
+		snapshotSourceLabel:    d.MachineName,
+		snapshotBackupLabel:    "true",
+		snapshotTimestampLabel: strconv.FormatInt(now.Unix(), 10),
+	}
+	if d.ClusterID != "" {
+		labels["cluster"] = d.ClusterID
+	}
+	if d.Pool != "" {
+		labels["pool"] = d.Pool
+	}
+	return labels
+}
+
+// pruneSnapshots deletes the oldest driver-created snapshots so that at most
+// SnapshotRetention remain. Snapshots are scoped to the cluster when a cluster
+// ID is set, otherwise to this machine.
+func (d *Driver) pruneSnapshots(ctx context.Context) {
+	selector := "managed-by=rancher-machine," + snapshotTimestampLabel
+	if d.ClusterID != "" {
+		selector += ",cluster=" + d.ClusterID
+	} else {
+		selector += "," + snapshotSourceLabel + "=" + d.MachineName
+	}
+
+	images, err := d.getClient().Image.AllWithOpts(ctx, hcloud.ImageListOpts{
+		ListOpts: hcloud.ListOpts{LabelSelector: selector},
+		Type:     []hcloud.ImageType{hcloud.ImageTypeSnapshot},
+	})
+	if err != nil {
+		log.Warnf("Failed to list snapshots for pruning: %v", err)
+		return
+	}
+	if len(images) <= d.SnapshotRetention {
+		return
+	}
+
+	// Newest first; anything past the retention count is deleted.
+	sort.SliceStable(images, func(i, j int) bool {
+		return snapshotTimestamp(images[i]) > snapshotTimestamp(images[j])
+	})
+	for _, image := range images[d.SnapshotRetention:] {
+		if _, err := d.getClient().Image.Delete(ctx, image); err != nil {
+			log.Warnf("Failed to delete old snapshot %d: %v", image.ID, err)
+			continue
+		}
+		log.Infof("Deleted old snapshot %d (%s)", image.ID, image.Description)
+	}
+}
+
+// snapshotTimestamp returns the snapshot's timestamp label, falling back to
+// the image creation time when the label is malformed.
+func snapshotTimestamp(image *hcloud.Image) int64 {
+	if ts, err := strconv.ParseInt(image.Labels[snapshotTimestampLabel], 10, 64); err == nil {
+		return ts
+	}
+	return image.Created.Unix()
+}
@@ -0,0 +1,210 @@
+package driver
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hetznercloud/hcloud-go/v2/hcloud"
+	"github.com/hetznercloud/hcloud-go/v2/hcloud/schema"
+)
+
+// snapshotImage returns a driver-created snapshot image with the given timestamp label.
+func snapshotImage(id int64, ts string) schema.Image {
+	return schema.Image{
+		ID:          id,
+		Type:        "snapshot",
+		Status:      "available",
+		Description: "snapshot",
+		Labels: map[string]string{
+			"managed-by":           "rancher-machine",
+			"cluster":              "test-cluster",
+			snapshotTimestampLabel: ts,
+		},
+	}
+}
+
+// removeWithSnapshotMux registers the endpoints used by Remove with
+// SnapshotOnRemove enabled. createImageStatus controls the create_image response.
+func removeWithSnapshotMux(createImageStatus int, serverDeleted *bool, snapshotLabels *map[string]string) *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/servers/123", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodDelete {
+			*serverDeleted = true
+			jsonResponse(w, http.StatusOK, schema.ServerDeleteResponse{Action: completedAction(10)})
+			return
+		}
+		jsonResponse(w, http.StatusOK, schema.ServerGetResponse{Server: standardServer(123, "running")})
+	})
+	mux.HandleFunc("/servers/123/actions/create_image", func(w http.ResponseWriter, r *http.Request) {
+		if createImageStatus != http.StatusCreated {
+			jsonResponse(w, createImageStatus, schema.ErrorResponse{
+				Error: schema.Error{Code: "locked", Message: "server is locked"},
+			})
+			return
+		}
+		var req schema.ServerActionCreateImageRequest
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		if req.Labels != nil {
+			*snapshotLabels = *req.Labels
+		}
+		jsonResponse(w, http.StatusCreated, schema.ServerActionCreateImageResponse{
+			Action: completedAction(10),
+			Image:  snapshotImage(900, "1"),
+		})
+	})
+	registerActionPoller(mux, 10)
+	return mux
+}
+
+func TestRemove_SnapshotOnRemove(t *testing.T) {
+	serverDeleted := false
+	var labels map[string]string
+
+	d, _ := newTestDriver(t, removeWithSnapshotMux(http.StatusCreated, &serverDeleted, &labels))
+	d.ServerID = 123
+	d.ClusterID = "test-cluster"
+	d.SnapshotOnRemove = true
+
+	if err := d.Remove(); err != nil {
+		t.Fatalf("Remove() error: %v", err)
+	}
+	if !serverDeleted {
+		t.Error("server was not deleted")
+	}
+	if labels["cluster"] != "test-cluster" || labels[snapshotSourceLabel] != "test-machine" || labels[snapshotBackupLabel] != "true" {
+		t.Errorf("snapshot labels = %v, want cluster, source machine and backup labels", labels)
+	}
+	if labels[snapshotTimestampLabel] == "" {
+		t.Errorf("snapshot labels = %v, want %q label", labels, snapshotTimestampLabel)
+	}
+}
+
+func TestRemove_SnapshotFailure_BestEffort(t *testing.T) {
+	serverDeleted := false
+	var labels map[string]string
+
+	d, _ := newTestDriver(t, removeWithSnapshotMux(http.StatusLocked, &serverDeleted, &labels))
+	d.ServerID = 123
+	d.SnapshotOnRemove = true
+
+	if err := d.Remove(); err != nil {
+		t.Fatalf("Remove() error: %v", err)
+	}
+	if !serverDeleted {
+		t.Error("server should be deleted when snapshot is best-effort")
+	}
+}
+
+func TestRemove_SnapshotFailure_Required(t *testing.T) {
+	serverDeleted := false
+	var labels map[string]string
+
+	d, _ := newTestDriver(t, removeWithSnapshotMux(http.StatusLocked, &serverDeleted, &labels))
+	d.ServerID = 123
+	d.SnapshotOnRemove = true
+	d.SnapshotRequired = true
+
+	err := d.Remove()
+	if err == nil {
+		t.Fatal("expected error when required snapshot fails")
+	}
+	if !strings.Contains(err.Error(), "snapshot") {
+		t.Errorf("error = %q, want it to mention 'snapshot'", err)
+	}
+	if serverDeleted {
+		t.Error("server must not be deleted when required snapshot fails")
+	}
+}
+
+func TestSnapshotLabels_OmitRoleLabels(t *testing.T) {
+	d := NewDriver("test-machine", t.TempDir(), "test")
+	d.ClusterID = "test-cluster"
+	d.Pool = "workers"
+	d.IngressLoadBalancer = true
+	d.CreateFloatingIP = true
+
+	labels := d.snapshotLabels(time.Unix(1700000000, 0))
+	want := map[string]string{
+		"managed-by":           "rancher-machine",
+		"cluster":              "test-cluster",
+		"pool":                 "workers",
+		snapshotSourceLabel:    "test-machine",
+		snapshotBackupLabel:    "true",
+		snapshotTimestampLabel: "1700000000",
+	}
+	if len(labels) != len(want) {
+		t.Errorf("snapshot labels = %v, want %v", labels, want)
+	}
+	for k, v := range want {
+		if labels[k] != v {
+			t.Errorf("label %s = %q, want %q", k, labels[k], v)
+		}
+	}
+}
+
+func TestPruneSnapshots_DeletesOldest(t *testing.T) {
+	var deleted []string
+	var selector string
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/images", func(w http.ResponseWriter, r *http.Request) {
+		selector = r.URL.Query().Get("label_selector")
+		jsonResponse(w, http.StatusOK, schema.ImageListResponse{
+			Images: []schema.Image{
+				snapshotImage(1, "100"),
+				snapshotImage(3, "300"),
+				snapshotImage(2, "200"),
+				snapshotImage(4, "400"),
+			},
+		})
+	})
+	mux.HandleFunc("/images/", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodDelete {
+			deleted = append(deleted, strings.TrimPrefix(r.URL.Path, "/images/"))
+			w.WriteHeader(http.StatusNoContent)
+		}
+	})
+
+	d, _ := newTestDriver(t, mux)
+	d.ClusterID = "test-cluster"
+	d.SnapshotRetention = 2
+
+	d.pruneSnapshots(testCtx(t))
+
+	sort.Strings(deleted)
+	if strings.Join(deleted, ",") != "1,2" {
+		t.Errorf("deleted = %v, want [1 2]", deleted)
+	}
+	if !strings.Contains(selector, "cluster=test-cluster") || !strings.Contains(selector, snapshotTimestampLabel) {
+		t.Errorf("label_selector = %q, want cluster and timestamp selectors", selector)
+	}
+}
+
+func TestPruneSnapshots_WithinRetention(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/images", func(w http.ResponseWriter, r *http.Request) {
+		jsonResponse(w, http.StatusOK, schema.ImageListResponse{
+			Images: []schema.Image{snapshotImage(1, "100")},
+		})
+	})
+	mux.HandleFunc("/images/", func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+	})
+
+	d, _ := newTestDriver(t, mux)
+	d.SnapshotRetention = 2
+
+	d.pruneSnapshots(testCtx(t))
+}
+
+func TestSnapshotTimestamp_FallsBackToCreated(t *testing.T) {
+	created := time.Unix(1700000000, 0)
+	image := &hcloud.Image{Created: created, Labels: map[string]string{snapshotTimestampLabel: "bogus"}}
+	if got := snapshotTimestamp(image); got != created.Unix() {
+		t.Errorf("snapshotTimestamp() = %d, want %d", got, created.Unix())
+	}
+}
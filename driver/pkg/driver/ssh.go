@@ -0,0 +1,221 @@
+package driver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/hetznercloud/hcloud-go/v2/hcloud"
+	"github.com/rancher/machine/libmachine/log"
+	gossh "golang.org/x/crypto/ssh"
+)
+
+// sshKeyRefLabelPrefix prefixes the labels that record which machines use a
+// driver-managed SSH key, one label per machine.
+const sshKeyRefLabelPrefix = "rancher-machine/"
+
+// sshUserRe matches the user names useradd accepts by default.
+var sshUserRe = regexp.MustCompile(`^[a-z_][a-z0-9_-]{0,31}$`)
+
+// validateSSHUser checks the --hetzner-ssh-user value.
+func validateSSHUser(user string) error {
+	if !sshUserRe.MatchString(user) {
+		return fmt.Errorf("hetzner-ssh-user %q is not a valid user name", user)
+	}
+	return nil
+}
+
+// sshKeyFingerprint returns the MD5 fingerprint Hetzner identifies SSH keys
+// by, for a public key in authorized_keys format.
+func sshKeyFingerprint(publicKey []byte) (string, error) {
+	key, _, _, _, err := gossh.ParseAuthorizedKey(publicKey)
+	if err != nil {
+		return "", fmt.Errorf("invalid public key: %w", err)
+	}
+	return gossh.FingerprintLegacyMD5(key), nil
+}
+
+// sshKeyRefLabel returns the label recording that this machine uses a key.
+func (d *Driver) sshKeyRefLabel() string {
+	return sshKeyRefLabelPrefix + d.MachineName
+}
+
+// isDriverSSHKey reports whether the driver created the key. Keys uploaded by
+// users are reused but never labelled or deleted.
+func isDriverSSHKey(key *hcloud.SSHKey) bool {
+	return key.Labels["managed-by"] == "rancher-machine"
+}
+
+// hasSSHKeyRefs reports whether a key carries any machine reference label.
+func hasSSHKeyRefs(labels map[string]string) bool {
+	for k := range labels {
+		if strings.HasPrefix(k, sshKeyRefLabelPrefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// ensureSSHKey finds the Hetzner SSH key with the public key's fingerprint or
+// uploads it, and records its ID. Hetzner rejects duplicate public keys, and a
+// retried Create reuses the key pair in the machine store. A driver-managed key
+// is labelled as used by this machine so deleteSSHKey keeps it for others.
+func (d *Driver) ensureSSHKey(ctx context.Context, publicKey []byte) (*hcloud.SSHKey, error) {
+	fingerprint, err := sshKeyFingerprint(publicKey)
+	if err != nil {
+		return nil, err
+	}
+	key, _, err := d.getClient().SSHKey.GetByFingerprint(ctx, fingerprint)
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up SSH key %s: %w", fingerprint, err)
+	}
+
+	if key == nil {
+		name := sshKeyNamePrefix + d.MachineName
+		log.Infof("Uploading SSH key %q...", name)
+		labels := d.resourceLabels()
+		labels[d.sshKeyRefLabel()] = "true"
+		key, _, err = d.getClient().SSHKey.Create(ctx, hcloud.SSHKeyCreateOpts{
+			Name:      name,
+			PublicKey: string(publicKey),
+			Labels:    labels,
+		})
+		if err != nil {
+			if !hcloud.IsError(err, hcloud.ErrorCodeUniquenessError) {
+				return nil, fmt.Errorf("failed to create SSH key: %w", err)
+			}
+			// Uploaded concurrently by another machine with the same key
+			existing, _, findErr := d.getClient().SSHKey.GetByFingerprint(ctx, fingerprint)
+			if findErr != nil || existing == nil {
+				return nil, fmt.Errorf("failed to create SSH key: %w", err)
+			}
+			key = existing
+		} else {
+			d.SSHKeyID = key.ID
+			return key, nil
+		}
+	}
+
+	log.Infof("Reusing SSH key %q (ID=%d, fingerprint %s)", key.Name, key.ID, fingerprint)
+	d.SSHKeyID = key.ID
+	if isDriverSSHKey(key) && key.Labels[d.sshKeyRefLabel()] == "" {
+		labels := make(map[string]string, len(key.Labels)+2)
+		for k, v := range key.Labels {
+			labels[k] = v
+		}
+		// A key without references predates them; keep it referenced for the
+		// machine it was uploaded for
+		if owner, ok := strings.CutPrefix(key.Name, sshKeyNamePrefix); ok && !hasSSHKeyRefs(key.Labels) && owner != d.MachineName {
+			labels[sshKeyRefLabelPrefix+owner] = "true"
+		}
+		labels[d.sshKeyRefLabel()] = "true"
+		if key, _, err = d.getClient().SSHKey.Update(ctx, key, hcloud.SSHKeyUpdateOpts{Labels: labels}); err != nil {
+			return nil, fmt.Errorf("failed to label SSH key %d as used by %q: %w", d.SSHKeyID, d.MachineName, err)
+		}
+	}
+	return key, nil
+}
+
+// parseSSHPrivateKey reads a private key flag value, an inline PEM key or the
+// path of a key file, and parses it. Keys with a passphrase are rejected:
+// rancher-machine cannot prompt for one.
+func parseSSHPrivateKey(flag, value string) ([]byte, gossh.Signer, error) {
+	pemBytes := []byte(value)
+	if !strings.Contains(value, "-----BEGIN") {
+		content, err := os.ReadFile(value)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to read %s file: %w", flag, err)
+		}
+		pemBytes = content
+	}
+	signer, err := gossh.ParsePrivateKey(pemBytes)
+	if err != nil {
+		var missing *gossh.PassphraseMissingError
+		if errors.As(err, &missing) {
+			return nil, nil, fmt.Errorf("%s is protected by a passphrase, which is not supported", flag)
+		}
+		return nil, nil, fmt.Errorf("invalid %s: %w", flag, err)
+	}
+	return pemBytes, signer, nil
+}
+
+// loadSSHPrivateKey reads and parses --hetzner-ssh-private-key.
+func (d *Driver) loadSSHPrivateKey() ([]byte, gossh.Signer, error) {
+	return parseSSHPrivateKey("hetzner-ssh-private-key", d.SSHPrivateKey)
+}
+
+// installSSHPrivateKey writes the provided key pair to the machine store in
+// place of a generated one, so rancher-machine connects with it.
+func (d *Driver) installSSHPrivateKey() error {
+	pemBytes, signer, err := d.loadSSHPrivateKey()
+	if err != nil {
+		return err
+	}
+	path := d.GetSSHKeyPath()
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return fmt.Errorf("failed to create machine store directory: %w", err)
+	}
+	if err := os.WriteFile(path, pemBytes, 0o600); err != nil {
+		return fmt.Errorf("failed to write SSH private key: %w", err)
+	}
+	if err := os.WriteFile(path+".pub", gossh.MarshalAuthorizedKey(signer.PublicKey()), 0o644); err != nil {
+		return fmt.Errorf("failed to write SSH public key: %w", err)
+	}
+	return nil
+}
+
+// checkSSHKeyMatches verifies that the Hetzner SSH key holds the public half
+// of the provided private key.
+func (d *Driver) checkSSHKeyMatches(key *hcloud.SSHKey) error {
+	_, signer, err := d.loadSSHPrivateKey()
+	if err != nil {
+		return err
+	}
+	fingerprint := gossh.FingerprintLegacyMD5(signer.PublicKey())
+	if key.Fingerprint != fingerprint {
+		return fmt.Errorf("SSH key %q (fingerprint %s) does not match hetzner-ssh-private-key (fingerprint %s)", key.Name, key.Fingerprint, fingerprint)
+	}
+	return nil
+}
+
+// sshPortCloudConfig returns a cloud-config snippet that moves sshd to port.
+// Ubuntu 22.10 and later start sshd through ssh.socket, whose port is
+// generated from sshd_config on daemon-reload, so the socket is restarted
+// instead of the service there.
+func sshPortCloudConfig(port int) string {
+	return fmt.Sprintf(`#cloud-config
+write_files:
+  - path: /etc/ssh/sshd_config.d/10-rancher-machine-port.conf
+    content: |
+      Port %d
+runcmd:
+  - systemctl daemon-reload
+  - if systemctl is-enabled --quiet ssh.socket 2>/dev/null; then systemctl restart ssh.socket; else systemctl restart ssh || systemctl restart sshd; fi
+`, port)
+}
+
+// sshUserCloudConfig returns a cloud-config snippet that creates a non-root
+// SSH user with passwordless sudo and the given keys. Hetzner only installs
+// the server's SSH keys for root, which hardened images may not allow to log
+// in.
+func sshUserCloudConfig(user string, keys []*hcloud.SSHKey) string {
+	var b strings.Builder
+	b.WriteString("#cloud-config\nusers:\n")
+	fmt.Fprintf(&b, "  - name: %s\n", user)
+	b.WriteString("    groups: [sudo]\n")
+	b.WriteString("    sudo: \"ALL=(ALL) NOPASSWD:ALL\"\n")
+	b.WriteString("    shell: /bin/bash\n")
+	b.WriteString("    lock_passwd: true\n")
+	b.WriteString("    ssh_authorized_keys:\n")
+	for _, key := range keys {
+		if key != nil && key.PublicKey != "" {
+			fmt.Fprintf(&b, "      - %s\n", strconv.Quote(strings.TrimSpace(key.PublicKey)))
+		}
+	}
+	return b.String()
+}
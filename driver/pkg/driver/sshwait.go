@@ -0,0 +1,232 @@
+package driver
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rancher/machine/libmachine/log"
+	gossh "golang.org/x/crypto/ssh"
+)
+
+const (
+	defaultSSHWaitAttempts  = 60
+	defaultSSHWaitTimeout   = 10  // seconds per connection attempt
+	defaultCloudInitTimeout = 600 // seconds
+)
+
+// sshWaitInterval is the delay between SSH connection attempts. Overridden
+// in tests.
+var sshWaitInterval = 3 * time.Second
+
+// dialTCP opens the connection of an SSH attempt without a bastion.
+// Overridden in tests.
+var dialTCP = net.DialTimeout
+
+var (
+	errSSHPortClosed   = errors.New("SSH port is not reachable")
+	errSSHAuthRejected = errors.New("SSH authentication rejected")
+)
+
+// WaitForSSH waits until the server accepts an SSH login with the machine key
+// and, with --hetzner-wait-for-cloud-init, until cloud-init has finished.
+// Create always calls it.
+// With --hetzner-bastion-host the node is reached through the bastion.
+func WaitForSSH(d *Driver) error {
+	ip, err := d.GetIP()
+	if err != nil {
+		return err
+	}
+	return d.waitForSSH(net.JoinHostPort(ip, strconv.Itoa(d.sshPort())))
+}
+
+// sshPort returns the port sshd listens on at the node. Unlike GetSSHPort it
+// is never the local end of the bastion tunnel.
+func (d *Driver) sshPort() int {
+	if d.SSHPort == 0 {
+		return defaultSSHPort
+	}
+	return d.SSHPort
+}
+
+// sshWaitTimeout returns the timeout of one SSH connection attempt.
+func (d *Driver) sshWaitTimeout() time.Duration {
+	if d.SSHWaitTimeout <= 0 {
+		return defaultSSHWaitTimeout * time.Second
+	}
+	return time.Duration(d.SSHWaitTimeout) * time.Second
+}
+
+// waitForSSH retries an authenticated SSH handshake with addr. A closed port
+// and a rejected key are both expected while the server boots (cloud-init
+// installs the keys), so both are retried; the error of the last attempt
+// says which one it was.
+func (d *Driver) waitForSSH(addr string) error {
+	config, err := d.sshClientConfig()
+	if err != nil {
+		return err
+	}
+	attempts := d.SSHWaitAttempts
+	if attempts <= 0 {
+		attempts = defaultSSHWaitAttempts
+	}
+	log.Infof("Waiting for SSH on %s as %q...", addr, config.User)
+
+	var client *gossh.Client
+	for attempt := 1; ; attempt++ {
+		client, err = d.dialSSH(addr, config)
+		if err == nil {
+			break
+		}
+		if attempt >= attempts {
+			switch {
+			case errors.Is(err, errBastionUnreachable):
+				return fmt.Errorf("could not reach %s after %d attempts: %w", addr, attempts, err)
+			case errors.Is(err, errSSHPortClosed):
+				return fmt.Errorf("SSH on %s did not open after %d attempts: %w", addr, attempts, err)
+			case errors.Is(err, errSSHAuthRejected):
+				return fmt.Errorf("SSH on %s rejected the machine key for user %q after %d attempts: %w", addr, config.User, attempts, err)
+			default:
+				return fmt.Errorf("SSH handshake with %s failed after %d attempts: %w", addr, attempts, err)
+			}
+		}
+		log.Debugf("SSH attempt %d/%d on %s: %v", attempt, attempts, addr, err)
+		time.Sleep(sshWaitInterval)
+	}
+	defer client.Close()
+	log.Infof("SSH on %s is ready", addr)
+
+	if !d.WaitForCloudInit {
+		return nil
+	}
+	return d.waitForCloudInit(client)
+}
+
+// sshClientConfig returns the client configuration for the machine key.
+// Host keys are not verified, like rancher-machine's own SSH client does: the
+// server was just created and its host key is not known yet.
+func (d *Driver) sshClientConfig() (*gossh.ClientConfig, error) {
+	signer, err := d.machineKeySigner()
+	if err != nil {
+		return nil, err
+	}
+	return &gossh.ClientConfig{
+		User:            d.GetSSHUsername(),
+		Auth:            []gossh.AuthMethod{gossh.PublicKeys(signer)},
+		HostKeyCallback: gossh.InsecureIgnoreHostKey(),
+		Timeout:         d.sshWaitTimeout(),
+	}, nil
+}
+
+// machineKeySigner loads the machine key from the machine store.
+func (d *Driver) machineKeySigner() (gossh.Signer, error) {
+	key, err := os.ReadFile(d.GetSSHKeyPath())
+	if err != nil {
+		return nil, fmt.Errorf("failed to read SSH private key: %w", err)
+	}
+	signer, err := gossh.ParsePrivateKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse SSH private key: %w", err)
+	}
+	return signer, nil
+}
+
+// dialSSH connects, through the bastion if one is set, and authenticates,
+// classifying failures as errBastionUnreachable, errSSHPortClosed,
+// errSSHAuthRejected or other handshake errors.
+func (d *Driver) dialSSH(addr string, config *gossh.ClientConfig) (*gossh.Client, error) {
+	var conn net.Conn
+	var err error
+	if d.BastionHost != "" {
+		conn, err = d.dialViaBastion(addr)
+	} else if conn, err = dialTCP("tcp", addr, config.Timeout); err != nil {
+		err = fmt.Errorf("%w: %v", errSSHPortClosed, err)
+	}
+	if err != nil {
+		return nil, err
+	}
+	// The handshake shares the attempt's timeout
+	if err := conn.SetDeadline(time.Now().Add(config.Timeout)); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	// The client reports rejected keys with an untyped error, so failures are
+	// classified by stage: once the host key has been checked the transport
+	// is up, and anything but a dropped connection is the server refusing
+	// the login
+	hostKeyChecked := false
+	attemptConfig := *config
+	attemptConfig.HostKeyCallback = func(hostname string, remote net.Addr, key gossh.PublicKey) error {
+		if err := config.HostKeyCallback(hostname, remote, key); err != nil {
+			return err
+		}
+		hostKeyChecked = true
+		return nil
+	}
+	c, chans, reqs, err := gossh.NewClientConn(conn, addr, &attemptConfig)
+	if err != nil {
+		conn.Close()
+		var netErr net.Error
+		if hostKeyChecked && !errors.Is(err, io.EOF) && !errors.As(err, &netErr) {
+			return nil, fmt.Errorf("%w: %v", errSSHAuthRejected, err)
+		}
+		return nil, err
+	}
+	if err := conn.SetDeadline(time.Time{}); err != nil {
+		c.Close()
+		return nil, err
+	}
+	return gossh.NewClient(c, chans, reqs), nil
+}
+
+// waitForCloudInit runs `cloud-init status --wait` on the server until it
+// reports a result or --hetzner-cloud-init-timeout passes. "done" and (on
+// cloud-init 23.4+) "degraded done", exit code 2, count as finished.
+func (d *Driver) waitForCloudInit(client *gossh.Client) error {
+	timeout := d.CloudInitTimeout
+	if timeout <= 0 {
+		timeout = defaultCloudInitTimeout
+	}
+	log.Infof("Waiting up to %ds for cloud-init to finish...", timeout)
+
+	session, err := client.NewSession()
+	if err != nil {
+		return fmt.Errorf("failed to open SSH session: %w", err)
+	}
+	defer session.Close()
+
+	type result struct {
+		output []byte
+		err    error
+	}
+	done := make(chan result, 1)
+	go func() {
+		output, err := session.CombinedOutput("cloud-init status --wait")
+		done <- result{output, err}
+	}()
+
+	select {
+	case r := <-done:
+		output := strings.TrimSpace(string(r.output))
+		var exitErr *gossh.ExitError
+		switch {
+		case r.err == nil:
+			log.Infof("cloud-init finished")
+			return nil
+		case errors.As(r.err, &exitErr) && exitErr.ExitStatus() == 2:
+			log.Warnf("Warning: cloud-init finished with recoverable errors: %s", output)
+			return nil
+		case errors.As(r.err, &exitErr):
+			return fmt.Errorf("cloud-init failed (exit status %d): %s", exitErr.ExitStatus(), output)
+		default:
+			return fmt.Errorf("failed to check cloud-init status: %w", r.err)
+		}
+	case <-time.After(time.Duration(timeout) * time.Second):
+		return fmt.Errorf("cloud-init did not finish within %ds", timeout)
+	}
+}
@@ -0,0 +1,331 @@
+package driver
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	gossh "golang.org/x/crypto/ssh"
+)
+
+// testSSHServer is an in-process SSH server that accepts one public key and
+// answers exec requests with a fixed output and exit status. With forward set
+// it also forwards TCP connections, like a bastion host.
+type testSSHServer struct {
+	addr       string
+	hostKey    gossh.PublicKey
+	output     string
+	exitStatus uint32
+	hang       chan struct{} // when set, exec requests block until it is closed
+	forward    bool
+
+	mu        sync.Mutex
+	commands  []string
+	forwarded []string
+}
+
+// newTestSSHServer starts a test SSH server that accepts the authorized key,
+// or any key when authorized is nil.
+func newTestSSHServer(t *testing.T, authorized gossh.PublicKey) *testSSHServer {
+	t.Helper()
+	_, hostKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hostSigner, err := gossh.NewSignerFromKey(hostKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := &gossh.ServerConfig{
+		PublicKeyCallback: func(_ gossh.ConnMetadata, key gossh.PublicKey) (*gossh.Permissions, error) {
+			if authorized == nil || bytes.Equal(key.Marshal(), authorized.Marshal()) {
+				return nil, nil
+			}
+			return nil, os.ErrPermission
+		},
+	}
+	config.AddHostKey(hostSigner)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &testSSHServer{addr: listener.Addr().String(), hostKey: hostSigner.PublicKey()}
+	t.Cleanup(func() {
+		listener.Close()
+		if s.hang != nil {
+			close(s.hang)
+		}
+	})
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn, config)
+		}
+	}()
+	return s
+}
+
+func (s *testSSHServer) serve(conn net.Conn, config *gossh.ServerConfig) {
+	_, chans, reqs, err := gossh.NewServerConn(conn, config)
+	if err != nil {
+		conn.Close()
+		return
+	}
+	go gossh.DiscardRequests(reqs)
+	for newChannel := range chans {
+		if newChannel.ChannelType() == "direct-tcpip" && s.forward {
+			go s.forwardChannel(newChannel)
+			continue
+		}
+		if newChannel.ChannelType() != "session" {
+			newChannel.Reject(gossh.UnknownChannelType, "only sessions")
+			continue
+		}
+		channel, requests, err := newChannel.Accept()
+		if err != nil {
+			continue
+		}
+		go func() {
+			defer channel.Close()
+			for req := range requests {
+				if req.Type != "exec" {
+					req.Reply(false, nil)
+					continue
+				}
+				var payload struct{ Command string }
+				gossh.Unmarshal(req.Payload, &payload)
+				s.mu.Lock()
+				s.commands = append(s.commands, payload.Command)
+				s.mu.Unlock()
+				req.Reply(true, nil)
+
+				if s.hang != nil {
+					<-s.hang
+					return
+				}
+				channel.Write([]byte(s.output))
+				status := make([]byte, 4)
+				binary.BigEndian.PutUint32(status, s.exitStatus)
+				channel.SendRequest("exit-status", false, status)
+				return
+			}
+		}()
+	}
+}
+
+func (s *testSSHServer) forwardChannel(newChannel gossh.NewChannel) {
+	var payload struct {
+		Host     string
+		Port     uint32
+		OrigHost string
+		OrigPort uint32
+	}
+	gossh.Unmarshal(newChannel.ExtraData(), &payload)
+	target := net.JoinHostPort(payload.Host, strconv.Itoa(int(payload.Port)))
+	s.mu.Lock()
+	s.forwarded = append(s.forwarded, target)
+	s.mu.Unlock()
+
+	conn, err := net.Dial("tcp", target)
+	if err != nil {
+		newChannel.Reject(gossh.ConnectionFailed, err.Error())
+		return
+	}
+	channel, requests, err := newChannel.Accept()
+	if err != nil {
+		conn.Close()
+		return
+	}
+	go gossh.DiscardRequests(requests)
+	go func() {
+		io.Copy(conn, channel)
+		conn.Close()
+	}()
+	io.Copy(channel, conn)
+	channel.Close()
+}
+
+// sshWaitDriver returns a driver with a machine key in its store, and the
+// key's public half.
+func sshWaitDriver(t *testing.T) (*Driver, gossh.PublicKey) {
+	t.Helper()
+	interval := sshWaitInterval
+	sshWaitInterval = 10 * time.Millisecond
+	t.Cleanup(func() { sshWaitInterval = interval })
+
+	privateKey, _ := testPrivateKey(t)
+	signer, err := gossh.ParsePrivateKey([]byte(privateKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := NewDriver("test", t.TempDir(), "test")
+	d.BaseDriver.SSHKeyPath = filepath.Join(t.TempDir(), "id_ed25519")
+	if err := os.WriteFile(d.GetSSHKeyPath(), []byte(privateKey), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	d.SSHWaitAttempts = 2
+	d.SSHWaitTimeout = 2
+	return d, signer.PublicKey()
+}
+
+// acceptSSH routes direct SSH dials to a test server that accepts any key, so
+// Create can finish its SSH wait against the fixture servers' addresses.
+func acceptSSH(t *testing.T) *testSSHServer {
+	t.Helper()
+	server := newTestSSHServer(t, nil)
+	dial := dialTCP
+	dialTCP = func(network, _ string, timeout time.Duration) (net.Conn, error) {
+		return net.DialTimeout(network, server.addr, timeout)
+	}
+	t.Cleanup(func() { dialTCP = dial })
+	return server
+}
+
+func TestWaitForSSH_Ready(t *testing.T) {
+	d, publicKey := sshWaitDriver(t)
+	server := newTestSSHServer(t, publicKey)
+
+	if err := d.waitForSSH(server.addr); err != nil {
+		t.Fatalf("waitForSSH() error: %v", err)
+	}
+	if len(server.commands) != 0 {
+		t.Errorf("commands = %q, want none without cloud-init waiting", server.commands)
+	}
+}
+
+func TestWaitForSSH_CloudInit(t *testing.T) {
+	tests := []struct {
+		name       string
+		exitStatus uint32
+		output     string
+		wantErr    string
+	}{
+		{"done", 0, "status: done", ""},
+		{"degraded done", 2, "status: done\nrecoverable errors", ""},
+		{"error", 1, "status: error", "cloud-init failed (exit status 1): status: error"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d, publicKey := sshWaitDriver(t)
+			d.WaitForCloudInit = true
+			server := newTestSSHServer(t, publicKey)
+			server.exitStatus, server.output = tt.exitStatus, tt.output
+
+			err := d.waitForSSH(server.addr)
+			if tt.wantErr == "" && err != nil {
+				t.Fatalf("waitForSSH() error: %v", err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Fatalf("waitForSSH() error = %v, want %q", err, tt.wantErr)
+			}
+			if len(server.commands) != 1 || server.commands[0] != "cloud-init status --wait" {
+				t.Errorf("commands = %q, want cloud-init status --wait", server.commands)
+			}
+		})
+	}
+}
+
+func TestWaitForSSH_CloudInitTimeout(t *testing.T) {
+	d, publicKey := sshWaitDriver(t)
+	d.WaitForCloudInit = true
+	d.CloudInitTimeout = 1
+	server := newTestSSHServer(t, publicKey)
+	server.hang = make(chan struct{})
+
+	err := d.waitForSSH(server.addr)
+	if err == nil || !strings.Contains(err.Error(), "cloud-init did not finish within 1s") {
+		t.Fatalf("waitForSSH() error = %v, want cloud-init timeout", err)
+	}
+}
+
+func TestWaitForSSH_AuthRejected(t *testing.T) {
+	d, _ := sshWaitDriver(t)
+	_, otherKey := sshWaitDriver(t)
+	server := newTestSSHServer(t, otherKey)
+
+	err := d.waitForSSH(server.addr)
+	if err == nil || !strings.Contains(err.Error(), "rejected the machine key") {
+		t.Fatalf("waitForSSH() error = %v, want authentication rejected", err)
+	}
+}
+
+func TestWaitForSSH_PortClosed(t *testing.T) {
+	d, _ := sshWaitDriver(t)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := listener.Addr().String()
+	listener.Close()
+
+	err = d.waitForSSH(addr)
+	if err == nil || !strings.Contains(err.Error(), "did not open after 2 attempts") {
+		t.Fatalf("waitForSSH() error = %v, want port closed", err)
+	}
+}
+
+func TestWaitForSSH_ConnectionDropped(t *testing.T) {
+	d, _ := sshWaitDriver(t)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+
+	err = d.waitForSSH(listener.Addr().String())
+	if err == nil || !strings.Contains(err.Error(), "handshake with") {
+		t.Fatalf("waitForSSH() error = %v, want handshake failure", err)
+	}
+}
+
+func TestCreate_SSHNotReady_ReleasesResources(t *testing.T) {
+	dial, interval := dialTCP, sshWaitInterval
+	dialTCP = func(string, string, time.Duration) (net.Conn, error) {
+		return nil, errors.New("connection refused")
+	}
+	sshWaitInterval = time.Millisecond
+	t.Cleanup(func() { dialTCP, sshWaitInterval = dial, interval })
+
+	next := http.NewServeMux()
+	var deleted []int64
+	registerServers(next, &deleted, standardServer(100, "running"))
+	sshKeyDeleted := false
+	d := createDriver(t, createMux(next, &sshKeyDeleted))
+	d.SSHWaitAttempts = 2
+
+	err := d.Create()
+	if err == nil || !strings.Contains(err.Error(), "did not open after 2 attempts") {
+		t.Fatalf("Create() error = %v, want SSH port closed without hetzner-wait-for-cloud-init", err)
+	}
+	if len(deleted) != 1 || deleted[0] != 100 {
+		t.Errorf("deleted servers %v, want [100]", deleted)
+	}
+	if !sshKeyDeleted {
+		t.Error("SSH key should be deleted")
+	}
+}
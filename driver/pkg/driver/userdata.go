@@ -0,0 +1,338 @@
+package driver
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/textproto"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"text/template"
+	"unicode/utf8"
+
+	"github.com/hetznercloud/hcloud-go/v2/hcloud"
+	"github.com/rancher/machine/libmachine/log"
+)
+
+const (
+	// maxUserDataSize is the largest user data the Hetzner API accepts.
+	maxUserDataSize = 32 * 1024
+	// maxDecodedUserDataSize bounds a decoded or decompressed user data
+	// source; composeUserData compresses it below maxUserDataSize again.
+	maxDecodedUserDataSize = 1024 * 1024
+)
+
+// gzipMagic starts every gzip stream.
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// snippetMergeType makes cloud-init append the lists of a driver snippet
+// (runcmd, write_files, ...) to the user's cloud-config instead of
+// replacing them, which is the default for later parts.
+const snippetMergeType = "list(append)+dict(no_replace,recurse_list)+str()"
+
+// userDataContentType returns the MIME type cloud-init uses for a part, based
+// on its first line.
+func userDataContentType(part string) string {
+	switch {
+	case strings.HasPrefix(part, "#cloud-config"):
+		return "text/cloud-config"
+	case strings.HasPrefix(part, "#!"):
+		return "text/x-shellscript"
+	case strings.HasPrefix(part, "#cloud-boothook"):
+		return "text/cloud-boothook"
+	case strings.HasPrefix(part, "#include"):
+		return "text/x-include-url"
+	default:
+		return "text/plain"
+	}
+}
+
+// cloudConfigSnippets returns the cloud-config snippets the driver's options
+// need on the node; keys are the SSH keys the server is created with.
+func (d *Driver) cloudConfigSnippets(keys []*hcloud.SSHKey) []string {
+	var snippets []string
+	if d.SSHUser != "" && d.SSHUser != defaultSSHUser {
+		snippets = append(snippets, sshUserCloudConfig(d.SSHUser, keys))
+	}
+	if d.SSHPort != 0 && d.SSHPort != defaultSSHPort {
+		snippets = append(snippets, sshPortCloudConfig(d.SSHPort))
+	}
+	if d.FloatingIP != "" {
+		snippets = append(snippets, floatingIPCloudConfig(d.FloatingIP))
+	}
+	return snippets
+}
+
+// composeUserData combines the user data (Rancher's bootstrap script) with
+// cloud-config fragments. Without user data a single fragment is used as is;
+// otherwise all are wrapped in a MIME multipart archive, which cloud-init
+// processes part by part whatever format the user data has. Fragments are
+// merged into earlier cloud-config parts with snippetMergeType.
+//
+// When the result exceeds the Hetzner limit, the whole document is gzipped
+// and base64-encoded: cloud-init's Hetzner datasource decodes base64 user
+// data and decompresses it before parsing the archive, so the parts keep
+// their types and Merge-Type headers. User data that is still too large is an
+// error.
+func composeUserData(userData string, fragments ...string) (string, error) {
+	composed, err := buildUserData(userData, fragments)
+	if err != nil || len(composed) <= maxUserDataSize {
+		return composed, err
+	}
+
+	compressed, err := gzipBase64([]byte(composed))
+	if err != nil {
+		return "", fmt.Errorf("failed to compress user data: %w", err)
+	}
+	if len(compressed) > maxUserDataSize {
+		return "", fmt.Errorf("user data is %d bytes (%d bytes compressed), Hetzner accepts at most %d bytes", len(composed), len(compressed), maxUserDataSize)
+	}
+	log.Infof("User data is %d bytes, compressed to %d bytes to fit the %d byte limit", len(composed), len(compressed), maxUserDataSize)
+	return compressed, nil
+}
+
+func buildUserData(userData string, fragments []string) (string, error) {
+	if len(fragments) == 0 {
+		return userData, nil
+	}
+	if userData == "" && len(fragments) == 1 {
+		return fragments[0], nil
+	}
+
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	if userData != "" {
+		if err := writeUserDataPart(w, userData, nil); err != nil {
+			return "", err
+		}
+	}
+	for _, fragment := range fragments {
+		if err := writeUserDataPart(w, fragment, map[string]string{"Merge-Type": snippetMergeType}); err != nil {
+			return "", err
+		}
+	}
+	if err := w.Close(); err != nil {
+		return "", fmt.Errorf("failed to build multipart user data: %w", err)
+	}
+
+	return fmt.Sprintf("Content-Type: multipart/mixed; boundary=\"%s\"\nMIME-Version: 1.0\n\n%s", w.Boundary(), body.String()), nil
+}
+
+func writeUserDataPart(w *multipart.Writer, content string, extra map[string]string) error {
+	header := textproto.MIMEHeader{}
+	header.Set("Content-Type", userDataContentType(content)+"; charset=\"utf-8\"")
+	header.Set("MIME-Version", "1.0")
+	for k, v := range extra {
+		header.Set(k, v)
+	}
+	part, err := w.CreatePart(header)
+	if err != nil {
+		return fmt.Errorf("failed to build multipart user data: %w", err)
+	}
+	if _, err := part.Write([]byte(content)); err != nil {
+		return fmt.Errorf("failed to build multipart user data: %w", err)
+	}
+	return nil
+}
+
+// gzipBase64 compresses data and encodes it as base64.
+func gzipBase64(data []byte) (string, error) {
+	var compressed bytes.Buffer
+	zw, err := gzip.NewWriterLevel(&compressed, gzip.BestCompression)
+	if err != nil {
+		return "", err
+	}
+	if _, err := zw.Write(data); err != nil {
+		return "", err
+	}
+	if err := zw.Close(); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(compressed.Bytes()), nil
+}
+
+// templateData is the data available to templated user data, cloud-config
+// fragments (--hetzner-user-data-template) and --hetzner-reverse-dns.
+type templateData struct {
+	MachineName    string
+	ClusterID      string
+	Pool           string
+	ServerLocation string
+	Location       string // alias of ServerLocation, the name reverse DNS templates used
+	ServerType     string
+	Image          string
+	Networks       []string
+}
+
+// templateData returns the template data of this machine.
+func (d *Driver) templateData() templateData {
+	return templateData{
+		MachineName:    d.MachineName,
+		ClusterID:      d.ClusterID,
+		Pool:           d.Pool,
+		ServerLocation: d.ServerLocation,
+		Location:       d.ServerLocation,
+		ServerType:     d.ServerType,
+		Image:          d.imageName,
+		Networks:       d.Networks,
+	}
+}
+
+// templateImageName returns the resolved image as templates see it: its
+// name, or the ID of a snapshot, which has none.
+func templateImageName(image *hcloud.Image) string {
+	if image.Name != "" {
+		return image.Name
+	}
+	return strconv.FormatInt(image.ID, 10)
+}
+
+// renderUserDataTemplate renders user data or a fragment as a Go template
+// when --hetzner-user-data-template is set; name identifies it in errors.
+func (d *Driver) renderUserDataTemplate(name, text string) (string, error) {
+	if !d.UserDataTemplate {
+		return text, nil
+	}
+	tmpl, err := template.New(name).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return "", fmt.Errorf("invalid %s template: %w", name, err)
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, d.templateData()); err != nil {
+		return "", fmt.Errorf("failed to render %s template: %w", name, err)
+	}
+	return buf.String(), nil
+}
+
+// loadUserData resolves a user data or cloud-config value, which is one of
+//   - file://<path>, or a path: absolute, starting with ./ or ../, or a
+//     single line naming an existing file
+//   - base64:<data>
+//   - inline content
+//
+// Gzip-compressed content is recognised by its magic bytes and decompressed.
+// fromFile reports whether the content was read from a file. Only the source
+// and format are logged, never the content, which may hold secrets.
+func loadUserData(name, value string) (content string, fromFile bool, err error) {
+	data := []byte(value)
+	source := "inline"
+	var format []string
+	switch {
+	case strings.HasPrefix(value, "file://"):
+		path, err := url.PathUnescape(strings.TrimPrefix(strings.TrimPrefix(value, "file://"), "localhost"))
+		if err != nil {
+			return "", false, fmt.Errorf("invalid %s file URL %q: %w", name, value, err)
+		}
+		if data, err = readUserDataFile(name, path); err != nil {
+			return "", false, err
+		}
+		source, fromFile = fmt.Sprintf("file %q", path), true
+	case isUserDataPath(value):
+		if data, err = readUserDataFile(name, value); err != nil {
+			return "", false, err
+		}
+		source, fromFile = fmt.Sprintf("file %q", value), true
+	case strings.HasPrefix(value, "base64:"):
+		encoded := strings.Join(strings.Fields(strings.TrimPrefix(value, "base64:")), "")
+		if data, err = base64.StdEncoding.DecodeString(encoded); err != nil {
+			return "", false, fmt.Errorf("invalid base64 in %s: %w", name, err)
+		}
+		format = append(format, "base64")
+	}
+
+	if bytes.HasPrefix(data, gzipMagic) {
+		if data, err = gunzipUserData(data); err != nil {
+			return "", false, fmt.Errorf("invalid gzip data in %s: %w", name, err)
+		}
+		format = append(format, "gzip")
+	}
+	if len(data) > maxDecodedUserDataSize {
+		return "", false, fmt.Errorf("%s is %d bytes, at most %d bytes are supported", name, len(data), maxDecodedUserDataSize)
+	}
+	if !utf8.Valid(data) {
+		return "", false, fmt.Errorf("%s is not text; binary user data must be gzip-compressed or base64-encoded text", name)
+	}
+
+	if len(format) == 0 {
+		format = append(format, "plain")
+	}
+	log.Infof("Loaded %s from %s (%s, %d bytes, %s)", name, source, strings.Join(format, "+"), len(data), userDataContentType(string(data)))
+	return string(data), fromFile, nil
+}
+
+// isUserDataPath reports whether a value names a file rather than holding
+// inline content. rancher-machine passes its bootstrap script as the
+// absolute path of a temp file.
+func isUserDataPath(value string) bool {
+	for _, prefix := range []string{"/", "./", "../"} {
+		if strings.HasPrefix(value, prefix) {
+			return true
+		}
+	}
+	if value == "" || strings.ContainsAny(value, "\n") {
+		return false
+	}
+	info, err := os.Stat(value)
+	return err == nil && info.Mode().IsRegular()
+}
+
+func readUserDataFile(name, path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s file %q: %w", name, path, err)
+	}
+	return data, nil
+}
+
+// gunzipUserData decompresses data, stopping past maxDecodedUserDataSize so a
+// small archive cannot expand without bound.
+func gunzipUserData(data []byte) ([]byte, error) {
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+	return io.ReadAll(io.LimitReader(zr, maxDecodedUserDataSize+1))
+}
+
+// readUserData returns the user data (see loadUserData). User data read from
+// a file is used verbatim and never templated: rancher-machine passes the
+// bootstrap script that way. Inline user data is rendered with
+// --hetzner-user-data-template.
+func (d *Driver) readUserData() (string, error) {
+	if d.UserData == "" {
+		return "", nil
+	}
+	content, fromFile, err := loadUserData("hetzner-user-data", d.UserData)
+	if err != nil || fromFile {
+		return content, err
+	}
+	return d.renderUserDataTemplate("hetzner-user-data", content)
+}
+
+// readCloudConfigFragments reads the --hetzner-cloud-config fragments (see
+// loadUserData); fragments are always rendered with
+// --hetzner-user-data-template.
+func (d *Driver) readCloudConfigFragments() ([]string, error) {
+	fragments := make([]string, 0, len(d.CloudConfig))
+	for i, value := range d.CloudConfig {
+		name := fmt.Sprintf("hetzner-cloud-config[%d]", i)
+		fragment, _, err := loadUserData(name, value)
+		if err != nil {
+			return nil, err
+		}
+		if fragment, err = d.renderUserDataTemplate(name, fragment); err != nil {
+			return nil, err
+		}
+		if !strings.HasPrefix(fragment, "#cloud-config") {
+			return nil, fmt.Errorf("%s must start with #cloud-config", name)
+		}
+		fragments = append(fragments, fragment)
+	}
+	return fragments, nil
+}
@@ -0,0 +1,388 @@
+package driver
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/rand"
+	"encoding/base64"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/hetznercloud/hcloud-go/v2/hcloud"
+)
+
+type userDataPart struct {
+	header textproto.MIMEHeader
+	body   string
+}
+
+// parseMultipartUserData splits multipart user data into its parts.
+func parseMultipartUserData(t *testing.T, userData string) []userDataPart {
+	t.Helper()
+	contentType, body, ok := strings.Cut(userData, "\n")
+	if !ok {
+		t.Fatalf("user data has no MIME header: %q", userData)
+	}
+	_, params, err := mime.ParseMediaType(strings.TrimPrefix(contentType, "Content-Type: "))
+	if err != nil {
+		t.Fatalf("parse content type: %v", err)
+	}
+	r := multipart.NewReader(strings.NewReader(body), params["boundary"])
+	var parts []userDataPart
+	for {
+		part, err := r.NextPart()
+		if err == io.EOF {
+			return parts
+		}
+		if err != nil {
+			t.Fatalf("read part: %v", err)
+		}
+		content, err := io.ReadAll(part)
+		if err != nil {
+			t.Fatalf("read part body: %v", err)
+		}
+		parts = append(parts, userDataPart{header: part.Header, body: string(content)})
+	}
+}
+
+func TestComposeUserData_SnippetOnly(t *testing.T) {
+	got, err := composeUserData("", "#cloud-config\nruncmd: []\n")
+	if err != nil {
+		t.Fatalf("composeUserData() error: %v", err)
+	}
+	if got != "#cloud-config\nruncmd: []\n" {
+		t.Errorf("composeUserData() = %q, want the snippet unchanged", got)
+	}
+}
+
+func TestComposeUserData_Multipart(t *testing.T) {
+	got, err := composeUserData("#!/bin/bash\necho hello", "#cloud-config\nruncmd:\n  - netplan apply\n")
+	if err != nil {
+		t.Fatalf("composeUserData() error: %v", err)
+	}
+
+	parts := parseMultipartUserData(t, got)
+	if len(parts) != 2 {
+		t.Fatalf("got %d parts, want 2", len(parts))
+	}
+	if ct := parts[0].header.Get("Content-Type"); !strings.HasPrefix(ct, "text/x-shellscript") {
+		t.Errorf("user part Content-Type = %q, want text/x-shellscript", ct)
+	}
+	if parts[0].header.Get("Merge-Type") != "" {
+		t.Error("user part should keep the default merge behaviour")
+	}
+	if ct := parts[1].header.Get("Content-Type"); !strings.HasPrefix(ct, "text/cloud-config") {
+		t.Errorf("snippet Content-Type = %q, want text/cloud-config", ct)
+	}
+	if parts[1].header.Get("Merge-Type") != snippetMergeType {
+		t.Errorf("snippet Merge-Type = %q, want %q", parts[1].header.Get("Merge-Type"), snippetMergeType)
+	}
+	if parts[0].body != "#!/bin/bash\necho hello" {
+		t.Errorf("user part body = %q", parts[0].body)
+	}
+	if !strings.Contains(parts[1].body, "netplan apply") {
+		t.Errorf("snippet body = %q", parts[1].body)
+	}
+}
+
+func TestUserDataContentType(t *testing.T) {
+	tests := map[string]string{
+		"#cloud-config\n":       "text/cloud-config",
+		"#!/bin/sh\n":           "text/x-shellscript",
+		"#cloud-boothook\n":     "text/cloud-boothook",
+		"#include\nhttps://x\n": "text/x-include-url",
+		"plain text":            "text/plain",
+	}
+	for content, want := range tests {
+		if got := userDataContentType(content); got != want {
+			t.Errorf("userDataContentType(%q) = %q, want %q", content, got, want)
+		}
+	}
+}
+
+func TestComposeUserData_CompressesLargeUserData(t *testing.T) {
+	// Compressible, but larger than the Hetzner limit. Like Rancher's
+	// bootstrap, the user data is a cloud-config with its own lists.
+	userData := "#cloud-config\nruncmd:\n" + strings.Repeat("  - echo 'installing rancher system agent'\n", 1500)
+	fragment := "#cloud-config\nruncmd:\n  - sysctl --system\n"
+
+	got, err := composeUserData(userData, fragment)
+	if err != nil {
+		t.Fatalf("composeUserData() error: %v", err)
+	}
+	if len(got) > maxUserDataSize {
+		t.Fatalf("composed user data is %d bytes, want at most %d", len(got), maxUserDataSize)
+	}
+
+	// What cloud-init's Hetzner datasource sees: base64, then gzip
+	data, err := base64.StdEncoding.DecodeString(got)
+	if err != nil {
+		t.Fatalf("decode base64: %v", err)
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("gzip: %v", err)
+	}
+	content, err := io.ReadAll(zr)
+	if err != nil {
+		t.Fatalf("gunzip: %v", err)
+	}
+
+	// The snippet is still a cloud-config part after the user's, merged with
+	// list(append), so its runcmd is appended to the user's runcmd
+	parts := parseMultipartUserData(t, string(content))
+	if len(parts) != 2 {
+		t.Fatalf("got %d parts, want 2", len(parts))
+	}
+	for i, want := range []string{userData, fragment} {
+		if ct := parts[i].header.Get("Content-Type"); !strings.HasPrefix(ct, "text/cloud-config") {
+			t.Errorf("part %d Content-Type = %q, want text/cloud-config", i, ct)
+		}
+		if parts[i].body != want {
+			t.Errorf("part %d is %d bytes, want the original %d bytes", i, len(parts[i].body), len(want))
+		}
+	}
+	if parts[0].header.Get("Merge-Type") != "" {
+		t.Error("user part should keep the default merge behaviour")
+	}
+	if mt := parts[1].header.Get("Merge-Type"); !strings.HasPrefix(mt, "list(append)") {
+		t.Errorf("snippet Merge-Type = %q, want its lists appended", mt)
+	}
+}
+
+func TestComposeUserData_TooLarge(t *testing.T) {
+	// Random data does not compress
+	random := make([]byte, maxUserDataSize)
+	if _, err := rand.Read(random); err != nil {
+		t.Fatal(err)
+	}
+	userData := "#!/bin/bash\n# " + base64.StdEncoding.EncodeToString(random)
+
+	if _, err := composeUserData(userData); err == nil {
+		t.Fatal("expected error for user data above the limit")
+	}
+}
+
+func TestReadCloudConfigFragments(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sysctl.yaml")
+	fileFragment := "#cloud-config\nwrite_files:\n  - path: /etc/sysctl.d/90-rancher.conf\n"
+	if err := os.WriteFile(path, []byte(fileFragment), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	d := NewDriver("test", t.TempDir(), "test")
+	d.CloudConfig = []string{"#cloud-config\npackages:\n  - open-iscsi\n", path}
+	fragments, err := d.readCloudConfigFragments()
+	if err != nil {
+		t.Fatalf("readCloudConfigFragments() error: %v", err)
+	}
+	if len(fragments) != 2 || fragments[0] != d.CloudConfig[0] || fragments[1] != fileFragment {
+		t.Errorf("fragments = %q", fragments)
+	}
+
+	d.CloudConfig = []string{"#!/bin/bash\necho not cloud-config"}
+	if _, err := d.readCloudConfigFragments(); err == nil {
+		t.Error("expected error for a fragment that is not cloud-config")
+	}
+}
+
+func TestBuildServerCreateOpts_CloudConfigFragments(t *testing.T) {
+	d, _ := newTestDriver(t, deprecationMux(standardServerType()))
+	d.UserData = "#!/bin/bash\necho bootstrap"
+	d.CloudConfig = []string{"#cloud-config\npackages:\n  - open-iscsi\n"}
+
+	opts, err := d.buildServerCreateOpts(testCtx(t), &hcloud.SSHKey{ID: 1, PublicKey: "ssh-ed25519 AAAA"}, nil)
+	if err != nil {
+		t.Fatalf("buildServerCreateOpts() error: %v", err)
+	}
+
+	parts := parseMultipartUserData(t, opts.UserData)
+	if len(parts) != 2 {
+		t.Fatalf("got %d user data parts, want bootstrap and fragment", len(parts))
+	}
+	if parts[0].body != d.UserData || parts[1].body != d.CloudConfig[0] {
+		t.Errorf("parts = %q, %q", parts[0].body, parts[1].body)
+	}
+}
+
+func TestRenderUserDataTemplate(t *testing.T) {
+	d := NewDriver("prod-workers-abc12-xyz34", t.TempDir(), "test")
+	d.ClusterID = "prod"
+	d.Pool = "workers"
+	d.ServerLocation = "nbg1"
+	d.ServerType = "cx32"
+	d.imageName = "ubuntu-24.04"
+	d.Networks = []string{"net1", "net2"}
+	d.UserDataTemplate = true
+
+	got, err := d.renderUserDataTemplate("test", "{{.MachineName}}.{{.ClusterID}} {{.Pool}} {{.ServerLocation}} {{.ServerType}} {{.Image}} {{range .Networks}}{{.}};{{end}}")
+	if err != nil {
+		t.Fatalf("renderUserDataTemplate() error: %v", err)
+	}
+	want := "prod-workers-abc12-xyz34.prod workers nbg1 cx32 ubuntu-24.04 net1;net2;"
+	if got != want {
+		t.Errorf("renderUserDataTemplate() = %q, want %q", got, want)
+	}
+
+	if got, err := d.renderUserDataTemplate("test", "{{.Location}}"); err != nil || got != "nbg1" {
+		t.Errorf("renderUserDataTemplate() of the Location alias = %q, %v, want nbg1", got, err)
+	}
+	if _, err := d.renderUserDataTemplate("test", "{{.Hostname}}"); err == nil {
+		t.Error("expected error for an unknown variable")
+	}
+
+	d.UserDataTemplate = false
+	if got, _ := d.renderUserDataTemplate("test", "{{.MachineName}}"); got != "{{.MachineName}}" {
+		t.Errorf("without templating got %q, want the text unchanged", got)
+	}
+}
+
+func TestReadUserData_FileIsNeverTemplated(t *testing.T) {
+	bootstrap := "#!/bin/bash\necho '{{ not a template'"
+	path := filepath.Join(t.TempDir(), "bootstrap.sh")
+	if err := os.WriteFile(path, []byte(bootstrap), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	d := NewDriver("test", t.TempDir(), "test")
+	d.UserDataTemplate = true
+	d.UserData = path
+	got, err := d.readUserData()
+	if err != nil {
+		t.Fatalf("readUserData() error: %v", err)
+	}
+	if got != bootstrap {
+		t.Errorf("readUserData() = %q, want the file unchanged", got)
+	}
+
+	d.UserData = "#cloud-config\nfqdn: {{.MachineName}}.example.com\n"
+	if got, _ := d.readUserData(); got != "#cloud-config\nfqdn: test.example.com\n" {
+		t.Errorf("readUserData() = %q, want rendered inline user data", got)
+	}
+}
+
+func TestPreCreateCheck_InvalidUserDataTemplate(t *testing.T) {
+	d, _ := newTestDriver(t, deprecationMux(standardServerType()))
+	d.UserDataTemplate = true
+	d.CloudConfig = []string{"#cloud-config\nfqdn: {{.Hostname}}\n"}
+
+	err := d.PreCreateCheck()
+	if err == nil || !strings.Contains(err.Error(), "hetzner-cloud-config[0]") {
+		t.Fatalf("PreCreateCheck() error = %v, want cloud-config template error", err)
+	}
+}
+
+func TestPreCreateCheck_RendersResolvedValues(t *testing.T) {
+	d, _ := newTestDriver(t, deprecationMux(standardServerType()))
+	d.ClusterID, d.Pool = "prod", "cp"
+	d.ServerLocation = ""
+	d.ServerLocations = []string{"fsn1"}
+	d.Image = "debian-12"
+	d.ImageMap = map[string]string{"x86": "ubuntu-24.04"}
+	d.UserDataTemplate = true
+	// Fails to render unless the location has been selected
+	d.UserData = "#cloud-config\n# {{if not .ServerLocation}}{{.Unselected}}{{end}}{{.ServerLocation}} {{.Image}}\n"
+
+	if err := d.PreCreateCheck(); err != nil {
+		t.Fatalf("PreCreateCheck() error: %v", err)
+	}
+	got, err := d.readUserData()
+	if err != nil {
+		t.Fatalf("readUserData() error: %v", err)
+	}
+	if got != "#cloud-config\n# fsn1 ubuntu-24.04\n" {
+		t.Errorf("readUserData() = %q, want the selected location and resolved image", got)
+	}
+}
+
+func TestTemplateImageName(t *testing.T) {
+	if got := templateImageName(&hcloud.Image{ID: 42, Name: "ubuntu-24.04"}); got != "ubuntu-24.04" {
+		t.Errorf("templateImageName() of a system image = %q, want its name", got)
+	}
+	if got := templateImageName(&hcloud.Image{ID: 42}); got != "42" {
+		t.Errorf("templateImageName() of a snapshot = %q, want its ID", got)
+	}
+}
+
+func gzipBytes(t *testing.T, data []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestLoadUserData(t *testing.T) {
+	script := "#!/bin/bash\necho bootstrap\n"
+	dir := t.TempDir()
+	plainPath := filepath.Join(dir, "bootstrap.sh")
+	gzipPath := filepath.Join(dir, "bootstrap.sh.gz")
+	if err := os.WriteFile(plainPath, []byte(script), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(gzipPath, gzipBytes(t, []byte(script)), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Chdir(dir)
+
+	tests := []struct {
+		name     string
+		value    string
+		want     string
+		fromFile bool
+	}{
+		{"inline", script, script, false},
+		{"absolute path", plainPath, script, true},
+		{"file URL", "file://" + plainPath, script, true},
+		{"file URL with localhost", "file://localhost" + plainPath, script, true},
+		{"relative path", "./bootstrap.sh", script, true},
+		{"bare file name", "bootstrap.sh", script, true},
+		{"gzip file", gzipPath, script, true},
+		{"base64", "base64:" + base64.StdEncoding.EncodeToString([]byte(script)), script, false},
+		{"base64 with line breaks", "base64:" + base64.StdEncoding.EncodeToString([]byte(script))[:8] + "\n" + base64.StdEncoding.EncodeToString([]byte(script))[8:], script, false},
+		{"base64 gzip", "base64:" + base64.StdEncoding.EncodeToString(gzipBytes(t, []byte(script))), script, false},
+		{"single line that is not a file", "#cloud-config", "#cloud-config", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, fromFile, err := loadUserData("hetzner-user-data", tt.value)
+			if err != nil {
+				t.Fatalf("loadUserData() error: %v", err)
+			}
+			if got != tt.want || fromFile != tt.fromFile {
+				t.Errorf("loadUserData() = %q, %v; want %q, %v", got, fromFile, tt.want, tt.fromFile)
+			}
+		})
+	}
+}
+
+func TestLoadUserData_Errors(t *testing.T) {
+	bomb := gzipBytes(t, make([]byte, maxDecodedUserDataSize+1))
+
+	for name, value := range map[string]string{
+		"missing absolute path": "/nonexistent/rancher-bootstrap.sh",
+		"missing relative path": "./nonexistent.sh",
+		"missing file URL":      "file:///nonexistent/rancher-bootstrap.sh",
+		"invalid base64":        "base64:not*base64",
+		"binary":                "base64:" + base64.StdEncoding.EncodeToString([]byte{0xff, 0xfe, 0x00}),
+		"invalid gzip":          "base64:" + base64.StdEncoding.EncodeToString(append([]byte{}, gzipMagic...)),
+		"too large":             "base64:" + base64.StdEncoding.EncodeToString(bomb),
+	} {
+		t.Run(name, func(t *testing.T) {
+			if _, _, err := loadUserData("hetzner-user-data", value); err == nil {
+				t.Error("expected error")
+			}
+		})
+	}
+}